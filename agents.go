@@ -168,20 +168,24 @@ func GetAgentAvailableBalanceFromAPI(ctx context.Context, eventsURL string, agen
 
 // GetAgentAvailableBalanceAtHeightFromAPI calls the REST API to get the available balance for an agent at a particular epoch
 func GetAgentAvailableBalanceAtHeightFromAPI(ctx context.Context, eventsURL string, agentID uint64, height uint64) (*big.Int, error) {
-	balance := big.NewInt(0)
-
 	txs, err := GetAgentTransactionsFromAPI(ctx, eventsURL, agentID)
 	if err != nil {
 		return nil, err
 	}
+
+	return AvailableBalanceAtHeight(txs, height), nil
+}
+
+// AvailableBalanceAtHeight returns the available balance after the last transaction at or before height
+func AvailableBalanceAtHeight(txs []Transaction, height uint64) *big.Int {
+	balance := big.NewInt(0)
 	for _, tx := range txs {
 		if tx.Height > height {
 			break
 		}
 		balance = tx.AvailableBalance
 	}
-
-	return balance, nil
+	return balance
 }
 
 type TransactionJSON struct {
@@ -358,9 +362,7 @@ func GetAgentEconFromNode(ctx context.Context, address common.Address, height ui
 	}
 
 	blockNumber := big.NewInt(int64(height))
-	q := singleton.PoolsSDK.Query()
-
-	principal, err := q.AgentPrincipal(ctx, address, blockNumber)
+	principal, err := singleton.Chain().AgentPrincipal(ctx, address, blockNumber)
 	if err != nil {
 		return nil, height, err
 	}
@@ -0,0 +1,104 @@
+package invariants
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAgentsFromAPI(t *testing.T) {
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agents, err := GetAgentsFromAPI(ctx, server.URL)
+	assert.Nil(t, err)
+	assert.Len(t, agents, 2)
+
+	agent, err := GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), agent.Miners)
+	assert.Equal(t, 0, agent.PrincipalBalance.Cmp(bigFIL(10000)))
+
+	agent, err = GetAgentFromAPI(ctx, server.URL, 3)
+	assert.Nil(t, err)
+	assert.Nil(t, agent)
+
+	server.SetField("/agent", "id", 2, "availableBalance", "5")
+	agent, err = GetAgentFromAPI(ctx, server.URL, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), agent.AvailableBalance.Int64())
+	agent, err = GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, agent.AvailableBalance.Cmp(bigFIL(1500)))
+}
+
+func TestGetAgentAvailableBalanceAtHeightFromAPI(t *testing.T) {
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	for height, expected := range map[uint64]int64{4300000: 0, 4300100: 10000, 4300499: 10000, 4300500: 1500} {
+		balance, err := GetAgentAvailableBalanceAtHeightFromAPI(ctx, server.URL, 1, height)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, balance.Cmp(bigFIL(expected)), "available balance @%d", height)
+	}
+}
+
+func TestGetAgentEconAndMinersFromAPI(t *testing.T) {
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	econ, err := GetAgentEconFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, econ.Liability.Cmp(bigFIL(10000)))
+
+	miners, err := GetAgentMinersFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	assert.Len(t, miners, 1)
+	assert.Equal(t, "f01234", miners[0].MinerAddr.String())
+
+	balance, err := GetAgentAvailableBalanceFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, balance.AvailableBalanceDB.Cmp(balance.AvailableBalanceNd))
+
+	server.Fail("/agent/*/econ", http.StatusInternalServerError)
+	_, err = GetAgentEconFromAPI(ctx, server.URL, 1)
+	assert.NotNil(t, err)
+	_, err = GetAgentMinersFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	server.Reset()
+	_, err = GetAgentEconFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+}
+
+func TestGetAgentEconFromNode(t *testing.T) {
+	chain := chaintest.New(4300600)
+	chain.Use(t)
+	chain.NullRound(4300101)
+	agent := common.HexToAddress("0x090fc62ec8f5a4f9c326f3043a765288c58d9adb")
+	chain.SetPrincipal(agent, chaintest.Step(big.NewInt(0), 4300102, bigFIL(10000)))
+	ctx := context.Background()
+
+	// The state after the borrow at 4300100 is read from the next tipset, past the
+	// null round
+	econ, height, err := GetAgentEconFromNode(ctx, agent, 4300100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4300102), height)
+	assert.Equal(t, 0, econ.Liability.Cmp(bigFIL(10000)))
+
+	econ, _, err = GetAgentEconFromNode(ctx, agent, 4300099)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, econ.Liability.Sign())
+
+	_, _, err = GetAgentEconFromNode(ctx, agent, 4300600)
+	assert.NotNil(t, err, "the head has no next tipset yet")
+}
@@ -0,0 +1,65 @@
+package invariants
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/glifio/invariants/singleton"
+)
+
+type AgentActorBalanceResult struct {
+	Height       uint64
+	ActorBalance *big.Int
+	WFILBalance  *big.Int
+	LiquidAssets *big.Int
+}
+
+// GetAgentActorBalanceFromNode calls the node to get the raw actor balance of an agent
+// alongside the liquid assets reported by the agent contract at the same height
+func GetAgentActorBalanceFromNode(ctx context.Context, agent *Agent, height uint64) (*AgentActorBalanceResult, uint64, error) {
+	chain := singleton.Chain()
+
+	height, err := getNextEpoch(ctx, height)
+	if err != nil {
+		return nil, height, err
+	}
+
+	// Eth calls at a block number see the state after the tipset at that height
+	// has executed, which is the parent state of the next non-null tipset
+	ts, err := chain.ChainGetTipSetAfterHeight(ctx, abi.ChainEpoch(height+1))
+	if err != nil {
+		return nil, height, err
+	}
+
+	agentAddr, err := DelegatedFromEthAddress(agent.AddressNative)
+	if err != nil {
+		return nil, height, err
+	}
+
+	actor, err := chain.StateGetActor(ctx, agentAddr, ts.Key())
+	if err != nil {
+		return nil, height, err
+	}
+
+	blockNumber := big.NewInt(int64(height))
+
+	wfilBalance, err := chain.WFILBalance(ctx, agent.AddressNative, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	liquidAssets, err := chain.AgentLiquidAssets(ctx, agent.AddressNative, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	result := AgentActorBalanceResult{
+		Height:       height,
+		ActorBalance: actor.Balance.Int,
+		WFILBalance:  wfilBalance,
+		LiquidAssets: liquidAssets,
+	}
+
+	return &result, height, nil
+}
@@ -0,0 +1,158 @@
+package invariants
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/invariants/singleton"
+)
+
+// Predicate reports whether an invariant holds at n, which is an epoch or an index
+type Predicate func(ctx context.Context, n uint64) (bool, error)
+
+// BisectStep is called after each evaluation of the predicate during a bisection
+type BisectStep func(n uint64, passed bool)
+
+// Bisect finds the first n in (good, bad] where pass doesn't hold, given that it
+// holds at good and doesn't hold at bad. It assumes pass is monotonic: once it
+// stops holding it never holds again. If it isn't, the result is a transition
+// from passing to failing, but not necessarily the first.
+func Bisect(ctx context.Context, pass Predicate, good uint64, bad uint64, onStep BisectStep) (lastPass uint64, firstFail uint64, err error) {
+	if good >= bad {
+		return 0, 0, fmt.Errorf("bisect: passing bound %d isn't below failing bound %d", good, bad)
+	}
+
+	for bad-good > 1 {
+		mid := good + (bad-good)/2
+		passed, err := pass(ctx, mid)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bisect @%d: %w", mid, err)
+		}
+		if onStep != nil {
+			onStep(mid, passed)
+		}
+		if passed {
+			good = mid
+		} else {
+			bad = mid
+		}
+	}
+
+	return good, bad, nil
+}
+
+type EpochBisectResult struct {
+	LastPass  uint64
+	FirstFail uint64
+	Tipset    *types.TipSet
+	Messages  []lotusapi.Message
+}
+
+// BisectEpochs finds the first epoch in (good, bad] where pass doesn't hold, and
+// returns the tipset at that epoch along with the messages included in it.
+// The bounds are checked first, and null rounds are never evaluated: they have
+// the same state as the tipset before them.
+func BisectEpochs(ctx context.Context, pass Predicate, good uint64, bad uint64, onStep BisectStep) (*EpochBisectResult, error) {
+	chain := singleton.Chain()
+
+	// The latest non-null epoch at or before n
+	nonNull := func(n uint64) (uint64, error) {
+		ts, err := chain.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(n))
+		if err != nil {
+			return 0, err
+		}
+		return uint64(ts.Height()), nil
+	}
+
+	bad, err := nonNull(bad)
+	if err != nil {
+		return nil, err
+	}
+	if good >= bad {
+		return nil, fmt.Errorf("bisect: passing bound @%d isn't below failing bound @%d", good, bad)
+	}
+
+	passed, err := pass(ctx, good)
+	if err != nil {
+		return nil, fmt.Errorf("bisect @%d: %w", good, err)
+	}
+	if !passed {
+		return nil, fmt.Errorf("bisect: invariant doesn't hold at passing bound @%d", good)
+	}
+	passed, err = pass(ctx, bad)
+	if err != nil {
+		return nil, fmt.Errorf("bisect @%d: %w", bad, err)
+	}
+	if passed {
+		return nil, fmt.Errorf("bisect: invariant holds at failing bound @%d", bad)
+	}
+
+	skipNull := func(ctx context.Context, n uint64) (bool, error) {
+		height, err := nonNull(n)
+		if err != nil {
+			return false, err
+		}
+		if height <= good {
+			// Null rounds since the passing bound
+			return true, nil
+		}
+		return pass(ctx, height)
+	}
+
+	lastPass, firstFail, err := Bisect(ctx, skipNull, good, bad, onStep)
+	if err != nil {
+		return nil, err
+	}
+
+	// Null rounds evaluate the same as the tipset before them, so the first
+	// failing epoch is never a null round
+	ts, err := chain.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(firstFail))
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := chain.ChainGetMessagesInTipset(ctx, ts.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	return &EpochBisectResult{
+		LastPass:  lastPass,
+		FirstFail: firstFail,
+		Tipset:    ts,
+		Messages:  messages,
+	}, nil
+}
+
+// FindPassingEpoch steps back from bad, doubling the distance each time starting
+// at step, until pass holds. It returns the passing epoch and the failing epoch
+// closest to it.
+func FindPassingEpoch(ctx context.Context, pass Predicate, bad uint64, step uint64, onStep BisectStep) (good uint64, closestBad uint64, err error) {
+	closestBad = bad
+	for {
+		if step >= closestBad {
+			good = 0
+		} else {
+			good = closestBad - step
+		}
+
+		passed, err := pass(ctx, good)
+		if err != nil {
+			return 0, 0, fmt.Errorf("search @%d: %w", good, err)
+		}
+		if onStep != nil {
+			onStep(good, passed)
+		}
+		if passed {
+			return good, closestBad, nil
+		}
+		if good == 0 {
+			return 0, 0, fmt.Errorf("no passing epoch found before @%d", bad)
+		}
+		closestBad = good
+		step *= 2
+	}
+}
@@ -0,0 +1,77 @@
+package invariants
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/stretchr/testify/assert"
+)
+
+// failsFrom is a predicate that holds before epoch and never after
+func failsFrom(epoch uint64, evaluated *[]uint64) Predicate {
+	return func(ctx context.Context, n uint64) (bool, error) {
+		*evaluated = append(*evaluated, n)
+		return n < epoch, nil
+	}
+}
+
+func TestBisect(t *testing.T) {
+	ctx := context.Background()
+	var evaluated []uint64
+
+	lastPass, firstFail, err := Bisect(ctx, failsFrom(137, &evaluated), 100, 200, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(136), lastPass)
+	assert.Equal(t, uint64(137), firstFail)
+	assert.LessOrEqual(t, len(evaluated), 7)
+
+	_, _, err = Bisect(ctx, failsFrom(137, &evaluated), 200, 100, nil)
+	assert.NotNil(t, err)
+}
+
+func TestFindPassingEpoch(t *testing.T) {
+	ctx := context.Background()
+	var evaluated []uint64
+
+	good, closestBad, err := FindPassingEpoch(ctx, failsFrom(1000, &evaluated), 1100, 10, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1090, 1070, 1030, 950}, evaluated)
+	assert.Equal(t, uint64(950), good)
+	assert.Equal(t, uint64(1030), closestBad)
+
+	_, _, err = FindPassingEpoch(ctx, failsFrom(0, &evaluated), 1100, 10, nil)
+	assert.NotNil(t, err, "no passing epoch back to genesis")
+}
+
+func TestBisectEpochs(t *testing.T) {
+	chain := chaintest.New(300)
+	chain.Use(t)
+	ctx := context.Background()
+
+	// The invariant breaks at 160, which comes right after a run of null rounds
+	chain.NullRound(155, 156, 157, 158, 159)
+	msg := lotusapi.Message{Message: &types.Message{Method: 2, Value: types.NewInt(1)}}
+	chain.AddMessages(160, msg)
+
+	var evaluated []uint64
+	result, err := BisectEpochs(ctx, failsFrom(160, &evaluated), 100, 250, nil)
+	assert.Nil(t, err)
+	// Null rounds have the state of the tipset before them, so they pass
+	assert.Equal(t, uint64(159), result.LastPass)
+	assert.Equal(t, uint64(160), result.FirstFail)
+	assert.Equal(t, abi.ChainEpoch(160), result.Tipset.Height())
+	assert.Len(t, result.Messages, 1)
+	for _, n := range evaluated {
+		assert.False(t, n >= 155 && n <= 159, "null round @%d evaluated", n)
+	}
+
+	// The bounds must pass and fail
+	_, err = BisectEpochs(ctx, failsFrom(160, &evaluated), 170, 250, nil)
+	assert.NotNil(t, err)
+	_, err = BisectEpochs(ctx, failsFrom(160, &evaluated), 100, 150, nil)
+	assert.NotNil(t, err)
+}
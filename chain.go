@@ -0,0 +1,41 @@
+package invariants
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/invariants/singleton"
+	"github.com/ipfs/go-cid"
+)
+
+type TipSetStateResult struct {
+	Height uint64
+	Key    types.TipSetKey
+	// State root after the tipset has executed
+	StateRoot cid.Cid
+}
+
+// GetTipSetStateFromNode calls the node to get the tipset at height and the state
+// root it produced, which is the parent state root of the next non-null tipset
+func GetTipSetStateFromNode(ctx context.Context, height uint64) (*TipSetStateResult, uint64, error) {
+	lotus := singleton.Lotus()
+
+	ts, err := lotus.Api.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(height), types.EmptyTSK)
+	if err != nil {
+		return nil, height, err
+	}
+	height = uint64(ts.Height())
+
+	execTs, err := lotus.Api.ChainGetTipSetAfterHeight(ctx, ts.Height()+1, types.EmptyTSK)
+	if err != nil {
+		return nil, height, err
+	}
+
+	result := TipSetStateResult{
+		Height:    height,
+		Key:       ts.Key(),
+		StateRoot: execTs.ParentState(),
+	}
+	return &result, height, nil
+}
@@ -0,0 +1,690 @@
+// Package chaintest is an in-memory chain and pools state, used in place of the
+// Lotus node to unit test the invariant checks. Values are defined as functions
+// of epoch, so a test can script exactly when the node state changes.
+package chaintest
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/invariants/singleton"
+	"github.com/ipfs/go-cid"
+)
+
+// ValueFunc returns a value at an epoch
+type ValueFunc func(epoch uint64) *big.Int
+
+// Constant is a value that never changes
+func Constant(value *big.Int) ValueFunc {
+	return func(uint64) *big.Int {
+		return value
+	}
+}
+
+// Step is a value that changes from before to after at epoch at
+func Step(before *big.Int, at uint64, after *big.Int) ValueFunc {
+	return func(epoch uint64) *big.Int {
+		if epoch < at {
+			return before
+		}
+		return after
+	}
+}
+
+// The contract addresses the chain returns, those deployed on mainnet
+var (
+	InfinityPool = common.HexToAddress("0xe764Acf02D8B7c21d2B6A8f0a96C78541e0DC3fd")
+	WFIL         = common.HexToAddress("0x60E1773636CF5E4A227d9AC24F20fEca034ee25A")
+)
+
+// ethTx is a message known by its eth transaction hash
+type ethTx struct {
+	msg   cid.Cid
+	epoch uint64
+}
+
+// Chain implements singleton.ChainBackend. Every epoch up to the head has a
+// tipset unless it's set as a null round. Values that aren't set return an error
+// when queried, so a check reading something the test didn't script fails.
+type Chain struct {
+	mu               sync.Mutex
+	head             uint64
+	fork             int
+	nullRounds       map[uint64]bool
+	messages         map[uint64][]lotusapi.Message
+	receipts         map[cid.Cid]types.MessageReceipt
+	ethTxs           map[common.Hash]ethTx
+	logs             []gethtypes.Log
+	actorIDs         map[address.Address]address.Address
+	actorBalances    map[address.Address]ValueFunc
+	minerInfo        map[address.Address]lotusapi.MinerInfo
+	liquidAssets     map[common.Address]ValueFunc
+	principal        map[common.Address]ValueFunc
+	roles            map[common.Address]singleton.AgentRoles
+	factoryIDs       map[common.Address]uint64
+	status           map[common.Address]singleton.AgentStatus
+	accounts         map[common.Address]abigen.Account
+	liquidationValue map[common.Address]ValueFunc
+	wfilBalances     map[common.Address]ValueFunc
+	writeOffs        []*abigen.InfinityPoolWriteOff
+	ifilSupply       ValueFunc
+	totalAssets      ValueFunc
+	totalBorrowed    ValueFunc
+	poolRate         ValueFunc
+	agentCount       ValueFunc
+	failures         map[string]error
+	calls            []string
+}
+
+var _ singleton.ChainBackend = (*Chain)(nil)
+
+// New creates a chain with its head at head
+func New(head uint64) *Chain {
+	return &Chain{
+		head:             head,
+		nullRounds:       make(map[uint64]bool),
+		messages:         make(map[uint64][]lotusapi.Message),
+		receipts:         make(map[cid.Cid]types.MessageReceipt),
+		ethTxs:           make(map[common.Hash]ethTx),
+		actorIDs:         make(map[address.Address]address.Address),
+		actorBalances:    make(map[address.Address]ValueFunc),
+		minerInfo:        make(map[address.Address]lotusapi.MinerInfo),
+		liquidAssets:     make(map[common.Address]ValueFunc),
+		principal:        make(map[common.Address]ValueFunc),
+		roles:            make(map[common.Address]singleton.AgentRoles),
+		factoryIDs:       make(map[common.Address]uint64),
+		status:           make(map[common.Address]singleton.AgentStatus),
+		accounts:         make(map[common.Address]abigen.Account),
+		liquidationValue: make(map[common.Address]ValueFunc),
+		wfilBalances:     make(map[common.Address]ValueFunc),
+		failures:         make(map[string]error),
+	}
+}
+
+// Use makes c the backend returned by singleton.Chain() until the test ends
+func (c *Chain) Use(t testing.TB) {
+	singleton.UseChain(c)
+	t.Cleanup(func() {
+		singleton.UseChain(nil)
+	})
+}
+
+func (c *Chain) SetHead(head uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.head = head
+}
+
+// NullRound makes epochs null rounds, with no tipset
+func (c *Chain) NullRound(epochs ...uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, epoch := range epochs {
+		c.nullRounds[epoch] = true
+	}
+}
+
+// Reorg replaces every tipset with a new one at the same height, so their keys change
+func (c *Chain) Reorg() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fork++
+}
+
+// AddMessages adds messages to the tipset at epoch
+func (c *Chain) AddMessages(epoch uint64, msgs ...lotusapi.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages[epoch] = append(c.messages[epoch], msgs...)
+}
+
+// SetReceipt sets the receipt of the message msg
+func (c *Chain) SetReceipt(msg cid.Cid, receipt types.MessageReceipt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.receipts[msg] = receipt
+}
+
+// AddLogs makes msg, in the tipset at epoch, the eth transaction txHash emitting
+// logs, which are set in the block at epoch as the FEVM does
+func (c *Chain) AddLogs(epoch uint64, msg cid.Cid, txHash common.Hash, logs ...gethtypes.Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ethTxs[txHash] = ethTx{msg, epoch}
+	for _, log := range logs {
+		log.BlockNumber = epoch
+		log.TxHash = txHash
+		c.logs = append(c.logs, log)
+	}
+}
+
+// SetActorID makes addr resolve to the ID address id
+func (c *Chain) SetActorID(addr address.Address, id address.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.actorIDs[addr] = id
+}
+
+func (c *Chain) SetActorBalance(addr address.Address, fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.actorBalances[addr] = fn
+}
+
+func (c *Chain) SetMinerInfo(miner address.Address, info lotusapi.MinerInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.minerInfo[miner] = info
+}
+
+func (c *Chain) SetLiquidAssets(agent common.Address, fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liquidAssets[agent] = fn
+}
+
+func (c *Chain) SetPrincipal(agent common.Address, fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.principal[agent] = fn
+}
+
+func (c *Chain) SetAgentRoles(agent common.Address, roles singleton.AgentRoles) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roles[agent] = roles
+}
+
+// SetAgentFactoryID sets the ID the agent factory registered agent with
+func (c *Chain) SetAgentFactoryID(agent common.Address, id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.factoryIDs[agent] = id
+}
+
+func (c *Chain) SetAgentStatus(agent common.Address, status singleton.AgentStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status[agent] = status
+}
+
+func (c *Chain) SetAgentAccount(agent common.Address, account abigen.Account) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accounts[agent] = account
+}
+
+func (c *Chain) SetLiquidationValue(agent common.Address, fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liquidationValue[agent] = fn
+}
+
+func (c *Chain) SetWFILBalance(account common.Address, fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wfilBalances[account] = fn
+}
+
+// AddWriteOff logs a write off of agentID by the Infinity Pool at epoch
+func (c *Chain) AddWriteOff(agentID uint64, epoch uint64, writeOff abigen.InfinityPoolWriteOff) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeOff.AgentID = new(big.Int).SetUint64(agentID)
+	writeOff.Raw.BlockNumber = epoch
+	c.writeOffs = append(c.writeOffs, &writeOff)
+}
+
+func (c *Chain) SetIFILSupply(fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ifilSupply = fn
+}
+
+func (c *Chain) SetPoolTotalAssets(fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totalAssets = fn
+}
+
+func (c *Chain) SetPoolTotalBorrowed(fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totalBorrowed = fn
+}
+
+func (c *Chain) SetPoolRate(fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.poolRate = fn
+}
+
+func (c *Chain) SetAgentCount(fn ValueFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agentCount = fn
+}
+
+// Fail makes calls to method (for example "AgentPrincipal") return err, or
+// succeed again if err is nil
+func (c *Chain) Fail(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.failures, method)
+	} else {
+		c.failures[method] = err
+	}
+}
+
+// Calls returns the calls made so far, in order, as "Method@epoch"
+func (c *Chain) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// call records a call and returns the failure set for method, if any
+func (c *Chain) call(method string, epoch uint64) error {
+	c.calls = append(c.calls, fmt.Sprintf("%s@%d", method, epoch))
+	return c.failures[method]
+}
+
+func (c *Chain) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ChainHead", c.head); err != nil {
+		return nil, err
+	}
+	return c.tipSetByHeight(c.head)
+}
+
+func (c *Chain) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ChainGetTipSetByHeight", uint64(height)); err != nil {
+		return nil, err
+	}
+	return c.tipSetByHeight(uint64(height))
+}
+
+func (c *Chain) ChainGetTipSetAfterHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ChainGetTipSetAfterHeight", uint64(height)); err != nil {
+		return nil, err
+	}
+	for epoch := uint64(height); epoch <= c.head; epoch++ {
+		if !c.nullRounds[epoch] {
+			return c.tipSet(epoch)
+		}
+	}
+	return nil, fmt.Errorf("looking for tipset with height greater than head: %d > %d", height, c.head)
+}
+
+func (c *Chain) ChainGetMessagesInTipset(ctx context.Context, tsk types.TipSetKey) ([]lotusapi.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	epoch, err := c.tipSetEpoch(tsk)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.call("ChainGetMessagesInTipset", epoch); err != nil {
+		return nil, err
+	}
+	return append([]lotusapi.Message(nil), c.messages[epoch]...), nil
+}
+
+// ChainGetParentReceipts returns the receipts set for the messages in the parent
+// tipset, recorded as a call at the parent epoch
+func (c *Chain) ChainGetParentReceipts(ctx context.Context, tsk types.TipSetKey) ([]*types.MessageReceipt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	epoch, err := c.parentEpoch(tsk)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.call("ChainGetParentReceipts", epoch); err != nil {
+		return nil, err
+	}
+	receipts := make([]*types.MessageReceipt, 0, len(c.messages[epoch]))
+	for _, msg := range c.messages[epoch] {
+		receipt, ok := c.receipts[msg.Cid]
+		if !ok {
+			return nil, fmt.Errorf("receipt of %v isn't set", msg.Cid)
+		}
+		receipts = append(receipts, &receipt)
+	}
+	return receipts, nil
+}
+
+// EthGetMessageCidByTransactionHash returns the message added with AddLogs for
+// txHash, recorded as a call at its epoch
+func (c *Chain) EthGetMessageCidByTransactionHash(ctx context.Context, txHash common.Hash) (*cid.Cid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, ok := c.ethTxs[txHash]
+	if err := c.call("EthGetMessageCidByTransactionHash", tx.epoch); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &tx.msg, nil
+}
+
+// EthGetTransactionHashByCid returns the transaction hash added with AddLogs for
+// msg, recorded as a call at its epoch
+func (c *Chain) EthGetTransactionHashByCid(ctx context.Context, msg cid.Cid) (*common.Hash, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for txHash, tx := range c.ethTxs {
+		if tx.msg == msg {
+			if err := c.call("EthGetTransactionHashByCid", tx.epoch); err != nil {
+				return nil, err
+			}
+			return &txHash, nil
+		}
+	}
+	if err := c.call("EthGetTransactionHashByCid", 0); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ContractLogs returns the logs added from start to end, recorded as a call at end
+func (c *Chain) ContractLogs(ctx context.Context, contracts []common.Address, start uint64, end uint64) ([]gethtypes.Log, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ContractLogs", end); err != nil {
+		return nil, err
+	}
+	if end > c.head {
+		return nil, fmt.Errorf("block range end %d is after head %d", end, c.head)
+	}
+	logs := make([]gethtypes.Log, 0)
+	for _, log := range c.logs {
+		if log.BlockNumber >= start && log.BlockNumber <= end && slices.Contains(contracts, log.Address) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (c *Chain) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	epoch, err := c.parentEpoch(tsk)
+	if err != nil {
+		return address.Undef, err
+	}
+	if addr.Protocol() == address.ID {
+		return addr, c.call("StateLookupID", epoch)
+	}
+	id, ok := c.actorIDs[addr]
+	return stateValue(c, "StateLookupID", epoch, id, ok)
+}
+
+func (c *Chain) StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	epoch, err := c.parentEpoch(tsk)
+	if err != nil {
+		return lotusapi.MinerInfo{}, err
+	}
+	info, ok := c.minerInfo[miner]
+	return stateValue(c, "StateMinerInfo", epoch, info, ok)
+}
+
+func (c *Chain) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	epoch, err := c.parentEpoch(tsk)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := c.value("StateGetActor", c.actorBalances[addr], new(big.Int).SetUint64(epoch))
+	if err != nil {
+		return nil, err
+	}
+	return &types.Actor{Balance: types.BigInt{Int: balance}}, nil
+}
+
+func (c *Chain) AgentLiquidAssets(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("AgentLiquidAssets", c.liquidAssets[agent], blockNumber)
+}
+
+func (c *Chain) AgentPrincipal(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("AgentPrincipal", c.principal[agent], blockNumber)
+}
+
+func (c *Chain) AgentRoles(ctx context.Context, agent common.Address, blockNumber *big.Int) (*singleton.AgentRoles, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	roles, ok := c.roles[agent]
+	if _, err := stateValue(c, "AgentRoles", blockNumber.Uint64(), roles, ok); err != nil {
+		return nil, err
+	}
+	return &roles, nil
+}
+
+func (c *Chain) AgentFactoryID(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.factoryIDs[agent]
+	if _, err := stateValue(c, "AgentFactoryID", blockNumber.Uint64(), id, ok); err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetUint64(id), nil
+}
+
+func (c *Chain) AgentStatus(ctx context.Context, agent common.Address, agentID uint64, blockNumber *big.Int) (*singleton.AgentStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status, ok := c.status[agent]
+	if _, err := stateValue(c, "AgentStatus", blockNumber.Uint64(), status, ok); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Chain) AgentAccount(ctx context.Context, agent common.Address, blockNumber *big.Int) (abigen.Account, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account, ok := c.accounts[agent]
+	return stateValue(c, "AgentAccount", blockNumber.Uint64(), account, ok)
+}
+
+// AgentLiquidationValue evaluates the liquidation value at the height of ts
+func (c *Chain) AgentLiquidationValue(ctx context.Context, agent common.Address, ts *types.TipSet) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("AgentLiquidationValue", c.liquidationValue[agent], big.NewInt(int64(ts.Height())))
+}
+
+func (c *Chain) WFILBalance(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("WFILBalance", c.wfilBalances[account], blockNumber)
+}
+
+func (c *Chain) IFILSupply(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("IFILSupply", c.ifilSupply, blockNumber)
+}
+
+func (c *Chain) PoolTotalAssets(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("PoolTotalAssets", c.totalAssets, blockNumber)
+}
+
+func (c *Chain) PoolTotalBorrowed(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("PoolTotalBorrowed", c.totalBorrowed, blockNumber)
+}
+
+func (c *Chain) PoolRate(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("PoolRate", c.poolRate, blockNumber)
+}
+
+// PoolWriteOffs returns the write offs added at start to end, recorded as a
+// call at end
+func (c *Chain) PoolWriteOffs(ctx context.Context, start uint64, end uint64) ([]*abigen.InfinityPoolWriteOff, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("PoolWriteOffs", end); err != nil {
+		return nil, err
+	}
+	if end > c.head {
+		return nil, fmt.Errorf("block range end %d is after head %d", end, c.head)
+	}
+	writeOffs := make([]*abigen.InfinityPoolWriteOff, 0)
+	for _, writeOff := range c.writeOffs {
+		if writeOff.Raw.BlockNumber >= start && writeOff.Raw.BlockNumber <= end {
+			writeOffs = append(writeOffs, writeOff)
+		}
+	}
+	return writeOffs, nil
+}
+
+func (c *Chain) AgentCount(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value("AgentCount", c.agentCount, blockNumber)
+}
+
+func (c *Chain) InfinityPoolAddress() common.Address {
+	return InfinityPool
+}
+
+func (c *Chain) WFILAddress() common.Address {
+	return WFIL
+}
+
+// value evaluates fn at blockNumber, which is a tipset height as for the FEVM
+func (c *Chain) value(method string, fn ValueFunc, blockNumber *big.Int) (*big.Int, error) {
+	epoch := blockNumber.Uint64()
+	if _, err := stateValue(c, method, epoch, fn, fn != nil); err != nil {
+		return nil, err
+	}
+	return new(big.Int).Set(fn(epoch)), nil
+}
+
+// stateValue records a call to method at epoch and returns v, or an error if v
+// isn't set or there's no tipset at epoch
+func stateValue[T any](c *Chain, method string, epoch uint64, v T, ok bool) (T, error) {
+	var zero T
+	if err := c.call(method, epoch); err != nil {
+		return zero, err
+	}
+	if !ok {
+		return zero, fmt.Errorf("%s isn't set", method)
+	}
+	if epoch > c.head {
+		return zero, fmt.Errorf("block number %d is after head %d", epoch, c.head)
+	}
+	if c.nullRounds[epoch] {
+		return zero, fmt.Errorf("block number %d is a null round", epoch)
+	}
+	return v, nil
+}
+
+// tipSetEpoch returns the epoch of the tipset with key tsk
+func (c *Chain) tipSetEpoch(tsk types.TipSetKey) (uint64, error) {
+	for epoch := c.head; ; epoch-- {
+		if !c.nullRounds[epoch] {
+			ts, err := c.tipSet(epoch)
+			if err != nil {
+				return 0, err
+			}
+			if ts.Key() == tsk {
+				return epoch, nil
+			}
+		}
+		if epoch == 0 {
+			return 0, fmt.Errorf("tipset %v not found", tsk)
+		}
+	}
+}
+
+// parentEpoch returns the epoch of the parent of the tipset with key tsk, the
+// last one before it that isn't a null round. State reads at tsk see the state
+// after the parent has executed, so they evaluate values at the parent epoch, as
+// eth calls do at its block number.
+func (c *Chain) parentEpoch(tsk types.TipSetKey) (uint64, error) {
+	epoch, err := c.tipSetEpoch(tsk)
+	if err != nil {
+		return 0, err
+	}
+	for epoch > 0 {
+		epoch--
+		if !c.nullRounds[epoch] {
+			return epoch, nil
+		}
+	}
+	return 0, fmt.Errorf("tipset %v has no parent", tsk)
+}
+
+// tipSetByHeight returns the tipset at height, or the one before it for a null round
+func (c *Chain) tipSetByHeight(height uint64) (*types.TipSet, error) {
+	if height > c.head {
+		return nil, fmt.Errorf("looking for tipset with height greater than head: %d > %d", height, c.head)
+	}
+	for epoch := height; ; epoch-- {
+		if !c.nullRounds[epoch] {
+			return c.tipSet(epoch)
+		}
+		if epoch == 0 {
+			return nil, fmt.Errorf("no tipset at or before %d", height)
+		}
+	}
+}
+
+// tipSet builds a single block tipset at epoch, whose CIDs depend only on the
+// epoch and the fork, so the same tipset is returned until Reorg is called
+func (c *Chain) tipSet(epoch uint64) (*types.TipSet, error) {
+	miner, err := address.NewIDAddress(1000)
+	if err != nil {
+		return nil, err
+	}
+	stateRoot, err := fakeCid(fmt.Sprintf("state/%d/%d", c.fork, epoch))
+	if err != nil {
+		return nil, err
+	}
+
+	return types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte(fmt.Sprintf("%d/%d", c.fork, epoch))},
+		ElectionProof:         &types.ElectionProof{VRFProof: []byte{}},
+		ParentWeight:          types.NewInt(epoch),
+		Height:                abi.ChainEpoch(epoch),
+		ParentStateRoot:       stateRoot,
+		ParentMessageReceipts: stateRoot,
+		Messages:              stateRoot,
+		ParentBaseFee:         types.NewInt(100),
+	}})
+}
+
+func fakeCid(s string) (cid.Cid, error) {
+	return abi.CidBuilder.Sum([]byte(s))
+}
@@ -0,0 +1,138 @@
+package chaintest
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/filecoin-project/go-address"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	chain := New(110)
+	chain.NullRound(104, 105)
+
+	head, err := chain.ChainHead(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 110, head.Height())
+
+	// Null rounds resolve to the tipset before them, or after them
+	ts, err := chain.ChainGetTipSetByHeight(ctx, 105)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 103, ts.Height())
+	ts, err = chain.ChainGetTipSetAfterHeight(ctx, 104)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 106, ts.Height())
+
+	_, err = chain.ChainGetTipSetByHeight(ctx, 111)
+	assert.NotNil(t, err)
+	_, err = chain.ChainGetTipSetAfterHeight(ctx, 111)
+	assert.NotNil(t, err)
+
+	// Tipsets only change on a reorg
+	before, _ := chain.ChainGetTipSetByHeight(ctx, 100)
+	again, _ := chain.ChainGetTipSetByHeight(ctx, 100)
+	assert.Equal(t, before.Key(), again.Key())
+	chain.Reorg()
+	after, _ := chain.ChainGetTipSetByHeight(ctx, 100)
+	assert.NotEqual(t, before.Key(), after.Key())
+
+	msgs, err := chain.ChainGetMessagesInTipset(ctx, after.Key())
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	_, err = chain.ChainGetMessagesInTipset(ctx, before.Key())
+	assert.NotNil(t, err)
+}
+
+func TestChainValues(t *testing.T) {
+	ctx := context.Background()
+	chain := New(110)
+	chain.NullRound(105)
+	agent := common.HexToAddress("0x01")
+
+	_, err := chain.AgentPrincipal(ctx, agent, big.NewInt(100))
+	assert.NotNil(t, err, "unset values are an error")
+
+	chain.SetPrincipal(agent, Step(big.NewInt(1), 103, big.NewInt(2)))
+	principal, err := chain.AgentPrincipal(ctx, agent, big.NewInt(102))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), principal.Int64())
+	principal, err = chain.AgentPrincipal(ctx, agent, big.NewInt(103))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), principal.Int64())
+
+	_, err = chain.AgentPrincipal(ctx, agent, big.NewInt(105))
+	assert.NotNil(t, err, "null rounds have no block")
+	_, err = chain.AgentPrincipal(ctx, agent, big.NewInt(111))
+	assert.NotNil(t, err, "blocks after the head don't exist yet")
+
+	chain.SetIFILSupply(Constant(big.NewInt(7)))
+	chain.Fail("IFILSupply", errors.New("boom"))
+	_, err = chain.IFILSupply(ctx, big.NewInt(100))
+	assert.NotNil(t, err)
+	chain.Fail("IFILSupply", nil)
+	supply, err := chain.IFILSupply(ctx, big.NewInt(100))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), supply.Int64())
+
+	assert.Equal(t, []string{
+		"AgentPrincipal@100", "AgentPrincipal@102", "AgentPrincipal@103", "AgentPrincipal@105",
+		"AgentPrincipal@111", "IFILSupply@100", "IFILSupply@100",
+	}, chain.Calls())
+}
+
+func TestChainState(t *testing.T) {
+	ctx := context.Background()
+	chain := New(110)
+	chain.NullRound(104)
+	actor, _ := address.NewIDAddress(1001)
+	delegated, _ := address.NewDelegatedAddress(10, []byte{1, 2, 3})
+
+	ts, err := chain.ChainGetTipSetByHeight(ctx, 105)
+	assert.Nil(t, err)
+	_, err = chain.StateLookupID(ctx, delegated, ts.Key())
+	assert.NotNil(t, err, "unset actors don't resolve")
+	chain.SetActorID(delegated, actor)
+	id, err := chain.StateLookupID(ctx, delegated, ts.Key())
+	assert.Nil(t, err)
+	assert.Equal(t, actor, id)
+	id, err = chain.StateLookupID(ctx, actor, ts.Key())
+	assert.Nil(t, err)
+	assert.Equal(t, actor, id)
+
+	// State at a tipset is the state after its parent, past the null round
+	chain.SetActorBalance(actor, Step(big.NewInt(1), 104, big.NewInt(2)))
+	balance, err := chain.StateGetActor(ctx, actor, ts.Key())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), balance.Balance.Int64())
+	ts, _ = chain.ChainGetTipSetByHeight(ctx, 106)
+	balance, err = chain.StateGetActor(ctx, actor, ts.Key())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), balance.Balance.Int64())
+
+	chain.AddWriteOff(7, 100, abigen.InfinityPoolWriteOff{RecoveredFunds: big.NewInt(1)})
+	chain.AddWriteOff(7, 108, abigen.InfinityPoolWriteOff{RecoveredFunds: big.NewInt(2)})
+	chain.AddWriteOff(8, 101, abigen.InfinityPoolWriteOff{RecoveredFunds: big.NewInt(3)})
+	writeOffs, err := chain.PoolWriteOffs(ctx, 100, 107)
+	assert.Nil(t, err)
+	assert.Len(t, writeOffs, 2)
+	assert.EqualValues(t, 7, writeOffs[0].AgentID.Int64())
+	assert.EqualValues(t, 100, writeOffs[0].Raw.BlockNumber)
+	assert.EqualValues(t, 8, writeOffs[1].AgentID.Int64())
+	_, err = chain.PoolWriteOffs(ctx, 100, 111)
+	assert.NotNil(t, err)
+
+	_, err = chain.AgentRoles(ctx, common.HexToAddress("0x01"), big.NewInt(100))
+	assert.NotNil(t, err, "unset values are an error")
+
+	assert.Equal(t, []string{
+		"ChainGetTipSetByHeight@105", "StateLookupID@103", "StateLookupID@103", "StateLookupID@103",
+		"StateGetActor@103", "ChainGetTipSetByHeight@106", "StateGetActor@105",
+		"PoolWriteOffs@107", "PoolWriteOffs@111", "AgentRoles@100",
+	}, chain.Calls())
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
-	"math/rand"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/glifio/invariants"
 	"github.com/glifio/invariants/singleton"
@@ -16,7 +18,7 @@ import (
 
 // agentBalancesCmd represents the checkAgentBalance command
 var agentBalancesCmd = &cobra.Command{
-	Use:   "agent-balances [agent-id] [--all] [--random <num>] [--epoch <epoch>]",
+	Use:   "agent-balances [agent-id] [--all [--resume]] [--random <num>] [--epoch <epoch>] [--from <epoch> [--to <epoch>] [--step <epochs>]]",
 	Short: "Compare the balances from the API and the node for an agent",
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -26,7 +28,7 @@ var agentBalancesCmd = &cobra.Command{
 
 		err := initSingleton(ctx)
 		if err != nil {
-			log.Fatal(err)
+			fatalInfrastructure(err)
 		}
 
 		epoch, err := cmd.Flags().GetUint64("epoch")
@@ -44,10 +46,39 @@ var agentBalancesCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		var failCount int
+		checkpointed := allAgents && randomAgents == 0 && len(args) == 0 && checkpointRequested(cmd)
+		if checkpointed {
+			epoch, err = resumeEpoch(cmd, epoch)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		var selection *epochSelection
+		if pinBalancesEpoch(cmd, epoch, checkpointed) {
+			selection, err = selectEpoch(ctx, epoch)
+			if err != nil {
+				fatalInfrastructure(err)
+			}
+			epoch = selection.Epoch
+		}
+
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		if !allAgents && randomAgents == 0 {
-			if len(args) != 1 {
+		sweep, err := getSweepRange(cmd, epoch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sweep != nil {
+			if len(args) != 1 || allAgents || randomAgents > 0 {
 				cmd.Usage()
 				return
 			}
@@ -57,67 +88,87 @@ var agentBalancesCmd = &cobra.Command{
 				log.Fatal(err)
 			}
 
-			agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
-			if err != nil {
-				log.Fatal(err)
-			}
+			report := &runReport{}
+			sweepAgentBalance(ctx, report, eventsURL, agentID, sweep)
+			report.Exit("Agent balances test")
+			return
+		}
 
-			failed, err := checkAgentBalance(ctx, eventsURL, epoch, agent)
+		var cp *checkpoint
+		if checkpointed {
+			cp, err = startCheckpoint(cmd, epoch)
 			if err != nil {
 				log.Fatal(err)
 			}
-			if failed {
-				failCount++
-			}
-		} else {
-			if len(args) != 0 {
-				cmd.Usage()
-				return
-			}
+		}
 
-			agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
-			if err != nil {
-				log.Fatal(err)
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if cp != nil {
+				cp.Attach(report)
 			}
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
 
-			if allAgents {
-				if randomAgents > 0 {
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
+
+				failed, err := checkAgentBalanceAt(ctx, os.Stdout, eventsURL, epoch, agent)
+				report.Add(agentTarget(agentID), failed, err)
+			} else {
+				if len(args) != 0 {
 					cmd.Usage()
 					return
 				}
-				for _, agent := range agents {
-					failed, err := checkAgentBalance(ctx, eventsURL, epoch, &agent)
-					if err != nil {
-						log.Fatal(err)
-					}
-					if failed {
-						failCount++
-					}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
 				}
-			} else if randomAgents > 0 {
-				if int(randomAgents) > len(agents) {
-					randomAgents = uint64(len(agents))
+
+				check := func(ctx context.Context, w io.Writer, agent invariants.Agent) (bool, error) {
+					return checkAgentBalanceAt(ctx, w, eventsURL, epoch, &agent)
 				}
-				rand.Shuffle(len(agents), func(i, j int) {
-					agents[i], agents[j] = agents[j], agents[i]
-				})
-				for i := 0; i < int(randomAgents); i++ {
-					agent := agents[i]
-					failed, err := checkAgentBalance(ctx, eventsURL, epoch, &agent)
-					if err != nil {
-						log.Fatal(err)
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
 					}
-					if failed {
-						failCount++
+					name := func(agent invariants.Agent) string {
+						return agentTarget(agent.ID)
+					}
+					runPool(ctx, report, concurrency, remaining(cp, agents, name), name, check)
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
 					}
+					runPool(ctx, report, concurrency, agents, func(agent invariants.Agent) string {
+						return agentTarget(agent.ID)
+					}, check)
+				} else {
+					cmd.Usage()
 				}
-			} else {
-				cmd.Usage()
 			}
+		})
+
+		if cp != nil {
+			cp.Finish(report)
 		}
-		if failCount > 0 {
-			log.Fatal("FAIL: Agent balances test had errors.")
-		}
+		report.Exit("Agent balances test")
 	},
 }
 
@@ -125,10 +176,34 @@ func init() {
 	rootCmd.AddCommand(agentBalancesCmd)
 	agentBalancesCmd.Flags().Uint64("epoch", 0, "Check at epoch")
 	agentBalancesCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(agentBalancesCmd)
 	agentBalancesCmd.Flags().Bool("all", false, "Check all agents")
+	agentBalancesCmd.Flags().Int("concurrency", 1, "Number of agents to check in parallel")
+	addSweepFlags(agentBalancesCmd)
+	addCheckpointFlags(agentBalancesCmd)
 }
 
-func checkAgentBalance(ctx context.Context, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
+// pinBalancesEpoch reports whether agent-balances checks at a selected epoch.
+// Without one, the latest balances are compared by the API itself, which a
+// checkpointed run can't do: --resume must check the remaining agents at the
+// same epoch as the agents already checked.
+func pinBalancesEpoch(cmd *cobra.Command, epoch uint64, checkpointed bool) bool {
+	return epoch != 0 || checkpointed || rootCmd.PersistentFlags().Changed("epoch-policy") ||
+		cmd.Flags().Changed("from") || rootCmd.PersistentFlags().Changed("bisect")
+}
+
+// checkAgentBalanceAt checks the balance of an agent, bisecting on failure when
+// checking at an epoch rather than the latest balances
+func checkAgentBalanceAt(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent) (bool, error) {
+	if epoch == 0 {
+		return checkAgentBalance(ctx, w, eventsURL, epoch, agent)
+	}
+	return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return checkAgentBalance(ctx, w, eventsURL, epoch, agent)
+	})
+}
+
+func checkAgentBalance(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
 	agentID := agent.ID
 	if epoch == 0 {
 		availableBalanceResult, err := invariants.GetAgentAvailableBalanceFromAPI(ctx, eventsURL, agentID)
@@ -137,14 +212,18 @@ func checkAgentBalance(ctx context.Context, eventsURL string, epoch uint64, agen
 		}
 		// Mutate for testing
 		// availableBalanceResult.AvailableBalanceDB = big.NewInt(1234)
-		if availableBalanceResult.AvailableBalanceDB.Cmp(availableBalanceResult.AvailableBalanceNd) == 0 {
-			fmt.Printf("Agent %d: Success, latest available balances match: %v\n", agentID, availableBalanceResult.AvailableBalanceDB)
+		ok, tol := compareValues(ctx, "agent-balances", "available_balance", availableBalanceResult.AvailableBalanceDB, availableBalanceResult.AvailableBalanceNd)
+		if ok {
+			fmt.Fprintf(w, "Agent %d: Success, latest available balances match: %v\n", agentID, availableBalanceResult.AvailableBalanceDB)
 			return false, nil
 		}
-		fmt.Printf("Agent %d: Error, latest available balance from REST API doesn't match node.\n", agentID)
-		fmt.Printf("  Node: %v\n", availableBalanceResult.AvailableBalanceNd)
-		fmt.Printf("   API: %v\n", availableBalanceResult.AvailableBalanceDB)
-		examineTransactionHistory(ctx, eventsURL, agent)
+		fmt.Fprintf(w, "Agent %d: Error, latest available balance from REST API doesn't match node (tolerance: %v).\n", agentID, tol)
+		fmt.Fprintf(w, "  Node: %v\n", availableBalanceResult.AvailableBalanceNd)
+		fmt.Fprintf(w, "   API: %v\n", availableBalanceResult.AvailableBalanceDB)
+		err = examineTransactionHistory(ctx, w, eventsURL, agent)
+		if err != nil {
+			fmt.Fprintf(w, "Transaction history: Error, %v\n", err)
+		}
 	} else {
 		availableBalance, err := invariants.GetAgentAvailableBalanceAtHeightFromAPI(ctx, eventsURL, agentID, epoch)
 		if err != nil {
@@ -161,182 +240,275 @@ func checkAgentBalance(ctx context.Context, eventsURL string, epoch uint64, agen
 			return true, err
 		}
 
-		if availableBalance.Cmp(liquidAssets) == 0 {
-			fmt.Printf("Agent %d @%d: Success, latest available balances match: %v\n", agentID, epoch, availableBalance)
+		ok, tol := compareValues(ctx, "agent-balances", "available_balance", availableBalance, liquidAssets)
+		if ok {
+			fmt.Fprintf(w, "Agent %d @%d: Success, latest available balances match: %v\n", agentID, epoch, availableBalance)
 			return false, nil
 		}
-		fmt.Printf("Agent %d @%d: Error, available balance from REST API doesn't match node.\n", agentID, epoch)
-		fmt.Printf("  Node: %v\n", liquidAssets)
-		fmt.Printf("   API: %v\n", availableBalance)
+		fmt.Fprintf(w, "Agent %d @%d: Error, available balance from REST API doesn't match node (tolerance: %v).\n", agentID, epoch, tol)
+		fmt.Fprintf(w, "  Node: %v\n", liquidAssets)
+		fmt.Fprintf(w, "   API: %v\n", availableBalance)
 	}
 	return true, nil
 }
 
-func examineTransactionHistory(ctx context.Context, eventsURL string, agent *invariants.Agent) {
+func sweepAgentBalance(ctx context.Context, report *runReport, eventsURL string, agentID uint64, sweep *sweepRange) {
+	agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	txs, err := invariants.GetAgentTransactionsFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	fmt.Printf("Agent %d: Sweeping available balance @%d to @%d\n", agentID, sweep.From, sweep.To)
+	sweepEpochs(ctx, os.Stdout, report, agentTarget(agentID), sweep, func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+		liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, epoch)
+		if err != nil {
+			return nil, err
+		}
+		return []sweepValue{
+			newSweepValue("agent-balances", "available_balance", "availableBalance", invariants.AvailableBalanceAtHeight(txs, epoch), liquidAssets),
+		}, nil
+	}, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return checkAgentBalance(ctx, w, eventsURL, epoch, agent)
+	})
+}
+
+// examineTransactionHistory looks for the first transaction from the REST API
+// whose available balance doesn't match the node. It's diagnostic, so its errors
+// are reported by the caller without changing the check's result.
+func examineTransactionHistory(ctx context.Context, w io.Writer, eventsURL string, agent *invariants.Agent) error {
 	agentID := agent.ID
-	fmt.Println("Examining transaction history...")
+	fmt.Fprintln(w, "Examining transaction history...")
 	txs, err := invariants.GetAgentTransactionsFromAPI(ctx, eventsURL, agentID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	fmt.Printf("%d transactions retrieved from REST API\n", len(txs))
+	fmt.Fprintf(w, "%d transactions retrieved from REST API\n", len(txs))
 	if len(txs) == 0 {
-		fmt.Println("No transactions in db.")
+		fmt.Fprintln(w, "No transactions in db.")
 		txs = append(txs, invariants.Transaction{Height: agent.Height, AvailableBalance: big.NewInt(0)})
 		height, err := getHeadEpoch(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		height = height - 2
 		liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, height)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		txs = append(txs, invariants.Transaction{Height: height, AvailableBalance: liquidAssets})
-		binarySearch(ctx, agent, txs, 0, 1)
+		return binarySearch(ctx, w, agent, txs, 0, 1)
 	} else {
 		// First
 		tx := txs[0]
 		firstIdx := 0
-		fmt.Printf("First tx (idx:0) @%d: ", tx.Height)
+		fmt.Fprintf(w, "First tx (idx:0) @%d: ", tx.Height)
 		liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, tx.Height)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		if tx.AvailableBalance.Cmp(liquidAssets) == 0 {
-			fmt.Printf("Matches: %v\n", liquidAssets)
+		ok, tol := compareValues(ctx, "agent-balances", "available_balance", tx.AvailableBalance, liquidAssets)
+		if ok {
+			fmt.Fprintf(w, "Matches: %v\n", liquidAssets)
 		} else {
-			fmt.Printf("Mismatch! Node: %v API: %v\n", liquidAssets, tx.AvailableBalance)
+			fmt.Fprintf(w, "Mismatch! Node: %v API: %v (tolerance: %v)\n", liquidAssets, tx.AvailableBalance, tol)
 			firstTx := invariants.Transaction{Height: agent.Height, AvailableBalance: big.NewInt(0)}
 			txs = append([]invariants.Transaction{firstTx}, txs...)
-			binarySearch(ctx, agent, txs, 0, 1)
-			return
+			return binarySearch(ctx, w, agent, txs, 0, 1)
 		}
 
 		// Last
 		if len(txs) == 1 {
-			fmt.Println("Only one transaction in db.")
+			fmt.Fprintln(w, "Only one transaction in db.")
 			height, err := getHeadEpoch(ctx)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			height = height - 3
 			liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, height)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			txs = append(txs, invariants.Transaction{Height: height, AvailableBalance: liquidAssets})
-			binarySearch(ctx, agent, txs, 0, 1)
-			return
+			return binarySearch(ctx, w, agent, txs, 0, 1)
 		}
 		idx := len(txs) - 1
 		tx = txs[idx]
 		lastIdx := idx
-		fmt.Printf("Last tx (idx:%d) @%d: ", idx, tx.Height)
+		fmt.Fprintf(w, "Last tx (idx:%d) @%d: ", idx, tx.Height)
 		liquidAssets, err = getLiquidAssetsAtHeight(ctx, agent, tx.Height)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		if tx.AvailableBalance.Cmp(liquidAssets) == 0 {
-			fmt.Printf("Matches: %v\n", liquidAssets)
+		ok, tol = compareValues(ctx, "agent-balances", "available_balance", tx.AvailableBalance, liquidAssets)
+		if ok {
+			fmt.Fprintf(w, "Matches: %v\n", liquidAssets)
 			// Probably missing a transaction beyond last epoch in database
 			latestHeight, err := getHeadEpoch(ctx)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			txs = append(txs, invariants.Transaction{Height: latestHeight - 1})
-			binarySearch(ctx, agent, txs, idx, len(txs)-1)
-			return
+			return binarySearch(ctx, w, agent, txs, idx, len(txs)-1)
 		} else {
-			fmt.Printf("Mismatch! Node: %v API: %v\n", liquidAssets, tx.AvailableBalance)
-			binarySearch(ctx, agent, txs, firstIdx, lastIdx)
+			fmt.Fprintf(w, "Mismatch! Node: %v API: %v (tolerance: %v)\n", liquidAssets, tx.AvailableBalance, tol)
+			return binarySearch(ctx, w, agent, txs, firstIdx, lastIdx)
 		}
 	}
 }
 
 func binarySearch(
 	ctx context.Context,
+	w io.Writer,
 	agent *invariants.Agent,
 	txs []invariants.Transaction,
 	goodIdx int,
 	badIdx int,
-) {
-	fmt.Printf("Binary searching between %d and %d\n", goodIdx, badIdx)
-	searchIdx := (goodIdx + badIdx) / 2
-	if searchIdx == goodIdx || searchIdx == badIdx {
-		fmt.Printf("Last good tx via API (idx: %d) @%d: %v\n", goodIdx, txs[goodIdx].Height, txs[goodIdx].AvailableBalance)
-		fmt.Printf("First bad tx via API (idx: %d) @%d\n", badIdx, txs[badIdx].Height)
-		findBalanceTransitions(ctx, agent, txs[goodIdx], txs[badIdx])
-		return
+) error {
+	fmt.Fprintf(w, "Binary searching between %d and %d\n", goodIdx, badIdx)
+	matches := func(ctx context.Context, idx uint64) (bool, error) {
+		tx := txs[idx]
+		fmt.Fprintf(w, "Tx (idx:%d) @%d: ", idx, tx.Height)
+		liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, tx.Height)
+		if err != nil {
+			return false, err
+		}
+		ok, tol := compareValues(ctx, "agent-balances", "available_balance", tx.AvailableBalance, liquidAssets)
+		if ok {
+			fmt.Fprintf(w, "Matches: %v\n", liquidAssets)
+			return true, nil
+		}
+		fmt.Fprintf(w, "Mismatch! Node: %v API: %v (tolerance: %v)\n", liquidAssets, tx.AvailableBalance, tol)
+		return false, nil
 	}
-	tx := txs[searchIdx]
-	fmt.Printf("Tx (idx:%d) @%d: ", searchIdx, tx.Height)
-	liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, tx.Height)
+
+	good, bad, err := invariants.Bisect(ctx, matches, uint64(goodIdx), uint64(badIdx), nil)
 	if err != nil {
-		log.Fatal(err)
-	}
-	if tx.AvailableBalance.Cmp(liquidAssets) == 0 {
-		fmt.Printf("Matches: %v\n", liquidAssets)
-		binarySearch(ctx, agent, txs, searchIdx, badIdx)
-	} else {
-		fmt.Printf("Mismatch! Node: %v API: %v\n", liquidAssets, tx.AvailableBalance)
-		binarySearch(ctx, agent, txs, goodIdx, searchIdx)
+		return err
 	}
+	fmt.Fprintf(w, "Last good tx via API (idx: %d) @%d: %v\n", good, txs[good].Height, txs[good].AvailableBalance)
+	fmt.Fprintf(w, "First bad tx via API (idx: %d) @%d\n", bad, txs[bad].Height)
+	return findBalanceTransitions(ctx, w, agent, txs, txs[good], txs[bad])
 }
 
 func findBalanceTransitions(
 	ctx context.Context,
+	w io.Writer,
 	agent *invariants.Agent,
+	txs []invariants.Transaction,
 	goodTx invariants.Transaction,
 	badTx invariants.Transaction,
-) {
-	fmt.Printf("Looking for interim balance transitions on node for agent %d...\n", agent.ID)
-	fmt.Printf("From %d to %d\n", goodTx.Height, badTx.Height)
+) error {
+	fmt.Fprintf(w, "Looking for interim balance transitions on node for agent %d...\n", agent.ID)
+	fmt.Fprintf(w, "From %d to %d\n", goodTx.Height, badTx.Height)
 	height := goodTx.Height
 	balance := goodTx.AvailableBalance
 	var err error
 	for {
-		fmt.Printf("%d: %v\n", height, balance)
-		height, balance, err = findNextBalanceTransition(ctx, agent, height+1, balance, badTx.Height-1)
+		fmt.Fprintf(w, "%d: %v\n", height, balance)
+		height, balance, err = findNextBalanceTransition(ctx, w, agent, height, balance, badTx.Height-1)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		if height == 0 {
 			break
 		}
+		explainBalanceTransition(ctx, w, agent, txs, height)
 	}
+	return nil
 }
 
+// explainBalanceTransition prints the messages at height that touch the agent, the
+// pool or WFIL, and whether the API has a matching transaction for each
+func explainBalanceTransition(
+	ctx context.Context,
+	w io.Writer,
+	agent *invariants.Agent,
+	txs []invariants.Transaction,
+	height uint64,
+) {
+	msgs, height, err := invariants.GetAgentMessagesFromNode(ctx, agent, height)
+	if err != nil {
+		fmt.Fprintf(w, "  Couldn't get messages @%d: %v\n", height, err)
+		return
+	}
+	if len(msgs) == 0 {
+		fmt.Fprintf(w, "  No messages touching the agent @%d\n", height)
+		return
+	}
+
+	for _, msg := range msgs {
+		call := msg.Call
+		if call == "" {
+			call = fmt.Sprintf("internal call via %v", msg.Message.To)
+		}
+		fmt.Fprintf(w, "  Message %v (%v) from %v: %s, exit code %v\n",
+			msg.Cid, msg.TxHash, msg.Message.From, call, msg.Receipt.ExitCode)
+		for _, event := range msg.Events {
+			fmt.Fprintf(w, "    Event: %s\n", event)
+		}
+
+		var match *invariants.Transaction
+		for i := range txs {
+			if strings.EqualFold(txs[i].TxHash, msg.TxHash.Hex()) || txs[i].TxHash == msg.Cid.String() {
+				match = &txs[i]
+				break
+			}
+		}
+		if match != nil {
+			fmt.Fprintf(w, "    API: matching %s transaction @%d: %v\n", match.Type, match.Height, match.Amount)
+		} else {
+			fmt.Fprintln(w, "    API: no matching transaction")
+		}
+	}
+}
+
+// findNextBalanceTransition returns the first height after fromHeight, up to maxHeight,
+// where the liquid assets of the agent differ from balance, or 0 if they don't change.
+// A balance that changes and changes back within the range is missed.
 func findNextBalanceTransition(
 	ctx context.Context,
+	w io.Writer,
 	agent *invariants.Agent,
-	minHeight uint64,
-	prevBalance *big.Int,
+	fromHeight uint64,
+	balance *big.Int,
 	maxHeight uint64,
 ) (uint64, *big.Int, error) {
-	if maxHeight < minHeight {
+	if maxHeight <= fromHeight {
 		return 0, nil, nil
 	}
-	fmt.Printf("  Searching %d to %d\n", minHeight, maxHeight)
-	sampleHeight := (maxHeight-minHeight)/2 + minHeight
-	liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, sampleHeight)
+	fmt.Fprintf(w, "  Searching %d to %d\n", fromHeight+1, maxHeight)
+	unchanged := func(ctx context.Context, height uint64) (bool, error) {
+		liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, height)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(w, "  Liquid assets @%d: %v\n", height, liquidAssets)
+		return liquidAssets.Cmp(balance) == 0, nil
+	}
+
+	same, err := unchanged(ctx, maxHeight)
+	if err != nil || same {
+		return 0, nil, err
+	}
+
+	_, height, err := invariants.Bisect(ctx, unchanged, fromHeight, maxHeight, nil)
 	if err != nil {
 		return 0, nil, err
 	}
-	fmt.Printf("  Liquid assets @%d: %v\n", sampleHeight, liquidAssets)
-	if prevBalance.Cmp(liquidAssets) == 0 {
-		return findNextBalanceTransition(ctx, agent, sampleHeight+1, prevBalance, maxHeight)
-	} else {
-		height, balance, err := findNextBalanceTransition(ctx, agent, minHeight, prevBalance, sampleHeight-1)
-		if err != nil {
-			return 0, nil, err
-		}
-		if height > 0 {
-			return height, balance, nil
-		} else {
-			return sampleHeight, liquidAssets, nil
-		}
+
+	liquidAssets, err := getLiquidAssetsAtHeight(ctx, agent, height)
+	if err != nil {
+		return 0, nil, err
 	}
+
+	return height, liquidAssets, nil
 }
 
 func getLiquidAssetsAtHeight(ctx context.Context, agent *invariants.Agent, height uint64) (*big.Int, error) {
@@ -345,8 +517,11 @@ func getLiquidAssetsAtHeight(ctx context.Context, agent *invariants.Agent, heigh
 		return nil, err
 	}
 
-	q := singleton.PoolsSDK.Query()
-	liquidAssets, err := q.AgentLiquidAssets(ctx, agent.AddressNative, big.NewInt(int64(nextEpoch)))
+	chain := singleton.Chain()
+	args := []any{agent.AddressNative}
+	liquidAssets, err := singleton.Cached("AgentLiquidAssets", nextEpoch, args, func() (*big.Int, error) {
+		return chain.AgentLiquidAssets(ctx, agent.AddressNative, big.NewInt(int64(nextEpoch)))
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/filecoin-project/go-address"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	agent1Address    = common.HexToAddress("0x090fc62ec8f5a4f9c326f3043a765288c58d9adb")
+	agent1ActorID, _ = address.NewIDAddress(1001)
+)
+
+func bigFIL(fil int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(fil), big.NewInt(1e18))
+}
+
+// agent1Chain is a chain matching the agent 1 fixtures: a 10000 FIL borrow at
+// 4300100 and a push leaving 1500 FIL available at 4300500. The agent actor is
+// f01001 and holds its liquid assets as FIL, with no wFIL.
+func agent1Chain(t *testing.T) *chaintest.Chain {
+	chain := chaintest.New(4301000)
+	chain.Use(t)
+	liquidAssets := func(epoch uint64) *big.Int {
+		switch {
+		case epoch > 4300500:
+			return bigFIL(1500)
+		case epoch > 4300100:
+			return bigFIL(10000)
+		default:
+			return big.NewInt(0)
+		}
+	}
+	chain.SetLiquidAssets(agent1Address, liquidAssets)
+	chain.SetPrincipal(agent1Address, chaintest.Step(big.NewInt(0), 4300101, bigFIL(10000)))
+
+	actor, err := invariants.DelegatedFromEthAddress(agent1Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.SetActorID(actor, agent1ActorID)
+	chain.SetActorBalance(actor, liquidAssets)
+	chain.SetWFILBalance(agent1Address, chaintest.Constant(big.NewInt(0)))
+	return chain
+}
+
+func TestCheckAgentBalance(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	for _, epoch := range []uint64{4300099, 4300100, 4300300, 4300500, 4300900} {
+		var w bytes.Buffer
+		failed, err := checkAgentBalance(ctx, &w, server.URL, epoch, agent)
+		assert.Nil(t, err)
+		assert.False(t, failed, w.String())
+		assert.Contains(t, w.String(), "Success")
+	}
+
+	// The node's state after 4300500 is read from the next tipset, past the null round
+	chain.NullRound(4300501)
+	var w bytes.Buffer
+	failed, err := checkAgentBalance(ctx, &w, server.URL, 4300500, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+
+	// The API missed the push
+	server.SetField("/agent/1/tx", "type", "push", "availableBalance", "10000000000000000000000")
+	w.Reset()
+	failed, err = checkAgentBalance(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300600: Error, available balance from REST API doesn't match node (tolerance: exact).")
+	assert.Contains(t, w.String(), "  Node: 1500000000000000000000\n")
+	assert.Contains(t, w.String(), "   API: 10000000000000000000000\n")
+
+	chain.Fail("AgentLiquidAssets", context.DeadlineExceeded)
+	_, err = checkAgentBalance(ctx, &w, server.URL, 4300600, agent)
+	assert.NotNil(t, err)
+}
+
+func TestCheckAgentBalanceDiagnosticsError(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	// The latest balances don't match, and the node fails while examining the
+	// history: the check still fails rather than errors
+	server.SetField("/agent/1/available-balance", "", nil, "availableBalanceDB", "5")
+	chain.Fail("AgentLiquidAssets", context.DeadlineExceeded)
+	var w bytes.Buffer
+	failed, err := checkAgentBalance(ctx, &w, server.URL, 0, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1: Error, latest available balance from REST API doesn't match node")
+	assert.Contains(t, w.String(), "Transaction history: Error, context deadline exceeded\n")
+}
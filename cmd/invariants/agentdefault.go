@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/glifio/go-pools/util"
+	"github.com/glifio/invariants"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentDefaultCmd represents the agentDefault command
+var agentDefaultCmd = &cobra.Command{
+	Use:   "agent-default [agent-id] [--all] [--random <num>] [--epoch <epoch>]",
+	Short: "Compare the default and liquidation state of an agent with the API",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		eventsURL := viper.GetString("events_api")
+
+		err := initSingleton(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		epoch, err := cmd.Flags().GetUint64("epoch")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
+		allAgents, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		randomAgents, err := cmd.Flags().GetUint64("random")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		maxPctVariance, err := cmd.Flags().GetFloat64("max-pct-variance")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// The REST API only has the latest DTE, so it's only compared near the head,
+		// and not at the epochs bisection looks at
+		nearHead := selection.NearHead()
+		var writeOffs *invariants.WriteOffs
+		check := func(ctx context.Context, w io.Writer, agent *invariants.Agent) (bool, error) {
+			return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, at uint64) (bool, error) {
+				return checkAgentDefault(ctx, w, eventsURL, at, agent, writeOffs, maxPctVariance, nearHead && at == epoch)
+			})
+		}
+
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			// The write offs are read once for every agent, and again after a reorg
+			writeOffs = invariants.NewWriteOffs()
+
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
+				if agent == nil {
+					log.Fatalf("Agent %d not found in REST API", agentID)
+				}
+
+				failed, err := check(ctx, os.Stdout, agent)
+				report.Add(agentTarget(agent.ID), failed, err)
+			} else {
+				if len(args) != 0 {
+					cmd.Usage()
+					return
+				}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
+				}
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else {
+					cmd.Usage()
+				}
+			}
+		})
+
+		report.Exit("Agent default tests")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentDefaultCmd)
+	agentDefaultCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	agentDefaultCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(agentDefaultCmd)
+	agentDefaultCmd.Flags().Bool("all", false, "Check all agents")
+	agentDefaultCmd.Flags().Float64("max-pct-variance", 5.0, "Acceptable percentage difference between recoveries and liquidation values, unless set in the config file")
+}
+
+// checkAgentDefault checks the default and liquidation state of an agent at
+// epoch, reading its write offs from writeOffs. With compareDTE, an agent whose latest DTE from the REST API is above
+// the limit must be flagged on the node. A liquidated agent must have no
+// principal left, and its write offs must recover the liquidation value of its
+// miners just before the first one.
+func checkAgentDefault(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent, writeOffs *invariants.WriteOffs, maxPctVariance float64, compareDTE bool) (failed bool, err error) {
+	agentID := agent.ID
+
+	state, height, err := invariants.GetAgentDefaultFromNode(ctx, agent, epoch)
+	if err != nil {
+		return true, err
+	}
+
+	var failCount int
+
+	if compareDTE {
+		econAPI, err := invariants.GetAgentEconFromAPI(ctx, eventsURL, agentID)
+		if err != nil {
+			return true, err
+		}
+
+		flagged := state.Defaulted || state.Administration != (common.Address{})
+		maxDTE, _ := util.ToFIL(state.MaxDTE).Float64()
+		if econAPI.Dte > maxDTE {
+			if flagged {
+				fmt.Fprintf(w, "Agent %d @%d: Success, DTE %0.3f above limit %0.3f and agent is flagged (defaulted: %v, administration: %v)\n",
+					agentID, height, econAPI.Dte, maxDTE, state.Defaulted, state.Administration)
+			} else {
+				fmt.Fprintf(w, "Agent %d @%d: Error, DTE from REST API is above limit but agent isn't flagged on node.\n", agentID, height)
+				fmt.Fprintf(w, "  DTE: %0.3f\n", econAPI.Dte)
+				fmt.Fprintf(w, "  Max: %0.3f\n", maxDTE)
+				failCount++
+			}
+		} else {
+			fmt.Fprintf(w, "Agent %d @%d: Success, DTE %0.3f within limit %0.3f (defaulted: %v)\n",
+				agentID, height, econAPI.Dte, maxDTE, state.Defaulted)
+		}
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Skipped DTE, the REST API only has the latest (defaulted: %v)\n",
+			agentID, height, state.Defaulted)
+	}
+
+	if !state.Liquidated {
+		return failCount > 0, nil
+	}
+
+	if state.Principal.Sign() == 0 {
+		fmt.Fprintf(w, "Agent %d @%d: Success, liquidated agent has no principal\n", agentID, height)
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Error, liquidated agent still has principal on node: %v\n", agentID, height, state.Principal)
+		failCount++
+	}
+
+	agentWriteOffs, err := writeOffs.GetAgentWriteOffsFromNode(ctx, agentID, agent.Height, height)
+	if err != nil {
+		return true, err
+	}
+	recovered := big.NewInt(0)
+	for _, writeOff := range agentWriteOffs {
+		fmt.Fprintf(w, "Agent %d @%d: Write off %v: recovered %0.3f FIL, lost %0.3f FIL\n",
+			agentID, writeOff.Height, writeOff.TxHash, util.ToFIL(writeOff.RecoveredFunds), util.ToFIL(writeOff.LostFunds))
+		recovered.Add(recovered, writeOff.RecoveredFunds)
+	}
+	if len(agentWriteOffs) == 0 {
+		fmt.Fprintf(w, "Agent %d @%d: Error, liquidated agent has no write off on node.\n", agentID, height)
+		return true, nil
+	}
+
+	// The miners' value once written off is gone, so compare with their value
+	// just before
+	beforeWriteOff := agentWriteOffs[0].Height - 1
+	liquidationValue, err := invariants.GetAgentLiquidationValueFromNode(ctx, agent, beforeWriteOff)
+	if err != nil {
+		return true, err
+	}
+
+	diff := new(big.Int).Sub(recovered, liquidationValue)
+	_, pctStr := getPct(diff, liquidationValue, nil)
+	tol := toleranceFor("agent-default", "recoveries", pctTolerance(maxPctVariance))
+	recorderFrom(ctx).record("recoveries", recovered, liquidationValue)
+	if tol.Within(recovered, liquidationValue) {
+		fmt.Fprintf(w, "Agent %d @%d: Success, recoveries match miner liquidation value @%d: %0.3f FIL (%s)\n",
+			agentID, height, beforeWriteOff, util.ToFIL(recovered), pctStr)
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Error, recoveries don't match miner liquidation value @%d on node (tolerance: %v).\n",
+			agentID, height, beforeWriteOff, tol)
+		fmt.Fprintf(w, "   Recovered: %0.3f FIL\n", util.ToFIL(recovered))
+		fmt.Fprintf(w, "  Liquidation: %0.3f FIL (%s)\n", util.ToFIL(liquidationValue), pctStr)
+		failCount++
+	}
+
+	return failCount > 0, nil
+}
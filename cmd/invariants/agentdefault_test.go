@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/glifio/invariants/singleton"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentDefaultDTE(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	// The API has agent 1 at a DTE of 0.667
+	status := singleton.AgentStatus{MaxDTE: big.NewInt(8e17)}
+	chain.SetAgentStatus(agent1Address, status)
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	failed, err := checkAgentDefault(ctx, &w, server.URL, 4300600, agent, invariants.NewWriteOffs(), 1, true)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Equal(t, "Agent 1 @4300601: Success, DTE 0.667 within limit 0.800 (defaulted: false)\n", w.String())
+
+	// Above the limit, the agent must be flagged
+	status.MaxDTE = big.NewInt(5e17)
+	chain.SetAgentStatus(agent1Address, status)
+	w.Reset()
+	failed, err = checkAgentDefault(ctx, &w, server.URL, 4300600, agent, invariants.NewWriteOffs(), 1, true)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Equal(t, "Agent 1 @4300601: Error, DTE from REST API is above limit but agent isn't flagged on node.\n"+
+		"  DTE: 0.667\n  Max: 0.500\n", w.String())
+
+	status.Defaulted = true
+	chain.SetAgentStatus(agent1Address, status)
+	w.Reset()
+	failed, err = checkAgentDefault(ctx, &w, server.URL, 4300600, agent, invariants.NewWriteOffs(), 1, true)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Contains(t, w.String(), "Success, DTE 0.667 above limit 0.500 and agent is flagged (defaulted: true")
+
+	chain.Fail("AgentStatus", context.DeadlineExceeded)
+	_, err = checkAgentDefault(ctx, &w, server.URL, 4300600, agent, invariants.NewWriteOffs(), 1, true)
+	assert.NotNil(t, err)
+}
+
+func TestCheckAgentDefaultLiquidated(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	// Agent 1 is liquidated at 4300700, its miners recovering 22000 FIL and
+	// repaying its principal
+	chain.SetAgentStatus(agent1Address, singleton.AgentStatus{Defaulted: true, Liquidated: true, MaxDTE: big.NewInt(8e17)})
+	chain.SetPrincipal(agent1Address, chaintest.Step(bigFIL(10000), 4300700, big.NewInt(0)))
+	chain.SetLiquidationValue(agent1Address, chaintest.Constant(bigFIL(22000)))
+	chain.AddWriteOff(1, 4300700, abigen.InfinityPoolWriteOff{
+		RecoveredFunds: bigFIL(22000),
+		LostFunds:      big.NewInt(0),
+		InterestPaid:   big.NewInt(0),
+	})
+	chain.AddWriteOff(2, 4300650, abigen.InfinityPoolWriteOff{
+		RecoveredFunds: bigFIL(500),
+		LostFunds:      big.NewInt(0),
+		InterestPaid:   big.NewInt(0),
+	})
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	writeOffs := invariants.NewWriteOffs()
+	var w bytes.Buffer
+	failed, err := checkAgentDefault(ctx, &w, server.URL, 4300800, agent, writeOffs, 1, false)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Contains(t, w.String(), "Agent 1 @4300801: Success, liquidated agent has no principal\n")
+	assert.Contains(t, w.String(), "Agent 1 @4300700: Write off 0x0000000000000000000000000000000000000000000000000000000000000000: recovered 22000.000 FIL, lost 0.000 FIL\n")
+	assert.NotContains(t, w.String(), "recovered 500.000 FIL", "agent 2's write off")
+	assert.Contains(t, w.String(), "Agent 1 @4300801: Success, recoveries match miner liquidation value @4300699: 22000.000 FIL")
+
+	// The miners were worth more than the write off recovered
+	chain.SetLiquidationValue(agent1Address, chaintest.Step(bigFIL(30000), 4300700, big.NewInt(0)))
+	w.Reset()
+	failed, err = checkAgentDefault(ctx, &w, server.URL, 4300800, agent, writeOffs, 1, false)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300801: Error, recoveries don't match miner liquidation value @4300699 on node (tolerance: ±1%).\n")
+	assert.Contains(t, w.String(), "  Liquidation: 30000.000 FIL")
+
+	// Liquidated without a write off yet
+	w.Reset()
+	failed, err = checkAgentDefault(ctx, &w, server.URL, 4300600, agent, writeOffs, 1, false)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Error, liquidated agent still has principal on node: 10000000000000000000000\n")
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Error, liquidated agent has no write off on node.\n")
+
+	// The day of write offs read by the first check was shared by the others
+	var reads int
+	for _, call := range chain.Calls() {
+		if strings.HasPrefix(call, "PoolWriteOffs@") {
+			reads++
+		}
+	}
+	assert.Equal(t, 1, reads)
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
+	"math/big"
+	"os"
 	"strconv"
 
 	"github.com/glifio/invariants"
@@ -14,7 +16,7 @@ import (
 
 // agentEconCmd represents the agentEcon command
 var agentEconCmd = &cobra.Command{
-	Use:   "agent-econ [agent-id] [--all] [--random <num>] [--epoch <epoch>]",
+	Use:   "agent-econ [agent-id] [--all] [--random <num>] [--epoch <epoch>] [--from <epoch> [--to <epoch>] [--step <epochs>]]",
 	Short: "Compare the econ values from the API and the node for an agent",
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -24,7 +26,7 @@ var agentEconCmd = &cobra.Command{
 
 		err := initSingleton(ctx)
 		if err != nil {
-			log.Fatal(err)
+			fatalInfrastructure(err)
 		}
 
 		epoch, err := cmd.Flags().GetUint64("epoch")
@@ -32,6 +34,12 @@ var agentEconCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
 		allAgents, err := cmd.Flags().GetBool("all")
 		if err != nil {
 			log.Fatal(err)
@@ -42,10 +50,22 @@ var agentEconCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		var failCount int
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		if !allAgents && randomAgents == 0 {
-			if len(args) != 1 {
+		sweep, err := getSweepRange(cmd, epoch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sweep != nil {
+			if len(args) != 1 || allAgents || randomAgents > 0 {
 				cmd.Usage()
 				return
 			}
@@ -55,68 +75,76 @@ var agentEconCmd = &cobra.Command{
 				log.Fatal(err)
 			}
 
-			agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
-			if err != nil {
-				log.Fatal(err)
-			}
+			report := &runReport{}
+			sweepAgentEcon(ctx, report, eventsURL, agentID, sweep)
+			report.Exit("Econ tests")
+			return
+		}
 
-			failed, err := checkAgentEcon(ctx, eventsURL, epoch, agent)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if failed {
-				failCount++
-			}
-		} else {
-			if len(args) != 0 {
-				cmd.Usage()
-				return
-			}
+		check := func(ctx context.Context, w io.Writer, agent *invariants.Agent) (bool, error) {
+			return bisectAgentEcon(ctx, w, eventsURL, epoch, agent)
+		}
 
-			agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
-			if err != nil {
-				log.Fatal(err)
-			}
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
 
-			if allAgents {
-				if randomAgents > 0 {
+				failed, err := check(ctx, os.Stdout, agent)
+				report.Add(agentTarget(agentID), failed, err)
+			} else {
+				if len(args) != 0 {
 					cmd.Usage()
 					return
 				}
-				for _, agent := range agents {
-					failed, err := checkAgentEcon(ctx, eventsURL, epoch, &agent)
-					if err != nil {
-						log.Fatal(err)
-					}
-					if failed {
-						failCount++
-					}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
 				}
-			} else if randomAgents > 0 {
-				if int(randomAgents) > len(agents) {
-					randomAgents = uint64(len(agents))
+
+				poolCheck := func(ctx context.Context, w io.Writer, agent invariants.Agent) (bool, error) {
+					return check(ctx, w, &agent)
 				}
-				rand.Shuffle(len(agents), func(i, j int) {
-					agents[i], agents[j] = agents[j], agents[i]
-				})
-				for i := 0; i < int(randomAgents); i++ {
-					agent := agents[i]
-					failed, err := checkAgentEcon(ctx, eventsURL, epoch, &agent)
-					if err != nil {
-						log.Fatal(err)
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
 					}
-					if failed {
-						failCount++
+					runPool(ctx, report, concurrency, agents, func(agent invariants.Agent) string {
+						return agentTarget(agent.ID)
+					}, poolCheck)
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
 					}
+					runPool(ctx, report, concurrency, agents, func(agent invariants.Agent) string {
+						return agentTarget(agent.ID)
+					}, poolCheck)
+				} else {
+					cmd.Usage()
 				}
-			} else {
-				cmd.Usage()
 			}
-		}
+		})
 
-		if failCount > 0 {
-			log.Fatal("FAIL: Econ tests had errors.")
-		}
+		report.Exit("Econ tests")
 	},
 }
 
@@ -124,19 +152,35 @@ func init() {
 	rootCmd.AddCommand(agentEconCmd)
 	agentEconCmd.Flags().Uint64("epoch", 0, "Check at epoch")
 	agentEconCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(agentEconCmd)
 	agentEconCmd.Flags().Bool("all", false, "Check all agents")
+	agentEconCmd.Flags().Int("concurrency", 1, "Number of agents to check in parallel")
+	addSweepFlags(agentEconCmd)
 }
 
-func checkAgentEcon(ctx context.Context, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
-	agentID := agent.ID
-
-	if epoch == 0 {
-		epoch, err = getHeadEpoch(ctx)
-		if err != nil {
-			return true, err
+// bisectAgentEcon runs checkAgentEcon at epoch, bisecting on failure with
+// --bisect. The API only serves the latest econ values, so the earlier epochs are
+// checked against the transaction history.
+func bisectAgentEcon(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
+	var txs []invariants.Transaction
+	rateAt := poolRates(ctx)
+	return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, at uint64) (bool, error) {
+		if at == epoch {
+			return checkAgentEcon(ctx, w, eventsURL, epoch, agent)
 		}
-		epoch = epoch - 3
-	}
+		if txs == nil {
+			var err error
+			txs, err = invariants.GetAgentTransactionsFromAPI(ctx, eventsURL, agent.ID)
+			if err != nil {
+				return true, err
+			}
+		}
+		return checkAgentLiabilityAt(ctx, w, at, agent, txs, rateAt)
+	})
+}
+
+func checkAgentEcon(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
+	agentID := agent.ID
 
 	econAPI, err := invariants.GetAgentEconFromAPI(ctx, eventsURL, agentID)
 	if err != nil {
@@ -152,13 +196,92 @@ func checkAgentEcon(ctx context.Context, eventsURL string, epoch uint64, agent *
 	// Mutate for testing
 	// econAPI.Liability = big.NewInt(1234)
 
-	if econAPI.Liability.Cmp(econNode.Liability) == 0 {
-		fmt.Printf("Agent %d: Success, latest liabilities match: %v\n", agentID, econNode.Liability)
+	ok, tol := compareValues(ctx, "agent-econ", "liability", econAPI.Liability, econNode.Liability)
+	if ok {
+		fmt.Fprintf(w, "Agent %d: Success, latest liabilities match: %v\n", agentID, econNode.Liability)
 		return false, nil
 	} else {
-		fmt.Printf("Agent %d: Error, latest liability from REST API doesn't match node.\n", agentID)
-		fmt.Printf("  Node @%d: %v\n", height, econNode.Liability)
-		fmt.Printf("   API: %v\n", econAPI.Liability)
+		fmt.Fprintf(w, "Agent %d: Error, latest liability from REST API doesn't match node (tolerance: %v).\n", agentID, tol)
+		fmt.Fprintf(w, "  Node @%d: %v\n", height, econNode.Liability)
+		fmt.Fprintf(w, "   API: %v\n", econAPI.Liability)
 		return true, nil
 	}
 }
+
+// agentLiabilityAt returns the liability of an agent at epoch replayed from its
+// transaction history, as the API only serves the latest econ values, and the
+// liability on the node at height, the first tipset from epoch
+func agentLiabilityAt(
+	ctx context.Context,
+	agent *invariants.Agent,
+	txs []invariants.Transaction,
+	rateAt func(height uint64) (*big.Int, error),
+	epoch uint64,
+) (history *big.Int, node *big.Int, height uint64, err error) {
+	replayed, err := invariants.ReplayAgentAccount(ctx, txs, epoch, rateAt)
+	if err != nil {
+		return nil, nil, epoch, err
+	}
+	econNode, height, err := invariants.GetAgentEconFromNode(ctx, agent.AddressNative, epoch)
+	if err != nil {
+		return nil, nil, height, err
+	}
+	return replayed.Account.Principal, econNode.Liability, height, nil
+}
+
+// checkAgentLiabilityAt compares the liability of an agent on the node with its
+// transaction history, which unlike checkAgentEcon holds at any epoch
+func checkAgentLiabilityAt(
+	ctx context.Context,
+	w io.Writer,
+	epoch uint64,
+	agent *invariants.Agent,
+	txs []invariants.Transaction,
+	rateAt func(height uint64) (*big.Int, error),
+) (failed bool, err error) {
+	history, node, height, err := agentLiabilityAt(ctx, agent, txs, rateAt, epoch)
+	if err != nil {
+		return true, err
+	}
+
+	ok, tol := compareValues(ctx, "agent-econ", "liability", history, node)
+	if ok {
+		fmt.Fprintf(w, "Agent %d @%d: Success, liability matches transaction history: %v\n", agent.ID, height, node)
+		return false, nil
+	}
+	fmt.Fprintf(w, "Agent %d @%d: Error, liability on node doesn't match transaction history (tolerance: %v).\n", agent.ID, height, tol)
+	fmt.Fprintf(w, "     Node: %v\n", node)
+	fmt.Fprintf(w, "  History: %v\n", history)
+	return true, nil
+}
+
+// sweepAgentEcon compares the liability of an agent on the node with the one
+// replayed from its transaction history, listed as the API value of the sweep
+func sweepAgentEcon(ctx context.Context, report *runReport, eventsURL string, agentID uint64, sweep *sweepRange) {
+	agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	txs, err := invariants.GetAgentTransactionsFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	rateAt := poolRates(ctx)
+
+	fmt.Printf("Agent %d: Sweeping liability @%d to @%d\n", agentID, sweep.From, sweep.To)
+	sweepEpochs(ctx, os.Stdout, report, agentTarget(agentID), sweep, func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+		history, node, _, err := agentLiabilityAt(ctx, agent, txs, rateAt, epoch)
+		if err != nil {
+			return nil, err
+		}
+		return []sweepValue{
+			newSweepValue("agent-econ", "liability", "liability", history, node),
+		}, nil
+	}, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return checkAgentLiabilityAt(ctx, w, epoch, agent, txs, rateAt)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentEcon(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	failed, err := checkAgentEcon(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Equal(t, "Agent 1: Success, latest liabilities match: 10000000000000000000000\n", w.String())
+
+	// Before the borrow, the node has no liability yet
+	w.Reset()
+	failed, err = checkAgentEcon(ctx, &w, server.URL, 4300050, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "  Node @4300051: 0\n")
+
+	chain.Fail("AgentPrincipal", context.DeadlineExceeded)
+	_, err = checkAgentEcon(ctx, &w, server.URL, 4300600, agent)
+	assert.NotNil(t, err)
+}
+
+func TestCheckAgentLiabilityAt(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	txs, err := invariants.GetAgentTransactionsFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	rateAt := poolRates(ctx)
+
+	// Unlike the latest econ from the API, the history holds before the borrow too
+	for _, epoch := range []uint64{4300050, 4300100, 4300600} {
+		var w bytes.Buffer
+		failed, err := checkAgentLiabilityAt(ctx, &w, epoch, agent, txs, rateAt)
+		assert.Nil(t, err)
+		assert.False(t, failed, w.String())
+	}
+
+	// The node's principal moves before the borrow in the history
+	chain.SetPrincipal(agent1Address, chaintest.Step(big.NewInt(0), 4300051, bigFIL(10000)))
+	var w bytes.Buffer
+	failed, err := checkAgentLiabilityAt(ctx, &w, 4300050, agent, txs, rateAt)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300051: Error, liability on node doesn't match transaction history (tolerance: exact).")
+	assert.Contains(t, w.String(), "     Node: 10000000000000000000000\n")
+	assert.Contains(t, w.String(), "  History: 0\n")
+
+	report := &runReport{}
+	sweepAgentEcon(ctx, report, server.URL, 1, &sweepRange{From: 4300000, To: 4300200, Step: 50})
+	assert.Equal(t, 4, report.Count(statusPass))
+	assert.Equal(t, 1, report.Count(statusFail))
+	assert.Equal(t, "Agent 1 @4300050", report.Results[1].Target)
+	assert.Equal(t, statusFail, report.Results[1].Status)
+}
+
+func TestBisectAgentEcon(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	rootCmd.PersistentFlags().Set("bisect", "true")
+	defer rootCmd.PersistentFlags().Set("bisect", "false")
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	// The node's principal grew from 4300300 without a borrow in the history
+	chain.SetPrincipal(agent1Address, func(epoch uint64) *big.Int {
+		switch {
+		case epoch >= 4300300:
+			return bigFIL(10001)
+		case epoch >= 4300101:
+			return bigFIL(10000)
+		default:
+			return big.NewInt(0)
+		}
+	})
+
+	var w bytes.Buffer
+	failed, err := bisectAgentEcon(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1: Error, latest liability from REST API doesn't match node")
+	assert.Contains(t, w.String(), "Last passing epoch: @4300298\n")
+	assert.Contains(t, w.String(), "First failing epoch: @4300299")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/glifio/invariants"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentInterestCmd represents the agentInterest command
+var agentInterestCmd = &cobra.Command{
+	Use:   "agent-interest [agent-id] [--all] [--random <num>] [--epoch <epoch>] [--from <epoch> [--to <epoch>] [--step <epochs>]]",
+	Short: "Compare the interest owed by an agent with its borrow and payment history",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		eventsURL := viper.GetString("events_api")
+
+		err := initSingleton(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		epoch, err := cmd.Flags().GetUint64("epoch")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
+		allAgents, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		randomAgents, err := cmd.Flags().GetUint64("random")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sweep, err := getSweepRange(cmd, epoch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sweep != nil {
+			if len(args) != 1 || allAgents || randomAgents > 0 {
+				cmd.Usage()
+				return
+			}
+
+			agentID, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			report := &runReport{}
+			sweepAgentInterest(ctx, report, eventsURL, agentID, sweep)
+			report.Exit("Agent interest tests")
+			return
+		}
+
+		check := func(ctx context.Context, w io.Writer, agent *invariants.Agent) (bool, error) {
+			return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+				return checkAgentInterest(ctx, w, eventsURL, epoch, agent)
+			})
+		}
+
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
+				if agent == nil {
+					log.Fatalf("Agent %d not found in REST API", agentID)
+				}
+
+				failed, err := check(ctx, os.Stdout, agent)
+				report.Add(agentTarget(agent.ID), failed, err)
+			} else {
+				if len(args) != 0 {
+					cmd.Usage()
+					return
+				}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
+				}
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else {
+					cmd.Usage()
+				}
+			}
+		})
+
+		report.Exit("Agent interest tests")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentInterestCmd)
+	agentInterestCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	agentInterestCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(agentInterestCmd)
+	agentInterestCmd.Flags().Bool("all", false, "Check all agents")
+	addSweepFlags(agentInterestCmd)
+}
+
+func checkAgentInterest(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
+	agentID := agent.ID
+
+	txs, err := invariants.GetAgentTransactionsFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		return true, err
+	}
+
+	replayed, err := invariants.ReplayAgentAccount(ctx, txs, epoch, poolRates(ctx))
+	if err != nil {
+		return true, err
+	}
+
+	interestNode, height, err := invariants.GetAgentInterestFromNode(ctx, agent, epoch)
+	if err != nil {
+		return true, err
+	}
+
+	var failCount int
+
+	for _, payment := range replayed.Payments {
+		ok, tol := compareValues(ctx, "agent-interest", "interest_paid", payment.Tx.Interest, payment.InterestPaid)
+		if ok {
+			continue
+		}
+		fmt.Fprintf(w, "Agent %d @%d: Error, interest paid from REST API doesn't match expected (tolerance: %v).\n", agentID, payment.Tx.Height, tol)
+		fmt.Fprintf(w, "  Tx: %s\n", payment.Tx.TxHash)
+		fmt.Fprintf(w, "  Expected: %v\n", payment.InterestPaid)
+		fmt.Fprintf(w, "       API: %v\n", payment.Tx.Interest)
+		failCount++
+	}
+	if failCount == 0 {
+		fmt.Fprintf(w, "Agent %d: Success, interest paid matches for %d payments\n", agentID, len(replayed.Payments))
+	}
+
+	expected := invariants.InterestOwedAt(ctx, replayed.Account, interestNode.Rate, height)
+	if ok, tol := compareValues(ctx, "agent-interest", "interest_owed", interestNode.InterestOwed, expected); ok {
+		fmt.Fprintf(w, "Agent %d @%d: Success, interest owed matches: %v\n", agentID, height, interestNode.InterestOwed)
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Error, interest owed on node doesn't match transaction history (tolerance: %v).\n", agentID, height, tol)
+		fmt.Fprintf(w, "      Node: %v (principal %v, epochs paid %v)\n",
+			interestNode.InterestOwed, interestNode.Account.Principal, interestNode.Account.EpochsPaid)
+		fmt.Fprintf(w, "  Expected: %v (principal %v, epochs paid %v)\n",
+			expected, replayed.Account.Principal, replayed.Account.EpochsPaid)
+		failCount++
+	}
+
+	return failCount > 0, nil
+}
+
+// poolRates returns the pool rate at a height, reading each height once from the
+// node
+func poolRates(ctx context.Context) func(height uint64) (*big.Int, error) {
+	rates := make(map[uint64]*big.Int)
+	return func(height uint64) (*big.Int, error) {
+		if rate, ok := rates[height]; ok {
+			return rate, nil
+		}
+		rate, _, err := invariants.GetPoolRateFromNode(ctx, height)
+		if err != nil {
+			return nil, err
+		}
+		rates[height] = rate
+		return rate, nil
+	}
+}
+
+func sweepAgentInterest(ctx context.Context, report *runReport, eventsURL string, agentID uint64, sweep *sweepRange) {
+	agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	txs, err := invariants.GetAgentTransactionsFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	rateAt := poolRates(ctx)
+
+	fmt.Printf("Agent %d: Sweeping interest owed @%d to @%d\n", agentID, sweep.From, sweep.To)
+	sweepEpochs(ctx, os.Stdout, report, agentTarget(agentID), sweep, func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+		replayed, err := invariants.ReplayAgentAccount(ctx, txs, epoch, rateAt)
+		if err != nil {
+			return nil, err
+		}
+		interestNode, height, err := invariants.GetAgentInterestFromNode(ctx, agent, epoch)
+		if err != nil {
+			return nil, err
+		}
+		expected := invariants.InterestOwedAt(ctx, replayed.Account, interestNode.Rate, height)
+		return []sweepValue{
+			newSweepValue("agent-interest", "interest_owed", "interestOwed", expected, interestNode.InterestOwed),
+		}, nil
+	}, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return checkAgentInterest(ctx, w, eventsURL, epoch, agent)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentInterest(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	// 10000 FIL borrowed at 4300100 owes 1e13 attoFIL per epoch
+	chain.SetPoolRate(chaintest.Constant(new(big.Int).Mul(big.NewInt(1e9), big.NewInt(1e18))))
+	account := abigen.Account{
+		StartEpoch: big.NewInt(4300100),
+		Principal:  bigFIL(10000),
+		EpochsPaid: big.NewInt(4300100),
+	}
+	chain.SetAgentAccount(agent1Address, account)
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	failed, err := checkAgentInterest(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Equal(t, "Agent 1: Success, interest paid matches for 0 payments\n"+
+		"Agent 1 @4300601: Success, interest owed matches: 5010000000000000\n", w.String())
+
+	// The node has the interest paid up to 4300200, the history has no payment
+	account.EpochsPaid = big.NewInt(4300200)
+	chain.SetAgentAccount(agent1Address, account)
+	w.Reset()
+	failed, err = checkAgentInterest(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Error, interest owed on node doesn't match transaction history (tolerance: exact).\n"+
+		"      Node: 4010000000000000 (principal 10000000000000000000000, epochs paid 4300200)\n"+
+		"  Expected: 5010000000000000 (principal 10000000000000000000000, epochs paid 4300100)\n")
+
+	// The interest owed is 1e15 attoFIL off, within TOLERANCE_AGENT_INTEREST=1e15
+	// and not 1 attoFIL less
+	defer viper.Reset()
+	defer func() { tolerances = nil }()
+	viper.Set("tolerance_agent_interest", "1000000000000000")
+	tolerances, err = loadTolerances()
+	assert.Nil(t, err)
+	w.Reset()
+	failed, err = checkAgentInterest(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+
+	viper.Set("tolerance_agent_interest", "999999999999999")
+	tolerances, err = loadTolerances()
+	assert.Nil(t, err)
+	w.Reset()
+	failed, err = checkAgentInterest(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "(tolerance: ±999999999999999 attoFIL).")
+
+	chain.Fail("PoolRate", context.DeadlineExceeded)
+	_, err = checkAgentInterest(ctx, &w, server.URL, 4300600, agent)
+	assert.NotNil(t, err)
+}
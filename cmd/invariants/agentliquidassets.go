@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/glifio/invariants"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentLiquidAssetsCmd represents the agentLiquidAssets command
+var agentLiquidAssetsCmd = &cobra.Command{
+	Use:   "agent-liquid-assets [agent-id] [--all] [--random <num>] [--epoch <epoch>] [--from <epoch> [--to <epoch>] [--step <epochs>]]",
+	Short: "Compare the liquid assets of an agent with its actor balance on the node",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		eventsURL := viper.GetString("events_api")
+
+		err := initSingleton(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		epoch, err := cmd.Flags().GetUint64("epoch")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
+		allAgents, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		randomAgents, err := cmd.Flags().GetUint64("random")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sweep, err := getSweepRange(cmd, epoch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sweep != nil {
+			if len(args) != 1 || allAgents || randomAgents > 0 {
+				cmd.Usage()
+				return
+			}
+
+			agentID, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			report := &runReport{}
+			sweepAgentLiquidAssets(ctx, report, eventsURL, agentID, sweep)
+			report.Exit("Agent liquid assets tests")
+			return
+		}
+
+		check := func(ctx context.Context, w io.Writer, agent *invariants.Agent) (bool, error) {
+			return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+				return checkAgentLiquidAssets(ctx, w, epoch, agent)
+			})
+		}
+
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
+				if agent == nil {
+					log.Fatalf("Agent %d not found in REST API", agentID)
+				}
+
+				failed, err := check(ctx, os.Stdout, agent)
+				report.Add(agentTarget(agent.ID), failed, err)
+			} else {
+				if len(args) != 0 {
+					cmd.Usage()
+					return
+				}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
+				}
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else {
+					cmd.Usage()
+				}
+			}
+		})
+
+		report.Exit("Agent liquid assets tests")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentLiquidAssetsCmd)
+	agentLiquidAssetsCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	agentLiquidAssetsCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(agentLiquidAssetsCmd)
+	agentLiquidAssetsCmd.Flags().Bool("all", false, "Check all agents")
+	addSweepFlags(agentLiquidAssetsCmd)
+}
+
+func checkAgentLiquidAssets(ctx context.Context, w io.Writer, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
+	agentID := agent.ID
+
+	result, height, err := invariants.GetAgentActorBalanceFromNode(ctx, agent, epoch)
+	if err != nil {
+		return true, err
+	}
+
+	// The agent contract doesn't lock or escrow any of its own funds, so its
+	// liquid assets should be exactly the FIL it holds plus any wrapped FIL
+	expected := new(big.Int).Add(result.ActorBalance, result.WFILBalance)
+
+	ok, tol := compareValues(ctx, "agent-liquid-assets", "liquid_assets", result.LiquidAssets, expected)
+	if ok {
+		fmt.Fprintf(w, "Agent %d @%d: Success, liquid assets match actor balance: %v\n", agentID, height, result.LiquidAssets)
+		return false, nil
+	}
+	fmt.Fprintf(w, "Agent %d @%d: Error, liquid assets from agent contract don't match actor balance (tolerance: %v).\n", agentID, height, tol)
+	fmt.Fprintf(w, "  Actor balance: %v\n", result.ActorBalance)
+	fmt.Fprintf(w, "   WFIL balance: %v\n", result.WFILBalance)
+	fmt.Fprintf(w, "  Liquid assets: %v\n", result.LiquidAssets)
+	return true, nil
+}
+
+// sweepAgentLiquidAssets compares the liquid assets of an agent with its actor
+// and wFIL balances, listed as the API and node values of the sweep
+func sweepAgentLiquidAssets(ctx context.Context, report *runReport, eventsURL string, agentID uint64, sweep *sweepRange) {
+	agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		report.Add(agentTarget(agentID), true, err)
+		return
+	}
+
+	fmt.Printf("Agent %d: Sweeping liquid assets @%d to @%d\n", agentID, sweep.From, sweep.To)
+	sweepEpochs(ctx, os.Stdout, report, agentTarget(agentID), sweep, func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+		result, _, err := invariants.GetAgentActorBalanceFromNode(ctx, agent, epoch)
+		if err != nil {
+			return nil, err
+		}
+		expected := new(big.Int).Add(result.ActorBalance, result.WFILBalance)
+		return []sweepValue{
+			newSweepValue("agent-liquid-assets", "liquid_assets", "liquidAssets", result.LiquidAssets, expected),
+		}, nil
+	}, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return checkAgentLiquidAssets(ctx, w, epoch, agent)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentLiquidAssets(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	// The actor balance is read from the parent state of the next tipset, at the
+	// same epoch as the liquid assets
+	for _, epoch := range []uint64{4300099, 4300100, 4300499, 4300500, 4300900} {
+		var w bytes.Buffer
+		failed, err := checkAgentLiquidAssets(ctx, &w, epoch, agent)
+		assert.Nil(t, err)
+		assert.False(t, failed, w.String())
+	}
+
+	// Wrapped FIL counts toward the liquid assets
+	chain.SetWFILBalance(agent1Address, chaintest.Step(big.NewInt(0), 4300700, bigFIL(1)))
+	var w bytes.Buffer
+	failed, err := checkAgentLiquidAssets(ctx, &w, 4300800, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Equal(t, "Agent 1 @4300801: Error, liquid assets from agent contract don't match actor balance (tolerance: exact).\n"+
+		"  Actor balance: 1500000000000000000000\n"+
+		"   WFIL balance: 1000000000000000000\n"+
+		"  Liquid assets: 1500000000000000000000\n", w.String())
+
+	chain.SetLiquidAssets(agent1Address, chaintest.Constant(bigFIL(1501)))
+	w.Reset()
+	failed, err = checkAgentLiquidAssets(ctx, &w, 4300800, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Equal(t, "Agent 1 @4300801: Success, liquid assets match actor balance: 1501000000000000000000\n", w.String())
+
+	chain.Fail("StateGetActor", context.DeadlineExceeded)
+	_, err = checkAgentLiquidAssets(ctx, &w, 4300800, agent)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/glifio/invariants"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentOwnershipCmd represents the agentOwnership command
+var agentOwnershipCmd = &cobra.Command{
+	Use:   "agent-ownership [agent-id] [--all] [--random <num>] [--epoch <epoch>] [--owner <addr>] [--operator <addr>]",
+	Short: "Check the owner, operator and IDs of an agent on the node",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		eventsURL := viper.GetString("events_api")
+
+		err := initSingleton(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		epoch, err := cmd.Flags().GetUint64("epoch")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
+		allAgents, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		randomAgents, err := cmd.Flags().GetUint64("random")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var expectedOwner, expectedOperator *common.Address
+
+		ownerStr, err := cmd.Flags().GetString("owner")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ownerStr != "" {
+			owner, err := invariants.ParseEthAddress(ownerStr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			expectedOwner = &owner
+		}
+
+		operatorStr, err := cmd.Flags().GetString("operator")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if operatorStr != "" {
+			operator, err := invariants.ParseEthAddress(operatorStr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			expectedOperator = &operator
+		}
+
+		check := func(ctx context.Context, w io.Writer, agent *invariants.Agent) (bool, error) {
+			return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+				return checkAgentOwnership(ctx, w, epoch, agent, expectedOwner, expectedOperator)
+			})
+		}
+
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
+				if agent == nil {
+					log.Fatalf("Agent %d not found in REST API", agentID)
+				}
+
+				failed, err := check(ctx, os.Stdout, agent)
+				report.Add(agentTarget(agent.ID), failed, err)
+			} else {
+				if len(args) != 0 {
+					cmd.Usage()
+					return
+				}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
+				}
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else {
+					cmd.Usage()
+				}
+			}
+		})
+
+		report.Exit("Agent ownership tests")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentOwnershipCmd)
+	agentOwnershipCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	agentOwnershipCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(agentOwnershipCmd)
+	agentOwnershipCmd.Flags().Bool("all", false, "Check all agents")
+	agentOwnershipCmd.Flags().String("owner", "", "Expected owner address (0x or f410)")
+	agentOwnershipCmd.Flags().String("operator", "", "Expected operator address (0x or f410)")
+}
+
+func checkAgentOwnership(
+	ctx context.Context,
+	w io.Writer,
+	epoch uint64,
+	agent *invariants.Agent,
+	expectedOwner *common.Address,
+	expectedOperator *common.Address,
+) (failed bool, err error) {
+	agentID := agent.ID
+
+	ownership, height, err := invariants.GetAgentOwnershipFromNode(ctx, agent, epoch)
+	if err != nil {
+		return true, err
+	}
+
+	var failCount int
+
+	if ownership.ActorID == ownership.ActorIDNative {
+		fmt.Fprintf(w, "Agent %d @%d: Success, %s and %s resolve to %v\n",
+			agentID, height, agent.Address, agent.AddressNative, ownership.ActorID)
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Error, agent addresses resolve to different actors.\n", agentID, height)
+		fmt.Fprintf(w, "  %s: %v\n", agent.Address, ownership.ActorID)
+		fmt.Fprintf(w, "  %s: %v\n", agent.AddressNative, ownership.ActorIDNative)
+		failCount++
+	}
+
+	if ownership.ID == agentID {
+		fmt.Fprintf(w, "Agent %d @%d: Success, agent contract ID matches: %d\n", agentID, height, ownership.ID)
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Error, agent contract ID doesn't match REST API.\n", agentID, height)
+		fmt.Fprintf(w, "  Node: %d\n", ownership.ID)
+		fmt.Fprintf(w, "   API: %d\n", agentID)
+		failCount++
+	}
+
+	if ownership.FactoryID == agentID {
+		fmt.Fprintf(w, "Agent %d @%d: Success, agent factory ID matches: %d\n", agentID, height, ownership.FactoryID)
+	} else {
+		fmt.Fprintf(w, "Agent %d @%d: Error, agent factory ID doesn't match REST API.\n", agentID, height)
+		fmt.Fprintf(w, "  Node: %d\n", ownership.FactoryID)
+		fmt.Fprintf(w, "   API: %d\n", agentID)
+		failCount++
+	}
+
+	if !checkAgentRole(w, agentID, height, "owner", ownership.Owner, expectedOwner) {
+		failCount++
+	}
+	if !checkAgentRole(w, agentID, height, "operator", ownership.Operator, expectedOperator) {
+		failCount++
+	}
+
+	if ownership.PendingOwner != (common.Address{}) {
+		fmt.Fprintf(w, "Agent %d @%d: Warning, ownership transfer pending to %v\n", agentID, height, ownership.PendingOwner)
+	}
+	if ownership.PendingOperator != (common.Address{}) {
+		fmt.Fprintf(w, "Agent %d @%d: Warning, operator transfer pending to %v\n", agentID, height, ownership.PendingOperator)
+	}
+
+	return failCount > 0, nil
+}
+
+func checkAgentRole(w io.Writer, agentID uint64, height uint64, role string, actual common.Address, expected *common.Address) bool {
+	if actual == (common.Address{}) {
+		fmt.Fprintf(w, "Agent %d @%d: Error, agent has no %s.\n", agentID, height, role)
+		return false
+	}
+	if expected != nil && actual != *expected {
+		fmt.Fprintf(w, "Agent %d @%d: Error, agent %s doesn't match expected.\n", agentID, height, role)
+		fmt.Fprintf(w, "      Node: %v\n", actual)
+		fmt.Fprintf(w, "  Expected: %v\n", *expected)
+		return false
+	}
+	fmt.Fprintf(w, "Agent %d @%d: Success, agent %s: %v\n", agentID, height, role, actual)
+	return true
+}
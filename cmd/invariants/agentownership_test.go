@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/glifio/invariants/singleton"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentOwnership(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	owner := common.HexToAddress("0x01")
+	operator := common.HexToAddress("0x02")
+	roles := singleton.AgentRoles{ID: big.NewInt(1), Owner: owner, Operator: operator}
+	chain.SetAgentRoles(agent1Address, roles)
+	chain.SetAgentFactoryID(agent1Address, 1)
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	failed, err := checkAgentOwnership(ctx, &w, 4300600, agent, &owner, nil)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Success, f410fbeh4mlwi6wsptqzg6mcdu5ssrdcy3gw3oty3gla and "+agent1Address.String()+" resolve to f01001\n")
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Success, agent owner: "+owner.String()+"\n")
+
+	// The factory registered the agent under another ID
+	chain.SetAgentFactoryID(agent1Address, 2)
+	w.Reset()
+	failed, err = checkAgentOwnership(ctx, &w, 4300600, agent, &owner, nil)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Error, agent factory ID doesn't match REST API.\n  Node: 2\n   API: 1\n")
+	chain.SetAgentFactoryID(agent1Address, 1)
+
+	// The operator isn't the one expected, and an ownership transfer is pending
+	roles.PendingOwner = common.HexToAddress("0x03")
+	chain.SetAgentRoles(agent1Address, roles)
+	w.Reset()
+	failed, err = checkAgentOwnership(ctx, &w, 4300600, agent, nil, &owner)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Error, agent operator doesn't match expected.\n")
+	assert.Contains(t, w.String(), "Agent 1 @4300601: Warning, ownership transfer pending to "+roles.PendingOwner.String()+"\n")
+
+	chain.Fail("StateLookupID", context.DeadlineExceeded)
+	_, err = checkAgentOwnership(ctx, &w, 4300600, agent, nil, nil)
+	assert.NotNil(t, err)
+}
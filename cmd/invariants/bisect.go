@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/glifio/go-pools/util"
+	"github.com/glifio/invariants"
+)
+
+// epochCheck checks an invariant for a single target at epoch, writing its results to w
+type epochCheck func(ctx context.Context, w io.Writer, epoch uint64) (failed bool, err error)
+
+// bisectOnFailure runs check at epoch. With --bisect, a failure is followed by a
+// search for the first epoch the check failed at.
+func bisectOnFailure(ctx context.Context, w io.Writer, epoch uint64, check epochCheck) (failed bool, err error) {
+	failed, err = check(ctx, w, epoch)
+	if err != nil || !failed {
+		return failed, err
+	}
+
+	enabled, err := rootCmd.PersistentFlags().GetBool("bisect")
+	if err != nil {
+		return true, err
+	}
+	if enabled {
+		bisectCheck(ctx, w, check, 0, epoch)
+	}
+
+	return true, nil
+}
+
+// bisectCheck finds the first epoch after lastPass that check fails at, up to
+// firstFail. If lastPass is 0, it first steps back from firstFail to find an epoch
+// that passes. Bisection is diagnostic, so its errors are reported but not returned.
+func bisectCheck(ctx context.Context, w io.Writer, check epochCheck, lastPass uint64, firstFail uint64) {
+	step, err := rootCmd.PersistentFlags().GetUint64("bisect-step")
+	if err != nil {
+		fmt.Fprintf(w, "Bisect: Error, %v\n", err)
+		return
+	}
+
+	pass := func(ctx context.Context, epoch uint64) (bool, error) {
+		failed, err := check(ctx, io.Discard, epoch)
+		return !failed, err
+	}
+	onStep := func(epoch uint64, passed bool) {
+		if passed {
+			fmt.Fprintf(w, "  @%d pass\n", epoch)
+		} else {
+			fmt.Fprintf(w, "  @%d fail\n", epoch)
+		}
+	}
+
+	if lastPass == 0 {
+		fmt.Fprintf(w, "Searching for a passing epoch before @%d\n", firstFail)
+		lastPass, firstFail, err = invariants.FindPassingEpoch(ctx, pass, firstFail, step, onStep)
+		if err != nil {
+			fmt.Fprintf(w, "Bisect: Error, %v\n", err)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "Bisecting between @%d and @%d\n", lastPass, firstFail)
+	result, err := invariants.BisectEpochs(ctx, pass, lastPass, firstFail, onStep)
+	if err != nil {
+		fmt.Fprintf(w, "Bisect: Error, %v\n", err)
+		return
+	}
+
+	fmt.Fprintf(w, "Last passing epoch: @%d\n", result.LastPass)
+	fmt.Fprintf(w, "First failing epoch: @%d (tipset %v, %d messages)\n",
+		result.FirstFail, result.Tipset.Key(), len(result.Messages))
+	for _, msg := range result.Messages {
+		fmt.Fprintf(w, "  %v: %v -> %v method %d, %0.3f FIL\n",
+			msg.Cid, msg.Message.From, msg.Message.To, msg.Message.Method, util.ToFIL(msg.Message.Value.Int))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBisectCheck(t *testing.T) {
+	agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	check := func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return checkAgentBalance(ctx, w, server.URL, epoch, agent)
+	}
+
+	// The API missed the push at 4300500
+	server.SetField("/agent/1/tx", "type", "push", "availableBalance", "10000000000000000000000")
+
+	var w bytes.Buffer
+	bisectCheck(ctx, &w, check, 0, 4300900)
+	assert.Contains(t, w.String(), "Searching for a passing epoch before @4300900\n")
+	assert.Contains(t, w.String(), "Last passing epoch: @4300499\n")
+	assert.Contains(t, w.String(), "First failing epoch: @4300500")
+
+	// With a passing epoch already known, the search is skipped
+	w.Reset()
+	bisectCheck(ctx, &w, check, 4300400, 4300600)
+	assert.NotContains(t, w.String(), "Searching")
+	assert.Contains(t, w.String(), "First failing epoch: @4300500")
+}
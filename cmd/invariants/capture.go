@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/glifio/invariants/singleton"
+	"github.com/spf13/viper"
+)
+
+// captureConfigFile is the file in a capture directory holding the configuration
+// the run was recorded with
+const captureConfigFile = "config.json"
+
+// captureConfig is the configuration a run was recorded with. Tokens aren't kept.
+type captureConfig struct {
+	Recorded         time.Time `json:"recorded"`
+	Args             []string  `json:"args"`
+	ChainID          int64     `json:"chainId"`
+	EventsAPI        string    `json:"eventsApi"`
+	LotusPrivateAddr string    `json:"lotusPrivateAddr"`
+	LotusArchiveAddr string    `json:"lotusArchiveAddr"`
+	Archive          bool      `json:"archive"`
+}
+
+// replayedAt is when the capture being replayed was recorded, or zero if the run
+// isn't a replay
+var replayedAt time.Time
+
+// initCapture records the events API and Lotus traffic of the run if --record is
+// set, or answers it from a previous recording if --replay is set
+func initCapture() error {
+	recordDir, err := rootCmd.PersistentFlags().GetString("record")
+	if err != nil {
+		return err
+	}
+	replayDir, err := rootCmd.PersistentFlags().GetString("replay")
+	if err != nil {
+		return err
+	}
+	cachePath, err := rootCmd.PersistentFlags().GetString("cache")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case recordDir != "" && replayDir != "":
+		return fmt.Errorf("--record and --replay can't be used together")
+
+	case recordDir != "":
+		if cachePath != "" {
+			return fmt.Errorf("--record can't be used with --cache, cached results wouldn't be recorded")
+		}
+		err = checkCaptureAddrs()
+		if err != nil {
+			return err
+		}
+		archive, err := rootCmd.PersistentFlags().GetBool("archive")
+		if err != nil {
+			return err
+		}
+		_, err = singleton.RecordTraffic(recordDir)
+		if err != nil {
+			return err
+		}
+		return writeCaptureConfig(recordDir, captureConfig{
+			Recorded:         time.Now().UTC(),
+			Args:             os.Args[1:],
+			ChainID:          viper.GetInt64("chain_id"),
+			EventsAPI:        viper.GetString("events_api"),
+			LotusPrivateAddr: viper.GetString("lotus_private_addr"),
+			LotusArchiveAddr: viper.GetString("lotus_archive_addr"),
+			Archive:          archive,
+		})
+
+	case replayDir != "":
+		config, err := readCaptureConfig(replayDir)
+		if err != nil {
+			return err
+		}
+		err = singleton.ReplayTraffic(replayDir)
+		if err != nil {
+			return err
+		}
+
+		// Requests are matched on their URL, so use the recorded addresses
+		viper.Set("chain_id", config.ChainID)
+		viper.Set("events_api", config.EventsAPI)
+		viper.Set("lotus_private_addr", config.LotusPrivateAddr)
+		viper.Set("lotus_archive_addr", config.LotusArchiveAddr)
+		err = rootCmd.PersistentFlags().Set("archive", strconv.FormatBool(config.Archive))
+		if err != nil {
+			return err
+		}
+		replayedAt = config.Recorded
+
+		if os.Getenv("QUIET") == "" {
+			fmt.Printf("Replaying %s, recorded %s\n", replayDir, config.Recorded.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// checkCaptureAddrs checks the Lotus addresses use HTTP, as websocket traffic
+// doesn't go through the HTTP transport that's recorded
+func checkCaptureAddrs() error {
+	for _, key := range []string{"lotus_private_addr", "lotus_archive_addr"} {
+		addr := viper.GetString(key)
+		u, err := url.Parse(addr)
+		if err != nil || addr == "" {
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("--record needs an http or https Lotus address, %s is %s", key, addr)
+		}
+	}
+	return nil
+}
+
+func writeCaptureConfig(dir string, config captureConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, captureConfigFile), append(data, '\n'), 0o644)
+}
+
+func readCaptureConfig(dir string) (*captureConfig, error) {
+	data, err := os.ReadFile(filepath.Join(dir, captureConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	var config captureConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", captureConfigFile, err)
+	}
+	return &config, nil
+}
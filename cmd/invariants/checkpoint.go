@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// checkpointHeader is the first line of a checkpoint, identifying the run
+type checkpointHeader struct {
+	Invariant string `json:"invariant"`
+	Epoch     uint64 `json:"epoch"`
+}
+
+// checkpointEntry is a target whose check completed, one per line after the header
+type checkpointEntry struct {
+	Target string      `json:"target"`
+	Epoch  uint64      `json:"epoch"`
+	Status checkStatus `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// checkpoint saves the targets a run has checked, so an interrupted run can be
+// resumed without checking them again. Targets that couldn't be checked aren't
+// saved, so they're retried.
+type checkpoint struct {
+	path     string
+	header   checkpointHeader
+	previous []checkResult
+	done     map[string]bool
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func addCheckpointFlags(cmd *cobra.Command) {
+	cmd.Flags().String("checkpoint", cmd.Name()+".checkpoint.jsonl", "Save the targets checked so far to this file, to resume an interrupted run from")
+	cmd.Flags().Bool("resume", false, "Skip the targets already checked at the same epoch in the checkpoint, checkpointing the run")
+}
+
+// checkpointRequested reports whether a run of cmd is checkpointed, which is
+// only when --checkpoint or --resume is set
+func checkpointRequested(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("checkpoint") || cmd.Flags().Changed("resume")
+}
+
+// readCheckpointHeader returns the header of the checkpoint at path, or nil if
+// there isn't one
+func readCheckpointHeader(path string) (*checkpointHeader, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	var header checkpointHeader
+	err = json.Unmarshal(scanner.Bytes(), &header)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %v", path, err)
+	}
+	return &header, nil
+}
+
+// resumeEpoch returns the epoch to check with --resume: the checkpoint's, unless
+// an epoch is set explicitly
+func resumeEpoch(cmd *cobra.Command, epoch uint64) (uint64, error) {
+	resume, err := cmd.Flags().GetBool("resume")
+	if err != nil || !resume || epoch != 0 {
+		return epoch, err
+	}
+	path, err := cmd.Flags().GetString("checkpoint")
+	if err != nil {
+		return epoch, err
+	}
+	header, err := readCheckpointHeader(path)
+	if err != nil || header == nil || header.Invariant != cmd.Name() {
+		return epoch, err
+	}
+	return header.Epoch, nil
+}
+
+// startCheckpoint opens the checkpoint set with --checkpoint for a run of cmd at
+// epoch, resuming it with --resume
+func startCheckpoint(cmd *cobra.Command, epoch uint64) (*checkpoint, error) {
+	path, err := cmd.Flags().GetString("checkpoint")
+	if err != nil {
+		return nil, err
+	}
+	resume, err := cmd.Flags().GetBool("resume")
+	if err != nil {
+		return nil, err
+	}
+	return openCheckpoint(path, cmd.Name(), epoch, resume)
+}
+
+// openCheckpoint starts the checkpoint of a run of invariant at epoch. With
+// resume, the targets in an existing checkpoint for the same invariant and epoch
+// are kept, otherwise it's started over.
+func openCheckpoint(path string, invariant string, epoch uint64, resume bool) (*checkpoint, error) {
+	// Epoch 0 checks the latest values, which change from one run to the next
+	if epoch == 0 {
+		return nil, fmt.Errorf("checkpoint %s: a checkpointed run needs an epoch", path)
+	}
+
+	cp := &checkpoint{
+		path:   path,
+		header: checkpointHeader{Invariant: invariant, Epoch: epoch},
+		done:   make(map[string]bool),
+	}
+
+	if resume {
+		err := cp.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if len(cp.previous) > 0 {
+		cp.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			// End any line cut short by the interrupted run
+			_, err = cp.file.Write([]byte("\n"))
+		}
+	} else {
+		cp.file, err = os.Create(path)
+		if err == nil {
+			err = cp.write(cp.header)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %v", path, err)
+	}
+	return cp, nil
+}
+
+// load reads the targets already checked from the checkpoint, if it's for the
+// same run. A line cut short by a crash is ignored.
+func (cp *checkpoint) load() error {
+	header, err := readCheckpointHeader(cp.path)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		fmt.Printf("Checkpoint %s not found, starting from the first target.\n", cp.path)
+		return nil
+	}
+	if *header != cp.header {
+		fmt.Printf("Checkpoint %s is for %s @%d, starting over for %s @%d.\n",
+			cp.path, header.Invariant, header.Epoch, cp.header.Invariant, cp.header.Epoch)
+		return nil
+	}
+
+	file, err := os.Open(cp.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		var entry checkpointEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		result := checkResult{Target: entry.Target, Epoch: entry.Epoch, Status: entry.Status,
+			Reason: entry.Reason, Resumed: true}
+		if i, ok := index[entry.Target]; ok {
+			cp.previous[i] = result
+			continue
+		}
+		index[entry.Target] = len(cp.previous)
+		cp.previous = append(cp.previous, result)
+		cp.done[entry.Target] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Resuming %s @%d: %d targets already checked.\n", cp.header.Invariant, cp.header.Epoch, len(cp.previous))
+	return nil
+}
+
+func (cp *checkpoint) write(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = cp.file.Write(append(line, '\n'))
+	return err
+}
+
+// Attach adds the results of the resumed run to report, and saves the checks
+// completed from now on to the checkpoint
+func (cp *checkpoint) Attach(report *runReport) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	report.Results = append(report.Results, cp.previous...)
+	report.checkpoint = cp
+}
+
+// Done reports whether target was checked by the resumed run
+func (cp *checkpoint) Done(target string) bool {
+	return cp.done[target]
+}
+
+// Save adds the result of a completed check to the checkpoint. Errors are left
+// out, so the target is checked again when resuming.
+func (cp *checkpoint) Save(result checkResult) error {
+	if result.Status == statusError || result.Status == statusSkipped {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.write(checkpointEntry{
+		Target: result.Target,
+		Epoch:  result.Epoch,
+		Status: result.Status,
+		Reason: result.Reason,
+	})
+}
+
+// Finish removes the checkpoint once every target has been checked, or tells how
+// to retry the ones that couldn't be
+func (cp *checkpoint) Finish(report *runReport) {
+	cp.file.Close()
+	errored := report.Count(statusError) + report.Count(statusSkipped)
+	if errored > 0 {
+		fmt.Printf("%d targets couldn't be checked, rerun with --resume to retry them from %s.\n", errored, cp.path)
+		return
+	}
+	err := os.Remove(cp.path)
+	if err != nil {
+		fmt.Printf("Failed to remove checkpoint: %v\n", err)
+	}
+}
+
+// remaining returns the items whose target hasn't been checked yet
+func remaining[T any](cp *checkpoint, items []T, name func(item T) string) []T {
+	var left []T
+	for _, item := range items {
+		if !cp.Done(name(item)) {
+			left = append(left, item)
+		}
+	}
+	return left
+}
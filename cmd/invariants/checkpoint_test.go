@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "agent-balances.checkpoint.jsonl")
+
+	var mu sync.Mutex
+	var checked []uint64
+	check := func(ctx context.Context, w io.Writer, agentID uint64) (bool, error) {
+		mu.Lock()
+		checked = append(checked, agentID)
+		mu.Unlock()
+		switch agentID {
+		case 2:
+			return true, nil
+		case 3:
+			return true, errors.New("deadline exceeded")
+		}
+		return false, nil
+	}
+	agents := []uint64{1, 2, 3, 4}
+
+	cp, err := openCheckpoint(path, "agent-balances", 4300600, false)
+	assert.Nil(t, err)
+	report := &runReport{Epoch: 4300600}
+	cp.Attach(report)
+	// Interrupted after agent 3
+	runPool(ctx, report, 1, remaining(cp, agents[:3], agentTarget), agentTarget, check)
+	cp.file.Write([]byte(`{"target":"Agent 4","ep`))
+	cp.file.Close()
+
+	// Resuming at another epoch starts over
+	cp, err = openCheckpoint(path, "agent-balances", 4300700, true)
+	assert.Nil(t, err)
+	assert.False(t, cp.Done(agentTarget(1)))
+	cp.file.Close()
+	header, err := readCheckpointHeader(path)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4300700, header.Epoch)
+
+	cp, err = openCheckpoint(path, "agent-balances", 4300600, false)
+	assert.Nil(t, err)
+	report = &runReport{Epoch: 4300600}
+	cp.Attach(report)
+	runPool(ctx, report, 1, remaining(cp, agents[:3], agentTarget), agentTarget, check)
+	cp.file.Write([]byte(`{"target":"Agent 4","ep`))
+	cp.file.Close()
+
+	// Errors are retried, the cut short line is ignored
+	checked = nil
+	cp, err = openCheckpoint(path, "agent-balances", 4300600, true)
+	assert.Nil(t, err)
+	assert.True(t, cp.Done(agentTarget(1)))
+	assert.True(t, cp.Done(agentTarget(2)))
+	assert.False(t, cp.Done(agentTarget(3)))
+	report = &runReport{Epoch: 4300600}
+	cp.Attach(report)
+	runPool(ctx, report, 2, remaining(cp, agents, agentTarget), agentTarget, check)
+	assert.ElementsMatch(t, []uint64{3, 4}, checked)
+
+	assert.Equal(t, 2, report.Count(statusPass))
+	assert.Equal(t, 1, report.Count(statusFail))
+	assert.Equal(t, 1, report.Count(statusError))
+	var w bytes.Buffer
+	report.Print(&w)
+	assert.Contains(t, w.String(), "Summary, with 2 targets checked by the resumed run:")
+	assert.Contains(t, w.String(), "fail     Agent 2\n")
+
+	// The checkpoint is kept while targets couldn't be checked
+	cp.Finish(report)
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+
+	checked = nil
+	cp, err = openCheckpoint(path, "agent-balances", 4300600, true)
+	assert.Nil(t, err)
+	report = &runReport{Epoch: 4300600}
+	cp.Attach(report)
+	runPool(ctx, report, 1, remaining(cp, agents, agentTarget), agentTarget, func(ctx context.Context, w io.Writer, agentID uint64) (bool, error) {
+		checked = append(checked, agentID)
+		return false, nil
+	})
+	assert.Equal(t, []uint64{3}, checked)
+	assert.Equal(t, 0, report.Count(statusError))
+	assert.Len(t, report.Results, 4)
+
+	cp.Finish(report)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestResumeEpoch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "miner-liquidation.checkpoint.jsonl")
+	cmd := &cobra.Command{Use: "miner-liquidation"}
+	addCheckpointFlags(cmd)
+	cmd.Flags().Set("checkpoint", path)
+
+	epoch, err := resumeEpoch(cmd, 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, epoch)
+
+	cp, err := openCheckpoint(path, "miner-liquidation", 4300600, false)
+	assert.Nil(t, err)
+	cp.file.Close()
+
+	epoch, err = resumeEpoch(cmd, 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, epoch, "only with --resume")
+
+	cmd.Flags().Set("resume", "true")
+	epoch, err = resumeEpoch(cmd, 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4300600, epoch)
+
+	epoch, err = resumeEpoch(cmd, 4300700)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4300700, epoch, "an explicit epoch wins")
+}
+
+func TestCheckpointNeedsEpoch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-balances.checkpoint.jsonl")
+
+	// The latest values change between runs, so they can't be resumed
+	_, err := openCheckpoint(path, "agent-balances", 0, false)
+	assert.ErrorContains(t, err, "a checkpointed run needs an epoch")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// A checkpointed agent-balances --all pins the epoch even without --epoch or
+	// --epoch-policy
+	assert.True(t, pinBalancesEpoch(agentBalancesCmd, 0, true))
+	assert.True(t, pinBalancesEpoch(agentBalancesCmd, 4300600, false))
+}
+
+func TestCheckpointRequested(t *testing.T) {
+	cmd := &cobra.Command{Use: "agent-balances"}
+	addCheckpointFlags(cmd)
+	assert.False(t, checkpointRequested(cmd), "checkpointing is opt-in")
+
+	assert.Nil(t, cmd.Flags().Set("resume", "true"))
+	assert.True(t, checkpointRequested(cmd))
+
+	cmd = &cobra.Command{Use: "agent-balances"}
+	addCheckpointFlags(cmd)
+	assert.Nil(t, cmd.Flags().Set("checkpoint", "run.jsonl"))
+	assert.True(t, checkpointRequested(cmd))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/invariants/singleton"
+)
+
+const (
+	EpochPolicyLatest    = "latest"
+	EpochPolicyFinalized = "finalized"
+	EpochPolicyExplicit  = "explicit"
+
+	DefaultEpochPolicy = "head-3"
+)
+
+// epochSelection is the epoch a command checks, along with the tipset it was
+// selected from so a reorg during the run can be detected
+type epochSelection struct {
+	Epoch  uint64
+	Policy string
+	Key    types.TipSetKey
+}
+
+// selectEpoch picks the epoch to check: the explicit epoch if it isn't 0, otherwise
+// the one chosen by the --epoch-policy flag. Null rounds are resolved to the
+// tipset before them, which has the same state.
+func selectEpoch(ctx context.Context, explicit uint64) (*epochSelection, error) {
+	policy, err := rootCmd.PersistentFlags().GetString("epoch-policy")
+	if err != nil {
+		return nil, err
+	}
+
+	var epoch uint64
+	if explicit != 0 {
+		epoch = explicit
+		policy = EpochPolicyExplicit
+	} else {
+		epoch, err = getPolicyEpoch(ctx, policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ts, err := singleton.Chain().ChainGetTipSetByHeight(ctx, abi.ChainEpoch(epoch))
+	if err != nil {
+		return nil, err
+	}
+
+	selection := epochSelection{
+		Epoch:  uint64(ts.Height()),
+		Policy: policy,
+		Key:    ts.Key(),
+	}
+
+	if os.Getenv("QUIET") == "" {
+		if selection.Epoch != epoch {
+			fmt.Printf("Epoch %d is a null round, checking @%d (same state)\n", epoch, selection.Epoch)
+		} else {
+			fmt.Printf("Checking @%d (%s)\n", selection.Epoch, policy)
+		}
+	}
+
+	return &selection, nil
+}
+
+// NearHead reports whether the epoch was picked near the chain head, where the
+// latest values from the REST API apply
+func (s *epochSelection) NearHead() bool {
+	return s.Policy == EpochPolicyLatest || strings.HasPrefix(s.Policy, "head-")
+}
+
+// getPolicyEpoch returns the epoch for a policy: latest, head-N or finalized
+func getPolicyEpoch(ctx context.Context, policy string) (uint64, error) {
+	switch {
+	case policy == EpochPolicyLatest:
+		return getHeadEpoch(ctx)
+
+	case policy == EpochPolicyFinalized:
+		return getFinalizedEpoch(ctx)
+
+	case strings.HasPrefix(policy, "head-"):
+		n, err := strconv.ParseUint(strings.TrimPrefix(policy, "head-"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid epoch policy %q: %v", policy, err)
+		}
+		head, err := getHeadEpoch(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if n > head {
+			return 0, fmt.Errorf("epoch policy %q is before genesis", policy)
+		}
+		return head - n, nil
+
+	default:
+		return 0, fmt.Errorf("invalid epoch policy %q, expected latest, head-<n> or finalized", policy)
+	}
+}
+
+// getFinalizedEpoch returns the latest epoch finalized by F3, or head minus the EC
+// finality if the node doesn't have an F3 certificate
+func getFinalizedEpoch(ctx context.Context) (uint64, error) {
+	// Snapshots don't have F3 certificates
+	if lotus := singleton.Lotus(); lotus != nil {
+		cert, err := lotus.Api.F3GetLatestCertificate(ctx)
+		if err == nil && cert != nil && !cert.ECChain.IsZero() {
+			return uint64(cert.ECChain.Head().Epoch), nil
+		}
+	}
+
+	head, err := getHeadEpoch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if head < singleton.FinalityEpochs {
+		return 0, nil
+	}
+	return head - singleton.FinalityEpochs, nil
+}
+
+// Reorged reports whether the tipset at the selected epoch has changed since
+// it was selected
+func (s *epochSelection) Reorged(ctx context.Context) (bool, error) {
+	ts, err := singleton.Chain().ChainGetTipSetByHeight(ctx, abi.ChainEpoch(s.Epoch))
+	if err != nil {
+		return false, err
+	}
+
+	return uint64(ts.Height()) != s.Epoch || ts.Key() != s.Key, nil
+}
+
+// recheckOnReorg runs check, and if it reports failures after the chain reorged at
+// the selected epoch, runs it once more against the new chain so the reorg
+// doesn't show up as a false alarm
+func recheckOnReorg(ctx context.Context, selection *epochSelection, check func(report *runReport)) *runReport {
+	report := &runReport{}
+	if selection != nil {
+		report.Epoch = selection.Epoch
+	}
+	// Don't count the setup of the run as part of the first check
+	defaultRecorder.take()
+	check(report)
+	if report.Count(statusFail) == 0 || selection == nil {
+		return report
+	}
+
+	reorged, err := selection.Reorged(ctx)
+	if err != nil {
+		fmt.Printf("@%d: Warning, couldn't check for a reorg: %v\n", selection.Epoch, err)
+		return report
+	}
+	if !reorged {
+		return report
+	}
+
+	fmt.Printf("@%d: Warning, chain reorged during the run, checking again.\n", selection.Epoch)
+	ts, err := singleton.Chain().ChainGetTipSetByHeight(ctx, abi.ChainEpoch(selection.Epoch))
+	if err == nil {
+		selection.Key = ts.Key()
+	}
+	report = &runReport{Epoch: selection.Epoch}
+	check(report)
+	return report
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glifio/invariants/chaintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectEpoch(t *testing.T) {
+	chain := chaintest.New(1000)
+	chain.Use(t)
+	chain.NullRound(997)
+	ctx := context.Background()
+
+	// The default policy is head-3, which is a null round
+	selection, err := selectEpoch(ctx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(996), selection.Epoch)
+	assert.Equal(t, DefaultEpochPolicy, selection.Policy)
+
+	selection, err = selectEpoch(ctx, 900)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(900), selection.Epoch)
+	assert.Equal(t, EpochPolicyExplicit, selection.Policy)
+
+	_, err = selectEpoch(ctx, 1001)
+	assert.NotNil(t, err)
+}
+
+func TestRecheckOnReorg(t *testing.T) {
+	chain := chaintest.New(1000)
+	chain.Use(t)
+	ctx := context.Background()
+
+	selection, err := selectEpoch(ctx, 990)
+	assert.Nil(t, err)
+
+	// Without a reorg, failures stand
+	var runs int
+	report := recheckOnReorg(ctx, selection, func(report *runReport) {
+		runs++
+		report.Add("Agent 1", true, nil)
+	})
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, 1, report.Count(statusFail))
+
+	// A failure caused by a reorg passes when checked again
+	runs = 0
+	report = recheckOnReorg(ctx, selection, func(report *runReport) {
+		runs++
+		if runs == 1 {
+			chain.Reorg()
+		}
+		report.Add("Agent 1", runs == 1, nil)
+	})
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, 0, report.Count(statusFail))
+	assert.Equal(t, 1, report.Count(statusPass))
+
+	reorged, err := selection.Reorged(ctx)
+	assert.Nil(t, err)
+	assert.False(t, reorged, "the selection follows the new chain")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glifio/invariants/history"
+	"github.com/spf13/cobra"
+)
+
+// comparedValue is a value compared by a check, kept for the result history
+type comparedValue struct {
+	Field string
+	API   *big.Int
+	Node  *big.Int
+}
+
+// checkRecorder collects the values compared by a check and times it
+type checkRecorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	values []comparedValue
+}
+
+func newCheckRecorder() *checkRecorder {
+	return &checkRecorder{start: time.Now()}
+}
+
+// defaultRecorder records the checks that run one at a time. Checks run
+// concurrently by runPool each have their own recorder in their context.
+var defaultRecorder = newCheckRecorder()
+
+type recorderKey struct{}
+
+func withRecorder(ctx context.Context) (context.Context, *checkRecorder) {
+	rec := newCheckRecorder()
+	return context.WithValue(ctx, recorderKey{}, rec), rec
+}
+
+func recorderFrom(ctx context.Context) *checkRecorder {
+	if rec, ok := ctx.Value(recorderKey{}).(*checkRecorder); ok {
+		return rec
+	}
+	return defaultRecorder
+}
+
+// record keeps the first value compared for each field, as later ones come from
+// bisecting other epochs
+func (rec *checkRecorder) record(field string, api *big.Int, node *big.Int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, v := range rec.values {
+		if v.Field == field {
+			return
+		}
+	}
+	rec.values = append(rec.values, comparedValue{Field: field, API: api, Node: node})
+}
+
+// take returns the values recorded and the time since the last take, and starts
+// recording the next check
+func (rec *checkRecorder) take() ([]comparedValue, time.Duration) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	values, elapsed := rec.values, time.Since(rec.start)
+	rec.values = nil
+	rec.start = time.Now()
+	return values, elapsed
+}
+
+// resultsHistory is where the results of the run are saved, if --results-db is set
+var resultsHistory struct {
+	db        *history.DB
+	invariant string
+	startedAt time.Time
+}
+
+// openResultsHistory opens the history database to save the results of a run of
+// invariant to
+func openResultsHistory(path string, invariant string) error {
+	db, err := history.Open(path)
+	if err != nil {
+		return err
+	}
+	resultsHistory.db = db
+	resultsHistory.invariant = invariant
+	resultsHistory.startedAt = time.Now()
+	return nil
+}
+
+// saveHistory saves the results of the run to the history database, if enabled
+func saveHistory(r *runReport) error {
+	if resultsHistory.db == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	var results []history.Result
+	for _, result := range r.Results {
+		// Saved by the run that checked it
+		if result.Resumed {
+			continue
+		}
+		stored := history.Result{
+			Target:   historyTarget(result.Target),
+			Epoch:    result.Epoch,
+			Status:   string(result.Status),
+			Message:  result.Reason,
+			Duration: result.Duration,
+		}
+		if result.Err != nil {
+			stored.Message = result.Err.Error()
+		}
+		if len(result.Values) == 0 {
+			results = append(results, stored)
+			continue
+		}
+		for _, v := range result.Values {
+			stored.Field, stored.API, stored.Node = v.Field, v.API, v.Node
+			results = append(results, stored)
+		}
+	}
+	r.mu.Unlock()
+
+	_, err := resultsHistory.db.AddRun(resultsHistory.invariant, resultsHistory.startedAt, results)
+	return err
+}
+
+// historyTarget drops the epoch from a target, so the same target checked at
+// different epochs is followed across runs. Checks of an invariant as a whole,
+// such as metrics, have an empty target.
+func historyTarget(target string) string {
+	var fields []string
+	for _, field := range strings.Fields(target) {
+		if !strings.HasPrefix(field, "@") {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [invariant] [--target <target> | --agent <agent-id>] [--field <field>] [--plot ascii|csv]",
+	Short: "Show failing and flapping invariants from the results history",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := rootCmd.PersistentFlags().GetString("results-db")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if path == "" {
+			log.Fatal("history needs --results-db")
+		}
+
+		filter, err := getHistoryFilter(cmd, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		runs, err := cmd.Flags().GetInt("runs")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		minChanges, err := cmd.Flags().GetInt("min-changes")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		plot, err := cmd.Flags().GetString("plot")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db, err := history.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		results, err := db.Results(filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		switch plot {
+		case "":
+			printHistory(os.Stdout, results, runs, minChanges, time.Now())
+		case "ascii":
+			history.PlotDelta(os.Stdout, results, 40)
+		case "csv":
+			err = history.WriteCSV(os.Stdout, results)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("unknown --plot %q: want ascii or csv", plot)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().String("target", "", "Only show this target, for example \"Agent 12\" or \"Miner f01234\"")
+	historyCmd.Flags().Uint64("agent", 0, "Only show this agent")
+	historyCmd.Flags().String("field", "", "Only show this field, for example pool_total_assets")
+	historyCmd.Flags().Duration("since", 0, "Only show runs in this long before now (all runs if 0)")
+	historyCmd.Flags().Int("runs", 10, "Number of recent runs to look for flapping in")
+	historyCmd.Flags().Int("min-changes", 3, "Status changes in recent runs that make an invariant flapping")
+	historyCmd.Flags().String("plot", "", "Plot the API - node difference over time instead, as ascii or csv")
+}
+
+func getHistoryFilter(cmd *cobra.Command, args []string) (history.Filter, error) {
+	var filter history.Filter
+	if len(args) == 1 {
+		filter.Invariant = args[0]
+	}
+
+	target, err := cmd.Flags().GetString("target")
+	if err != nil {
+		return filter, err
+	}
+	agentID, err := cmd.Flags().GetUint64("agent")
+	if err != nil {
+		return filter, err
+	}
+	if target != "" && agentID != 0 {
+		return filter, fmt.Errorf("--target and --agent can't both be set")
+	}
+	filter.Target = target
+	if agentID != 0 {
+		filter.Target = agentTarget(agentID)
+	}
+
+	filter.Field, err = cmd.Flags().GetString("field")
+	if err != nil {
+		return filter, err
+	}
+
+	since, err := cmd.Flags().GetDuration("since")
+	if err != nil {
+		return filter, err
+	}
+	if since > 0 {
+		filter.Since = time.Now().Add(-since)
+	}
+	return filter, nil
+}
+
+// printHistory lists the targets that are failing, and for how long, and the
+// targets that are flapping
+func printHistory(w io.Writer, results []history.Result, runs int, minChanges int, now time.Time) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No results in history.")
+		return
+	}
+
+	streaks := history.FailingStreaks(results)
+	if len(streaks) == 0 {
+		fmt.Fprintln(w, "Failing: none")
+	} else {
+		fmt.Fprintln(w, "Failing:")
+	}
+	for _, streak := range streaks {
+		lastPass := "never passed"
+		if !streak.LastPass.IsZero() {
+			lastPass = "last passed " + streak.LastPass.UTC().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "  %s: failing for %s, since %s @%d (%d runs, %s)\n",
+			historyName(streak.Invariant, streak.Target), now.Sub(streak.Since).Round(time.Minute),
+			streak.Since.UTC().Format(time.DateTime), streak.SinceEpoch, streak.Runs, lastPass)
+	}
+
+	flaps := history.Flapping(results, runs, minChanges)
+	if len(flaps) == 0 {
+		fmt.Fprintln(w, "Flapping: none")
+	} else {
+		fmt.Fprintln(w, "Flapping:")
+	}
+	for _, flap := range flaps {
+		fmt.Fprintf(w, "  %s: %d status changes in the last %d runs\n",
+			historyName(flap.Invariant, flap.Target), flap.Changes, flap.Runs)
+	}
+}
+
+func historyName(invariant string, target string) string {
+	return strings.TrimSpace(invariant + " " + target)
+}
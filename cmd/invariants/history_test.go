@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/glifio/invariants/history"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryTarget(t *testing.T) {
+	assert.Equal(t, "Agent 1", historyTarget("Agent 1 @4300000"))
+	assert.Equal(t, "Miner f01234", historyTarget("Miner f01234"))
+	assert.Equal(t, "", historyTarget("@4300000"))
+}
+
+func TestSaveHistory(t *testing.T) {
+	agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	assert.Nil(t, openResultsHistory(path, "agent-balances"))
+	defer func() {
+		resultsHistory.db.Close()
+		resultsHistory.db = nil
+	}()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	// One check run on its own, then the same concurrently after the API missed
+	// the push
+	report := &runReport{Epoch: 4300600}
+	defaultRecorder.take()
+	failed, err := checkAgentBalance(ctx, io.Discard, server.URL, 4300600, agent)
+	report.Add(agentTarget(1), failed, err)
+
+	server.SetField("/agent/1/tx", "type", "push", "availableBalance", "10000000000000000000000")
+	runPool(ctx, report, 2, []uint64{1, 1}, agentTarget, func(ctx context.Context, w io.Writer, agentID uint64) (bool, error) {
+		return checkAgentBalance(ctx, w, server.URL, 4300600, agent)
+	})
+	report.Skip(agentTarget(2), "no miners")
+
+	assert.Nil(t, saveHistory(report))
+
+	db, err := history.Open(path)
+	assert.Nil(t, err)
+	defer db.Close()
+	results, err := db.Results(history.Filter{Invariant: "agent-balances"})
+	assert.Nil(t, err)
+	assert.Len(t, results, 4)
+
+	assert.Equal(t, "Agent 1", results[0].Target)
+	assert.EqualValues(t, 4300600, results[0].Epoch)
+	assert.Equal(t, "available_balance", results[0].Field)
+	assert.Equal(t, history.StatusPass, results[0].Status)
+	assert.Equal(t, bigFIL(1500), results[0].API)
+	assert.Equal(t, bigFIL(1500), results[0].Node)
+
+	for _, result := range results[1:3] {
+		assert.Equal(t, history.StatusFail, result.Status)
+		assert.Equal(t, bigFIL(10000), result.API)
+		assert.Equal(t, bigFIL(1500), result.Node)
+	}
+
+	assert.Equal(t, "Agent 2", results[3].Target)
+	assert.Equal(t, history.StatusSkipped, results[3].Status)
+	assert.Equal(t, "no miners", results[3].Message)
+	assert.Nil(t, results[3].API)
+}
+
+func TestPrintHistory(t *testing.T) {
+	db, err := history.Open(filepath.Join(t.TempDir(), "results.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i, status := range []string{"pass", "fail", "pass", "fail", "fail"} {
+		_, err := db.AddRun("metrics", start.Add(time.Duration(i)*time.Hour), []history.Result{
+			{Epoch: uint64(4300000 + 120*i), Field: "pool_total_assets", Status: status},
+		})
+		assert.Nil(t, err)
+	}
+	results, err := db.Results(history.Filter{})
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	printHistory(&w, results, 10, 3, start.Add(5*time.Hour))
+	assert.Equal(t, `Failing:
+  metrics: failing for 2h0m0s, since 2026-10-01 15:00:00 @4300360 (2 runs, last passed 2026-10-01 14:00:00)
+Flapping:
+  metrics: 3 status changes in the last 5 runs
+`, w.String())
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"os"
 
 	"github.com/glifio/invariants"
 	"github.com/spf13/cobra"
@@ -12,7 +14,7 @@ import (
 
 // iFILTotalSupplyCmd represents the check-ifil-total-supply command
 var iFILTotalSupplyCmd = &cobra.Command{
-	Use:   "ifil-total-supply [--epoch <epoch>] [--find-missing]",
+	Use:   "ifil-total-supply [--epoch <epoch>] [--find-missing] [--from <epoch> [--to <epoch>] [--step <epochs>]]",
 	Short: "Compare the iFIL Total Supply from the API and the node",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -22,7 +24,7 @@ var iFILTotalSupplyCmd = &cobra.Command{
 
 		err := initSingleton(ctx)
 		if err != nil {
-			log.Fatal(err)
+			fatalInfrastructure(err)
 		}
 
 		epoch, err := cmd.Flags().GetUint64("epoch")
@@ -34,114 +36,86 @@ var iFILTotalSupplyCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		if epoch == 0 {
-			epoch, err = getHeadEpoch(ctx)
-			if err != nil {
-				log.Fatal(err)
-			}
-			epoch = epoch - 2
+		if findMissing {
+			rootCmd.PersistentFlags().Set("bisect", "true")
 		}
 
-		apiTotalSupply, err := invariants.GetIFILTotalSupplyFromAPI(ctx, eventsURL, epoch)
+		selection, err := selectEpoch(ctx, epoch)
 		if err != nil {
-			log.Fatal(err)
+			fatalInfrastructure(err)
 		}
+		epoch = selection.Epoch
 
-		nodeTotalSupply, resultEpoch, err := invariants.GetIFILTotalSupplyFromNode(ctx, epoch)
+		check := func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+			return checkIFILTotalSupply(ctx, w, eventsURL, epoch)
+		}
+
+		sweep, err := getSweepRange(cmd, epoch)
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		// Mutate for testing
-		// nodeTotalSupply.IFILTotalSupply = big.NewInt(1234)
-
-		if apiTotalSupply.IFILTotalSupply.Cmp(nodeTotalSupply.IFILTotalSupply) == 0 {
-			fmt.Printf("@%d: Success, iFIL total supply matches: %v\n", epoch, apiTotalSupply.IFILTotalSupply)
+		if sweep != nil {
+			report := &runReport{}
+			sweepEpochs(ctx, os.Stdout, report, "", sweep, func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+				return getIFILTotalSupplySweepValues(ctx, eventsURL, epoch)
+			}, check)
+			report.Exit("iFIL Total Supply test")
 			return
 		}
-		fmt.Printf("@%d: Error, iFIL total supply from REST API doesn't match node.\n", epoch)
-		fmt.Printf("  Node @%d: %v\n", resultEpoch, nodeTotalSupply.IFILTotalSupply)
-		fmt.Printf("   API @%d: %v\n", epoch, apiTotalSupply.IFILTotalSupply)
-		if findMissing {
-			findMissingIFILEvents(ctx, eventsURL, epoch)
-		}
-		log.Fatal("FAIL: iFIL Total Supply test had errors.")
-	},
-}
-
-const step = 10000
 
-func findMissingIFILEvents(ctx context.Context, eventsURL string, maxEpoch uint64) {
-	fmt.Println("Searching for missing iFIL events")
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			failed, err := bisectOnFailure(ctx, os.Stdout, epoch, check)
+			report.Add(fmt.Sprintf("@%d", epoch), failed, err)
+		})
 
-	var goodEpoch uint64
-	var err error
-	epoch := int64(maxEpoch)
-	for {
-		minEpoch := max(epoch-step+1, 0)
-		goodEpoch, err = searchPassingIFILTotalSupply(ctx, eventsURL, uint64(epoch), uint64(minEpoch), "")
-		if err != nil {
-			log.Fatal(err)
-		}
-		if goodEpoch != 0 {
-			break
-		}
-		epoch = epoch - step
-		if epoch < 0 {
-			log.Fatal("No passing epochs found")
-		}
-	}
-	fmt.Printf("Highest passing epoch: %v\n", goodEpoch)
+		report.Exit("iFIL Total Supply test")
+	},
 }
 
-func searchPassingIFILTotalSupply(ctx context.Context, eventsURL string, maxEpoch uint64, minEpoch uint64, indent string) (uint64, error) {
-	if minEpoch > maxEpoch {
-		return 0, nil
-	}
-	fmt.Printf("%sSearching for passing epoch between %d and %d\n", indent, minEpoch, maxEpoch)
-
-	apiTotalSupply, err := invariants.GetIFILTotalSupplyFromAPI(ctx, eventsURL, minEpoch)
+func checkIFILTotalSupply(ctx context.Context, w io.Writer, eventsURL string, epoch uint64) (failed bool, err error) {
+	apiTotalSupply, err := invariants.GetIFILTotalSupplyFromAPI(ctx, eventsURL, epoch)
 	if err != nil {
-		return 0, err
+		return true, err
 	}
 
-	nodeTotalSupply, _, err := invariants.GetIFILTotalSupplyFromNode(ctx, minEpoch)
+	nodeTotalSupply, resultEpoch, err := invariants.GetIFILTotalSupplyFromNode(ctx, epoch)
 	if err != nil {
-		return 0, err
+		return true, err
 	}
 
-	if apiTotalSupply.IFILTotalSupply.Cmp(nodeTotalSupply.IFILTotalSupply) == 0 {
-		fmt.Printf("%s@%d pass\n", indent, minEpoch)
-		splitEpoch := (maxEpoch-minEpoch)/2 + minEpoch + 1
+	// Mutate for testing
+	// nodeTotalSupply.IFILTotalSupply = big.NewInt(1234)
 
-		// Check top half
-		topEpoch, err := searchPassingIFILTotalSupply(ctx, eventsURL, maxEpoch, splitEpoch, indent+"  ")
-		if err != nil {
-			return 0, nil
-		}
-		if topEpoch != 0 {
-			return topEpoch, nil
-		}
+	ok, tol := compareValues(ctx, "ifil-total-supply", "total_supply", apiTotalSupply.IFILTotalSupply, nodeTotalSupply.IFILTotalSupply)
+	if ok {
+		fmt.Fprintf(w, "@%d: Success, iFIL total supply matches: %v\n", epoch, apiTotalSupply.IFILTotalSupply)
+		return false, nil
+	}
+	fmt.Fprintf(w, "@%d: Error, iFIL total supply from REST API doesn't match node (tolerance: %v).\n", epoch, tol)
+	fmt.Fprintf(w, "  Node @%d: %v\n", resultEpoch, nodeTotalSupply.IFILTotalSupply)
+	fmt.Fprintf(w, "   API @%d: %v\n", epoch, apiTotalSupply.IFILTotalSupply)
+	return true, nil
+}
 
-		// Check bottom half
-		bottomEpoch, err := searchPassingIFILTotalSupply(ctx, eventsURL, splitEpoch-1, minEpoch+1, indent+"  ")
-		if err != nil {
-			return 0, nil
-		}
-		if bottomEpoch != 0 {
-			return bottomEpoch, nil
-		}
-		return minEpoch, nil
-	} else {
-		fmt.Printf("%s@%d fail\n", indent, minEpoch)
+func getIFILTotalSupplySweepValues(ctx context.Context, eventsURL string, epoch uint64) ([]sweepValue, error) {
+	apiTotalSupply, err := invariants.GetIFILTotalSupplyFromAPI(ctx, eventsURL, epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeTotalSupply, _, err := invariants.GetIFILTotalSupplyFromNode(ctx, epoch)
+	if err != nil {
+		return nil, err
 	}
 
-	return 0, nil
+	return []sweepValue{
+		newSweepValue("ifil-total-supply", "total_supply", "iFILTotalSupply", apiTotalSupply.IFILTotalSupply, nodeTotalSupply.IFILTotalSupply),
+	}, nil
 }
 
 func init() {
 	rootCmd.AddCommand(iFILTotalSupplyCmd)
 	iFILTotalSupplyCmd.Flags().Uint64("epoch", 0, "Check at epoch")
-	iFILTotalSupplyCmd.Flags().Bool("find-missing", false, "Find missing transactions")
+	iFILTotalSupplyCmd.Flags().Bool("find-missing", false, "Find missing transactions (same as --bisect)")
+	addSweepFlags(iFILTotalSupplyCmd)
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// knownIssue is an accepted discrepancy: failures of an invariant for a target
+// are reported as known instead of failing the run, until the issue expires
+type knownIssue struct {
+	// Invariant is the command checking it, for example "agent-balances"
+	Invariant string `json:"invariant"`
+	// Agent or Miner limit the issue to a target, otherwise it matches them all
+	Agent uint64 `json:"agent,omitempty"`
+	Miner string `json:"miner,omitempty"`
+	// FromEpoch and ToEpoch limit the issue to an epoch range, inclusive
+	FromEpoch uint64 `json:"fromEpoch,omitempty"`
+	ToEpoch   uint64 `json:"toEpoch,omitempty"`
+	Ticket    string `json:"ticket"`
+	// Expires is the last day, in UTC, that failures are accepted
+	Expires string `json:"expires"`
+	Reason  string `json:"reason,omitempty"`
+
+	miner   address.Address
+	expires time.Time
+}
+
+// knownIssues are the issues for the invariant being checked, loaded from the
+// --known-issues file
+var knownIssues []knownIssue
+
+// loadKnownIssues reads the known issues for invariant from path
+func loadKnownIssues(path string, invariant string) ([]knownIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []knownIssue
+	err = json.Unmarshal(data, &issues)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var matching []knownIssue
+	for i, issue := range issues {
+		if issue.Invariant == "" || issue.Ticket == "" || issue.Expires == "" {
+			return nil, fmt.Errorf("%s: issue %d needs an invariant, ticket and expires date", path, i+1)
+		}
+		expires, err := time.Parse(time.DateOnly, issue.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("%s: issue %d (%s): %v", path, i+1, issue.Ticket, err)
+		}
+		// Accepted through the whole expiry day
+		issue.expires = expires.AddDate(0, 0, 1)
+		if issue.Miner != "" {
+			issue.miner, err = address.NewFromString(issue.Miner)
+			if err != nil {
+				return nil, fmt.Errorf("%s: issue %d (%s): %v", path, i+1, issue.Ticket, err)
+			}
+		}
+		if issue.Invariant == invariant {
+			matching = append(matching, issue)
+		}
+	}
+	return matching, nil
+}
+
+// Expired reports whether failures stopped being accepted before now
+func (issue *knownIssue) Expired(now time.Time) bool {
+	return !now.Before(issue.expires)
+}
+
+// Matches reports whether the issue covers a check of target at epoch. Checks
+// of an unknown epoch, 0, are only matched by issues without an epoch range.
+func (issue *knownIssue) Matches(target string, epoch uint64) bool {
+	agentID, miner, targetEpoch := parseTarget(target)
+	if targetEpoch != 0 {
+		epoch = targetEpoch
+	}
+
+	if issue.Agent != 0 && issue.Agent != agentID {
+		return false
+	}
+	if issue.Miner != "" && issue.miner != miner {
+		return false
+	}
+	if issue.FromEpoch != 0 || issue.ToEpoch != 0 {
+		if epoch == 0 || epoch < issue.FromEpoch {
+			return false
+		}
+		if issue.ToEpoch != 0 && epoch > issue.ToEpoch {
+			return false
+		}
+	}
+	return true
+}
+
+// findKnownIssue returns the known issue covering a failure of target at epoch,
+// preferring one that hasn't expired
+func findKnownIssue(target string, epoch uint64, now time.Time) *knownIssue {
+	var expired *knownIssue
+	for i := range knownIssues {
+		issue := &knownIssues[i]
+		if !issue.Matches(target, epoch) {
+			continue
+		}
+		if !issue.Expired(now) {
+			return issue
+		}
+		expired = issue
+	}
+	return expired
+}
+
+// parseTarget reads the agent, miner and epoch out of a target made by
+// agentTarget, minerTarget or a sweep, for example "Agent 12 @4300000"
+func parseTarget(target string) (agentID uint64, miner address.Address, epoch uint64) {
+	fields := strings.Fields(target)
+	for i, field := range fields {
+		switch {
+		case field == "Agent" && i+1 < len(fields):
+			agentID, _ = strconv.ParseUint(fields[i+1], 10, 64)
+		case field == "Miner" && i+1 < len(fields):
+			miner, _ = address.NewFromString(fields[i+1])
+		case strings.HasPrefix(field, "@"):
+			epoch, _ = strconv.ParseUint(strings.TrimPrefix(field, "@"), 10, 64)
+		}
+	}
+	return agentID, miner, epoch
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeKnownIssues(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "known-issues.json")
+	err := os.WriteFile(path, []byte(data), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// useKnownIssues loads the known issues for invariant until the test ends
+func useKnownIssues(t *testing.T, data string, invariant string) {
+	issues, err := loadKnownIssues(writeKnownIssues(t, data), invariant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownIssues = issues
+	t.Cleanup(func() {
+		knownIssues = nil
+	})
+}
+
+func TestLoadKnownIssues(t *testing.T) {
+	path := writeKnownIssues(t, `[
+		{"invariant": "agent-balances", "agent": 12, "ticket": "OPS-1", "expires": "2099-01-01"},
+		{"invariant": "miner-liquidation", "miner": "f01234", "ticket": "OPS-2", "expires": "2099-01-01"}
+	]`)
+	issues, err := loadKnownIssues(path, "agent-balances")
+	assert.Nil(t, err)
+	assert.Len(t, issues, 1)
+	assert.Equal(t, "OPS-1", issues[0].Ticket)
+
+	_, err = loadKnownIssues(writeKnownIssues(t, `[{"invariant": "metrics", "expires": "2099-01-01"}]`), "metrics")
+	assert.ErrorContains(t, err, "needs an invariant, ticket and expires date")
+	_, err = loadKnownIssues(writeKnownIssues(t, `[{"invariant": "metrics", "ticket": "OPS-3", "expires": "soon"}]`), "metrics")
+	assert.NotNil(t, err)
+	_, err = loadKnownIssues(writeKnownIssues(t, `[{"invariant": "miner-liquidation", "miner": "x", "ticket": "OPS-4", "expires": "2099-01-01"}]`), "metrics")
+	assert.NotNil(t, err)
+}
+
+func TestKnownIssueMatches(t *testing.T) {
+	useKnownIssues(t, `[
+		{"invariant": "miner-liquidation", "miner": "f01234", "ticket": "OPS-1", "expires": "2099-01-01"},
+		{"invariant": "miner-liquidation", "agent": 12, "fromEpoch": 100, "toEpoch": 200, "ticket": "OPS-2", "expires": "2099-01-01"}
+	]`, "miner-liquidation")
+	miner, agent := &knownIssues[0], &knownIssues[1]
+
+	assert.True(t, miner.Matches("Miner f01234", 0))
+	assert.False(t, miner.Matches("Miner f05678", 0))
+	assert.False(t, miner.Matches("Agent 12", 0))
+
+	assert.True(t, agent.Matches("Agent 12", 100))
+	assert.True(t, agent.Matches("Agent 12 @200", 0), "the target's epoch is used")
+	assert.False(t, agent.Matches("Agent 12", 201))
+	assert.False(t, agent.Matches("Agent 12", 0), "unknown epochs don't match a range")
+	assert.False(t, agent.Matches("Agent 13", 150))
+
+	day, _ := time.Parse(time.DateOnly, "2099-01-01")
+	assert.False(t, miner.Expired(day.Add(23*time.Hour)), "accepted through the expiry day")
+	assert.True(t, miner.Expired(day.AddDate(0, 0, 1)))
+}
+
+func TestReportKnownIssues(t *testing.T) {
+	useKnownIssues(t, `[
+		{"invariant": "agent-balances", "agent": 1, "ticket": "OPS-1", "expires": "2099-01-01"},
+		{"invariant": "agent-balances", "agent": 2, "ticket": "OPS-2", "expires": "2020-01-01"}
+	]`, "agent-balances")
+
+	report := &runReport{Epoch: 4300000}
+	assert.Equal(t, statusKnown, report.Add(agentTarget(1), true, nil))
+	assert.Equal(t, statusPass, report.Add(agentTarget(1), false, nil))
+	assert.Equal(t, statusFail, report.Add(agentTarget(2), true, nil), "expired issues fail")
+	assert.Equal(t, statusFail, report.Add(agentTarget(3), true, nil))
+	assert.Equal(t, 2, report.Count(statusFail))
+
+	var w bytes.Buffer
+	report.Print(&w)
+	assert.Contains(t, w.String(), "known    Agent 1: OPS-1, accepted until 2099-01-01\n")
+	assert.Contains(t, w.String(), "fail     Agent 2: known issue OPS-2 expired on 2020-01-01\n")
+	assert.Contains(t, w.String(), "fail     Agent 3\n")
+}
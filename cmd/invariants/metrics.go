@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
+	"os"
 
 	"github.com/glifio/invariants"
 	"github.com/spf13/cobra"
@@ -11,7 +15,7 @@ import (
 
 // metricsCmd represents the metrics command
 var metricsCmd = &cobra.Command{
-	Use:   "metrics [--epoch <epoch>]",
+	Use:   "metrics [--epoch <epoch>] [--from <epoch> [--to <epoch>] [--step <epochs>]]",
 	Short: "Compare the metrics from the API and the node at height",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -25,7 +29,7 @@ var metricsCmd = &cobra.Command{
 
 		err := initSingleton(ctx)
 		if err != nil {
-			log.Fatal(err)
+			fatalInfrastructure(err)
 		}
 
 		epoch, err := cmd.Flags().GetUint64("epoch")
@@ -33,83 +37,129 @@ var metricsCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		if epoch == 0 {
-			epoch, err = getHeadEpoch(ctx)
-			if err != nil {
-				log.Fatal(err)
-			}
-			epoch = epoch - 3
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
 		}
+		epoch = selection.Epoch
 
 		checkMinerCount, err := cmd.Flags().GetBool("miner-count")
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		metricsFromAPI, err := invariants.GetMetricsFromAPIAtHeight(ctx, eventsURL, epoch)
-		if err != nil {
-			log.Fatal(err)
+		check := func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+			return checkMetrics(ctx, w, eventsURL, epoch, checkMinerCount)
 		}
-		metricsFromNode, resultEpoch, err := invariants.GetMetricsFromNode(ctx, epoch)
+
+		sweep, err := getSweepRange(cmd, epoch)
 		if err != nil {
 			log.Fatal(err)
 		}
-		var minerCountFromNode uint64
-		if checkMinerCount {
-			minerCountFromNode, resultEpoch, err = invariants.GetMinerCountFromNode(ctx, epoch)
-			if err != nil {
-				log.Fatal(err)
-			}
+		if sweep != nil {
+			report := &runReport{}
+			sweepEpochs(ctx, os.Stdout, report, "", sweep, func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+				return getMetricsSweepValues(ctx, eventsURL, epoch)
+			}, check)
+			report.Exit("Metrics tests")
+			return
 		}
 
-		fail := false
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			failed, err := bisectOnFailure(ctx, os.Stdout, epoch, check)
+			report.Add(fmt.Sprintf("@%d", epoch), failed, err)
+		})
 
-		if metricsFromAPI.PoolTotalAssets.Cmp(metricsFromNode.PoolTotalAssets) == 0 {
-			fmt.Printf("@%d: Success, pool total assets matches: %v\n", epoch, metricsFromAPI.PoolTotalAssets)
-		} else {
-			fmt.Printf("@%d: Error, pool total assets from REST API doesn't match node.\n", epoch)
-			fmt.Printf("  Node @%d: %v\n", resultEpoch, metricsFromNode.PoolTotalAssets)
-			fmt.Printf("   API @%d: %v\n", epoch, metricsFromAPI.PoolTotalAssets)
-			fail = true
-		}
+		report.Exit("Metrics tests")
+	},
+}
 
-		if metricsFromAPI.PoolTotalBorrowed.Cmp(metricsFromNode.PoolTotalBorrowed) == 0 {
-			fmt.Printf("@%d: Success, pool total borrowed matches: %v\n", epoch, metricsFromAPI.PoolTotalBorrowed)
-		} else {
-			fmt.Printf("@%d: Error, pool total borrowed from REST API doesn't match node.\n", epoch)
-			fmt.Printf("  Node @%d: %v\n", resultEpoch, metricsFromNode.PoolTotalBorrowed)
-			fmt.Printf("   API @%d: %v\n", epoch, metricsFromAPI.PoolTotalBorrowed)
-			fail = true
-		}
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	metricsCmd.Flags().Bool("miner-count", false, "Check miner count (slow)")
+	addSweepFlags(metricsCmd)
+}
 
-		if metricsFromAPI.TotalAgentCount == metricsFromNode.TotalAgentCount {
-			fmt.Printf("@%d: Success, agent count matches: %v\n", epoch, metricsFromAPI.TotalAgentCount)
+func checkMetrics(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, checkMinerCount bool) (failed bool, err error) {
+	metricsFromAPI, err := invariants.GetMetricsFromAPIAtHeight(ctx, eventsURL, epoch)
+	if err != nil {
+		return true, err
+	}
+	metricsFromNode, resultEpoch, err := invariants.GetMetricsFromNode(ctx, epoch)
+	if err != nil {
+		return true, err
+	}
+	var minerCountFromNode uint64
+	if checkMinerCount {
+		minerCountFromNode, resultEpoch, err = invariants.GetMinerCountFromNode(ctx, epoch)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	fail := false
+
+	if ok, tol := compareValues(ctx, "metrics", "pool_total_assets", metricsFromAPI.PoolTotalAssets, metricsFromNode.PoolTotalAssets); ok {
+		fmt.Fprintf(w, "@%d: Success, pool total assets matches: %v\n", epoch, metricsFromAPI.PoolTotalAssets)
+	} else {
+		fmt.Fprintf(w, "@%d: Error, pool total assets from REST API doesn't match node (tolerance: %v).\n", epoch, tol)
+		fmt.Fprintf(w, "  Node @%d: %v\n", resultEpoch, metricsFromNode.PoolTotalAssets)
+		fmt.Fprintf(w, "   API @%d: %v\n", epoch, metricsFromAPI.PoolTotalAssets)
+		fail = true
+	}
+
+	if ok, tol := compareValues(ctx, "metrics", "pool_total_borrowed", metricsFromAPI.PoolTotalBorrowed, metricsFromNode.PoolTotalBorrowed); ok {
+		fmt.Fprintf(w, "@%d: Success, pool total borrowed matches: %v\n", epoch, metricsFromAPI.PoolTotalBorrowed)
+	} else {
+		fmt.Fprintf(w, "@%d: Error, pool total borrowed from REST API doesn't match node (tolerance: %v).\n", epoch, tol)
+		fmt.Fprintf(w, "  Node @%d: %v\n", resultEpoch, metricsFromNode.PoolTotalBorrowed)
+		fmt.Fprintf(w, "   API @%d: %v\n", epoch, metricsFromAPI.PoolTotalBorrowed)
+		fail = true
+	}
+
+	if ok, tol := compareValues(ctx, "metrics", "agent_count", new(big.Int).SetUint64(metricsFromAPI.TotalAgentCount), new(big.Int).SetUint64(metricsFromNode.TotalAgentCount)); ok {
+		fmt.Fprintf(w, "@%d: Success, agent count matches: %v\n", epoch, metricsFromAPI.TotalAgentCount)
+	} else {
+		fmt.Fprintf(w, "@%d: Error, agent count from REST API doesn't match node (tolerance: %v).\n", epoch, tol)
+		fmt.Fprintf(w, "  Node @%d: %v\n", resultEpoch, metricsFromNode.TotalAgentCount)
+		fmt.Fprintf(w, "   API @%d: %v\n", epoch, metricsFromAPI.TotalAgentCount)
+		fail = true
+	}
+
+	if checkMinerCount {
+		if ok, tol := compareValues(ctx, "metrics", "miner_count", new(big.Int).SetUint64(metricsFromAPI.TotalMinersCount), new(big.Int).SetUint64(minerCountFromNode)); ok {
+			fmt.Fprintf(w, "@%d: Success, miner count matches: %v\n", epoch, minerCountFromNode)
 		} else {
-			fmt.Printf("@%d: Error, agent count from REST API doesn't match node.\n", epoch)
-			fmt.Printf("  Node @%d: %v\n", resultEpoch, metricsFromNode.TotalAgentCount)
-			fmt.Printf("   API @%d: %v\n", epoch, metricsFromAPI.TotalAgentCount)
+			fmt.Fprintf(w, "@%d: Error, miner count from REST API doesn't match node (tolerance: %v).\n", epoch, tol)
+			fmt.Fprintf(w, "  Node @%d: %v\n", resultEpoch, minerCountFromNode)
+			fmt.Fprintf(w, "   API @%d: %v\n", epoch, metricsFromAPI.TotalMinersCount)
 			fail = true
 		}
+	}
 
-		if checkMinerCount {
-			if metricsFromAPI.TotalMinersCount == minerCountFromNode {
-				fmt.Printf("@%d: Success, miner count matches: %v\n", epoch, minerCountFromNode)
-			} else {
-				fmt.Printf("@%d: Error, miner count from REST API doesn't match node.\n", epoch)
-				fmt.Printf("  Node @%d: %v\n", resultEpoch, minerCountFromNode)
-				fmt.Printf("   API @%d: %v\n", epoch, metricsFromAPI.TotalMinersCount)
-				fail = true
-			}
-		}
-
-		if fail {
-			log.Fatal("FAIL: Metrics tests had errors.")
-		}
-	},
+	return fail, nil
 }
 
-func init() {
-	rootCmd.AddCommand(metricsCmd)
-	metricsCmd.Flags().Uint64("epoch", 0, "Check at epoch")
-	metricsCmd.Flags().Bool("miner-count", false, "Check miner count (slow)")
+func getMetricsSweepValues(ctx context.Context, eventsURL string, epoch uint64) ([]sweepValue, error) {
+	metricsFromAPI, err := invariants.GetMetricsFromAPIAtHeight(ctx, eventsURL, epoch)
+	if err != nil {
+		return nil, err
+	}
+	metricsFromNode, _, err := invariants.GetMetricsFromNode(ctx, epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	return []sweepValue{
+		newSweepValue("metrics", "pool_total_assets", "poolTotalAssets", metricsFromAPI.PoolTotalAssets, metricsFromNode.PoolTotalAssets),
+		newSweepValue("metrics", "pool_total_borrowed", "poolTotalBorrowed", metricsFromAPI.PoolTotalBorrowed, metricsFromNode.PoolTotalBorrowed),
+		newSweepValue(
+			"metrics",
+			"agent_count",
+			"totalAgentCount",
+			new(big.Int).SetUint64(metricsFromAPI.TotalAgentCount),
+			new(big.Int).SetUint64(metricsFromNode.TotalAgentCount),
+		),
+	}, nil
 }
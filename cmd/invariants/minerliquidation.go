@@ -3,10 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
-	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/filecoin-project/go-address"
@@ -23,7 +24,7 @@ import (
 
 // minerLiquidationCmd represents the minerLiquidation command
 var minerLiquidationCmd = &cobra.Command{
-	Use:   "miner-liquidation [miner-id] [--agent <id>] [--random <num>] [--epoch <epoch>] [--progress] [--timeout duration]",
+	Use:   "miner-liquidation [miner-id] [--agent <id>] [--all-agents [--resume]] [--random <num> [--sample <strategy>] [--seed <seed>]] [--epoch <epoch>] [--progress] [--timeout duration] [--skip-full]",
 	Short: "Compare liquidation values computed using various methods",
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -40,7 +41,7 @@ var minerLiquidationCmd = &cobra.Command{
 
 		err = initSingleton(ctx)
 		if err != nil {
-			log.Fatal(err)
+			fatalInfrastructure(err)
 		}
 
 		epoch, err := cmd.Flags().GetUint64("epoch")
@@ -48,14 +49,24 @@ var minerLiquidationCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		if epoch == 0 {
-			epoch, err = getHeadEpoch(ctx)
+		allAgents, err := cmd.Flags().GetBool("all-agents")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if allAgents {
+			epoch, err = resumeEpoch(cmd, epoch)
 			if err != nil {
 				log.Fatal(err)
 			}
-			epoch = epoch - 3
 		}
 
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
 		agentID, err := cmd.Flags().GetUint64("agent")
 		if err != nil {
 			log.Fatal(err)
@@ -66,12 +77,12 @@ var minerLiquidationCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		showProgress, err := cmd.Flags().GetBool("progress")
+		sampling, err := getMinerSampleOptions(cmd)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		allAgents, err := cmd.Flags().GetBool("all-agents")
+		showProgress, err := cmd.Flags().GetBool("progress")
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -81,118 +92,125 @@ var minerLiquidationCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		var failCount int
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		if allAgents {
-			agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+		skipFull, err := cmd.Flags().GetBool("skip-full")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		quickTimeout, err := cmd.Flags().GetDuration("quick-timeout")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sampledTimeout, err := cmd.Flags().GetDuration("sampled-timeout")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fullTimeout, err := cmd.Flags().GetDuration("full-timeout")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts := terminationOptions{
+			showProgress:   showProgress,
+			maxPctVariance: maxPctVariance,
+			quickTimeout:   quickTimeout,
+			sampledTimeout: sampledTimeout,
+			fullTimeout:    fullTimeout,
+			skipFull:       skipFull,
+		}
+
+		var cp *checkpoint
+		if allAgents && checkpointRequested(cmd) {
+			cp, err = startCheckpoint(cmd, epoch)
 			if err != nil {
 				log.Fatal(err)
 			}
+		}
 
-			for _, agent := range agents {
-				failed, err := checkTerminationsForAgent(ctx, eventsURL, agent.ID,
-					epoch, showProgress, maxPctVariance)
+		// Unlike the other commands, failures aren't bisected with --bisect: the API
+		// only has the latest termination penalties, and the previews can take
+		// minutes per miner
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if allAgents {
+				cp.Attach(report)
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
 				if err != nil {
-					log.Fatal(err)
-				}
-				if failed {
-					failCount++
-				}
-			}
-		} else if agentID != 0 {
-			failed, err := checkTerminationsForAgent(ctx, eventsURL, agentID, epoch,
-				showProgress, maxPctVariance)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if failed {
-				failCount++
-			}
-		} else {
-			if randomMiners == 0 {
-				if len(args) != 1 {
-					cmd.Usage()
+					report.Add("Agents", true, err)
 					return
 				}
 
-				minerID := args[0]
-
-				miner, err := address.NewFromString(minerID)
-				if err != nil {
-					log.Fatal(err)
+				if concurrency > 1 {
+					// Progress bars from parallel checks would overwrite each other
+					opts.showProgress = false
 				}
-
-				failed, err := checkTerminations(ctx, epoch, miner, nil, nil, "",
-					showProgress, maxPctVariance)
-				if err != nil {
-					log.Fatal(err)
+				check := func(ctx context.Context, w io.Writer, agent invariants.Agent) (bool, error) {
+					return checkTerminationsForAgent(ctx, w, eventsURL, agent.ID,
+						epoch, opts)
 				}
-				if failed {
-					failCount++
+				name := func(agent invariants.Agent) string {
+					return agentTarget(agent.ID)
 				}
+				runPool(ctx, report, concurrency, remaining(cp, agents, name), name, check)
+			} else if agentID != 0 {
+				failed, err := checkTerminationsForAgent(ctx, os.Stdout, eventsURL, agentID, epoch, opts)
+				report.Add(agentTarget(agentID), failed, err)
 			} else {
-				if len(args) != 0 {
-					cmd.Usage()
-					return
-				}
+				if randomMiners == 0 {
+					if len(args) != 1 {
+						cmd.Usage()
+						return
+					}
+
+					minerID := args[0]
 
-				if randomMiners > 0 {
-					fmt.Println("Loading agents...")
-					agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+					miner, err := address.NewFromString(minerID)
 					if err != nil {
 						log.Fatal(err)
 					}
 
-					type AgentMiner struct {
-						agent *invariants.Agent
-						miner int
+					failed, err := checkTerminations(ctx, os.Stdout, epoch, miner, nil, nil, "", opts)
+					report.Add(minerTarget(miner), failed, err)
+				} else {
+					if len(args) != 0 {
+						cmd.Usage()
+						return
 					}
-					allMiners := make([]AgentMiner, 0)
-					for _, agent := range agents {
-						for i := 1; i <= int(agent.Miners); i++ {
-							allMiners = append(allMiners, AgentMiner{&agent, i})
-						}
+
+					miners, err := loadAgentMiners(ctx, report, eventsURL)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
 					}
-					fmt.Printf("%d miners loaded.\n", len(allMiners))
 
-					if int(randomMiners) > len(allMiners) {
-						randomMiners = uint64(len(allMiners))
+					miners, err = sampleAgentMiners(sampling, report, miners, randomMiners)
+					if err != nil {
+						report.Add("Miners", true, err)
+						return
 					}
-					rand.Shuffle(len(agents), func(i, j int) {
-						allMiners[i], allMiners[j] = allMiners[j], allMiners[i]
-					})
-					for i := 0; i < int(randomMiners); i++ {
-						agentMiner := allMiners[i]
-						agent := agentMiner.agent
+					for _, m := range miners {
+						agent, miner := m.agent, m.miner
 						fmt.Printf("Agent %v @%d: %d miners, %0.3f FIL borrowed (via API)\n",
 							agent.ID, agent.Height, agent.Miners, util.ToFIL(agent.PrincipalBalance))
-
-						miners, err := invariants.GetAgentMinersFromAPI(ctx, eventsURL, agent.ID)
-						if err != nil {
-							log.Fatal(err)
-						}
-						for i, miner := range miners {
-							if i == agentMiner.miner-1 {
-								countStr := fmt.Sprintf("%d/%d", i+1, len(miners))
-								failed, err := checkTerminations(ctx, epoch, miner.MinerAddr,
-									agent, &miner, countStr, showProgress, maxPctVariance)
-								if err != nil {
-									log.Fatal(err)
-								}
-								if failed {
-									failCount++
-								}
-							}
-						}
+						countStr := fmt.Sprintf("%d/%d", m.index, m.count)
+						failed, err := checkTerminations(ctx, os.Stdout, epoch, miner.MinerAddr,
+							&agent, &miner, countStr, opts)
+						report.Add(minerTarget(miner.MinerAddr), failed, err)
 					}
-				} else {
-					cmd.Usage()
 				}
 			}
+		})
+
+		if cp != nil {
+			cp.Finish(report)
 		}
-		if failCount > 0 {
-			log.Fatal("FAIL: Miner liquidation test had errors.")
-		}
+		report.Exit("Miner liquidation test")
 	},
 }
 
@@ -200,26 +218,60 @@ func init() {
 	rootCmd.AddCommand(minerLiquidationCmd)
 	minerLiquidationCmd.Flags().Uint64("epoch", 0, "Check at epoch")
 	minerLiquidationCmd.Flags().Uint64("random", 0, "Randomly select miners")
+	addMinerSampleFlags(minerLiquidationCmd)
 	minerLiquidationCmd.Flags().Uint64("agent", 0, "Select only miners for a specific agent")
 	minerLiquidationCmd.Flags().Bool("all-agents", false, "Loop over all agents")
 	minerLiquidationCmd.Flags().Bool("progress", true, "Show progress bar")
 	minerLiquidationCmd.Flags().Duration("timeout", time.Duration(15*time.Minute), "Stop query after timeout")
-	minerLiquidationCmd.Flags().Float64("max-pct-variance", 5.0, "Acceptable percentage difference between quick and full methods")
+	minerLiquidationCmd.Flags().Float64("max-pct-variance", 5.0, "Acceptable percentage difference between quick and full methods, unless set in the config file")
+	minerLiquidationCmd.Flags().Duration("quick-timeout", 0, "Timeout for the quick method (0 uses --timeout)")
+	minerLiquidationCmd.Flags().Duration("sampled-timeout", 0, "Timeout for the sampled method (0 uses --timeout)")
+	minerLiquidationCmd.Flags().Duration("full-timeout", 0, "Timeout for the full method, reporting partial results when exceeded (0 uses --timeout)")
+	minerLiquidationCmd.Flags().Bool("skip-full", false, "Skip the full method and only compare quick vs sampled")
+	minerLiquidationCmd.Flags().Int("concurrency", 1, "Number of agents to check in parallel with --all-agents")
+	addCheckpointFlags(minerLiquidationCmd)
+}
+
+// loadAgentMiners lists the miners of every agent from the API. An agent whose
+// miners can't be listed is reported as an error, and its miners left out.
+func loadAgentMiners(ctx context.Context, report *runReport, eventsURL string) ([]agentMiner, error) {
+	fmt.Println("Loading agents...")
+	agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var miners []agentMiner
+	for _, agent := range agents {
+		if agent.Miners == 0 {
+			continue
+		}
+		agentMiners, err := invariants.GetAgentMinersFromAPI(ctx, eventsURL, agent.ID)
+		if err != nil {
+			report.Add(agentTarget(agent.ID), true, err)
+			continue
+		}
+		for i, miner := range agentMiners {
+			miners = append(miners, agentMiner{agent: agent, miner: miner, index: i + 1, count: len(agentMiners)})
+		}
+	}
+	fmt.Printf("%d miners loaded.\n", len(miners))
+	return miners, nil
 }
 
 func checkTerminationsForAgent(
 	ctx context.Context,
+	w io.Writer,
 	eventsURL string,
 	agentID uint64,
 	epoch uint64,
-	showProgress bool,
-	maxPctVariance float64,
+	opts terminationOptions,
 ) (failed bool, err error) {
 	agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
 	if err != nil {
 		return true, err
 	}
-	fmt.Printf("Agent %v @%d: %d miners, %0.3f FIL borrowed (via API)\n",
+	fmt.Fprintf(w, "Agent %v @%d: %d miners, %0.3f FIL borrowed (via API)\n",
 		agent.ID, agent.Height, agent.Miners, util.ToFIL(agent.PrincipalBalance))
 
 	miners, err := invariants.GetAgentMinersFromAPI(ctx, eventsURL, agentID)
@@ -229,8 +281,8 @@ func checkTerminationsForAgent(
 	var failCount int
 	for i, miner := range miners {
 		countStr := fmt.Sprintf("%d/%d", i+1, len(miners))
-		failed, err = checkTerminations(ctx, epoch, miner.MinerAddr, agent, &miner,
-			countStr, showProgress, maxPctVariance)
+		failed, err = checkTerminations(ctx, w, epoch, miner.MinerAddr, agent, &miner,
+			countStr, opts)
 		if err != nil {
 			return true, err
 		}
@@ -244,171 +296,203 @@ func checkTerminationsForAgent(
 	return false, nil
 }
 
+type terminationOptions struct {
+	showProgress   bool
+	maxPctVariance float64
+	quickTimeout   time.Duration
+	sampledTimeout time.Duration
+	fullTimeout    time.Duration
+	skipFull       bool
+}
+
+// The termination previews checkTerminations compares, replaced in tests
+var (
+	previewQuick = func(
+		ctx context.Context,
+		miner address.Address,
+		ts *types.TipSet,
+	) (*terminate.PreviewTerminateSectorsReturn, error) {
+		return terminate.PreviewTerminateSectorsQuick(ctx, &singleton.Lotus().Api, miner, ts)
+	}
+	previewOnchain = previewTerminateSectors
+)
+
+type terminationMethodResult struct {
+	result   *terminate.PreviewTerminateSectorsReturn
+	err      error
+	timedOut bool
+	elapsed  time.Duration
+	// progress is the last partition the onchain preview reported, and
+	// sectorsProcessed the sectors of the partitions before it and of the slices
+	// of it already terminated, to report partial results on timeout
+	progress         *terminate.PreviewTerminateSectorsProgress
+	sectorsProcessed uint64
+}
+
+// partialResults describes how far a timed out onchain preview got, with the
+// penalty of the sectors processed estimated by pro-rating the quick method, as
+// the preview doesn't report the penalty until it completes
+func (r terminationMethodResult) partialResults(quick *terminate.PreviewTerminateSectorsReturn) string {
+	if r.progress == nil {
+		return "before processing any partition"
+	}
+	partial := fmt.Sprintf("%d of %d partitions and %d of %d sectors processed",
+		r.progress.DeadlinePartitionIndex, r.progress.DeadlinePartitionCount,
+		r.sectorsProcessed, quick.SectorsCount)
+	if quick.SectorsCount > 0 {
+		penalty := new(big.Int).Mul(quick.SectorStats.TerminationPenalty, new(big.Int).SetUint64(r.sectorsProcessed))
+		penalty.Div(penalty, new(big.Int).SetUint64(quick.SectorsCount))
+		partial += fmt.Sprintf(", full penalty at least %0.3f FIL (pro-rated from the quick method)", util.ToFIL(penalty))
+	}
+	return partial
+}
+
 func checkTerminations(
 	ctx context.Context,
+	w io.Writer,
 	epoch uint64,
 	miner address.Address,
 	agent *invariants.Agent,
 	minerDetails *invariants.MinerDetailsResult,
 	countStr string,
-	showProgress bool,
-	maxPctVariance float64,
+	opts terminationOptions,
 ) (failed bool, err error) {
 	if countStr != "" {
 		countStr += " "
 	}
 	prefix := ""
 	if agent == nil {
-		fmt.Printf("Checking termination burn for miner %v @%d:\n", miner, epoch)
+		fmt.Fprintf(w, "Checking termination burn for miner %v @%d:\n", miner, epoch)
 	} else {
 		prefix = fmt.Sprintf("  Agent %d: ", agent.ID)
 	}
 
-	lotus := singleton.Lotus()
-
-	ts, err := lotus.Api.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(epoch), types.EmptyTSK)
+	ts, err := singleton.Chain().ChainGetTipSetByHeight(ctx, abi.ChainEpoch(epoch))
 	if err != nil {
 		return true, err
 	}
 
 	var failCount int
+	// The methods that timed out, which make the check an error rather than a pass
+	var timedOut []string
 
-	// Quick
-	start := time.Now()
-	quickResult, err := terminate.PreviewTerminateSectorsQuick(ctx, &lotus.Api, miner, ts)
-	if err != nil {
-		return true, err
-	}
-	elapsed := time.Since(start).Seconds()
-	fmt.Printf("%sMiner %s%v @%d: Quick method: %0.3f FIL (%d of %d sectors, offchain, %0.1fs)\n",
-		prefix, countStr, miner, epoch, util.ToFIL(quickResult.SectorStats.TerminationPenalty),
-		quickResult.SectorsTerminated, quickResult.SectorsCount, elapsed)
+	// Run the quick, sampled and full methods concurrently, each with its own timeout.
+	// Returning early cancels whichever methods are still running.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Sampled, onchain
-	var sampledResult *terminate.PreviewTerminateSectorsReturn
-	errorCh := make(chan error)
-	// progressCh := make(chan *terminate.PreviewTerminateSectorsProgress)
-	resultCh := make(chan *terminate.PreviewTerminateSectorsReturn)
 	epochStr := fmt.Sprintf("@%d", epoch)
-	start = time.Now()
-	go terminate.PreviewTerminateSectors(
-		ctx,
-		&lotus.Api,
-		miner,
-		epochStr,
-		0,            // vmHeight
-		40,           // batchSize
-		270000000000, // gasLimit
-		true,         // useSampling
-		true,         // optimize
-		false,        // offchain
-		21,           // maxPartitions
-		errorCh, nil /* progressCh */, resultCh)
-
-loopSampled:
-	for {
-		select {
-		case result := <-resultCh:
-			sampledResult = result
-			elapsed = time.Since(start).Seconds()
-			fmt.Printf("%sMiner %s%v @%d: Sampled method: %0.3f FIL (%d of %d sectors, onchain, %0.1fs)\n",
-				prefix, countStr, miner, epoch, util.ToFIL(sampledResult.SectorStats.TerminationPenalty),
-				sampledResult.SectorsTerminated, sampledResult.SectorsCount, elapsed)
-			break loopSampled
-
-			/*
-				case progress := <-progressCh:
-					fmt.Printf("Progress: %+v\n", progress)
-			*/
+	quickCh := make(chan terminationMethodResult, 1)
+	sampledCh := make(chan terminationMethodResult, 1)
+	fullCh := make(chan terminationMethodResult, 1)
 
-		case err := <-errorCh:
-			log.Fatal(err)
+	go func() {
+		ctx, cancel := withOptionalTimeout(ctx, opts.quickTimeout)
+		defer cancel()
+		start := time.Now()
+		result, err := previewQuick(ctx, miner, ts)
+		quickCh <- terminationMethodResult{
+			result:   result,
+			err:      err,
+			timedOut: err != nil && ctx.Err() != nil,
+			elapsed:  time.Since(start),
 		}
-	}
-
-	if sampledResult.SectorStats.TerminationPenalty.Cmp(quickResult.SectorStats.TerminationPenalty) != 0 {
-		fmt.Printf("%sMiner %v%v: Assertion failed: Quick vs Sampled don't match\n",
-			prefix, countStr, miner)
-		failCount++
-	}
+	}()
 
-	// Full
-	var fullResult *terminate.PreviewTerminateSectorsReturn
-	var bar *progressbar.ProgressBar
-	defer func() {
-		if bar != nil {
-			bar.Close()
-		}
+	go func() {
+		ctx, cancel := withOptionalTimeout(ctx, opts.sampledTimeout)
+		defer cancel()
+		sampledCh <- previewOnchain(ctx, miner, epochStr, true, false)
 	}()
-	errorCh = make(chan error)
-	progressCh := make(chan *terminate.PreviewTerminateSectorsProgress)
-	resultCh = make(chan *terminate.PreviewTerminateSectorsReturn)
-	start = time.Now()
-	go terminate.PreviewTerminateSectors(ctx, &lotus.Api, miner, epochStr, 0, 0, 0,
-		false, false, false, 0, errorCh, progressCh, resultCh)
-	var lastDeadlinePartIdx int = -1
 
-loopFull:
-	for {
-		select {
-		case result := <-resultCh:
-			fullResult = result
-			elapsedDuration := time.Since(start).Round(time.Second)
-			if bar != nil {
-				bar.Close()
-				bar = nil
-			}
-			fmt.Printf("%sMiner %s%v @%d: Full method: %0.3f FIL (%d of %d sectors, onchain, %s)\n",
-				prefix, countStr, miner, epoch, util.ToFIL(fullResult.SectorStats.TerminationPenalty),
-				fullResult.SectorsTerminated, fullResult.SectorsCount, elapsedDuration)
-			break loopFull
+	if !opts.skipFull {
+		go func() {
+			ctx, cancel := withOptionalTimeout(ctx, opts.fullTimeout)
+			defer cancel()
+			fullCh <- previewOnchain(ctx, miner, epochStr, false, opts.showProgress)
+		}()
+	}
 
-		case progress := <-progressCh:
-			if showProgress && bar == nil && progress.DeadlinePartitionCount > 0 {
-				bar = progressbar.NewOptions(progress.DeadlinePartitionCount,
-					progressbar.OptionSetDescription("Partitions"),
-					progressbar.OptionSetWriter(os.Stderr),
-					progressbar.OptionSetWidth(10),
-					progressbar.OptionThrottle(65*time.Millisecond),
-					progressbar.OptionShowCount(),
-					progressbar.OptionShowIts(),
-					progressbar.OptionSpinnerType(14),
-					progressbar.OptionFullWidth(),
-					progressbar.OptionSetRenderBlankState(true),
-					progressbar.OptionClearOnFinish())
-			}
-			if bar != nil && progress.DeadlinePartitionIndex != lastDeadlinePartIdx {
-				lastDeadlinePartIdx = progress.DeadlinePartitionIndex
-				bar.Add(1)
-			}
+	// Quick
+	quick := <-quickCh
+	if quick.timedOut {
+		fmt.Fprintf(w, "%sMiner %s%v @%d: Quick method: timed out after %s\n",
+			prefix, countStr, miner, epoch, quick.elapsed.Round(time.Second))
+		return true, fmt.Errorf("quick method timed out after %s", quick.elapsed.Round(time.Second))
+	}
+	if quick.err != nil {
+		return true, fmt.Errorf("quick method: %w", quick.err)
+	}
+	quickResult := quick.result
+	fmt.Fprintf(w, "%sMiner %s%v @%d: Quick method: %0.3f FIL (%d of %d sectors, offchain, %0.1fs)\n",
+		prefix, countStr, miner, epoch, util.ToFIL(quickResult.SectorStats.TerminationPenalty),
+		quickResult.SectorsTerminated, quickResult.SectorsCount, quick.elapsed.Seconds())
 
-		case err := <-errorCh:
-			log.Fatal(err)
+	// Sampled, onchain
+	sampled := <-sampledCh
+	if sampled.timedOut {
+		fmt.Fprintf(w, "%sMiner %s%v @%d: Sampled method: timed out after %s\n",
+			prefix, countStr, miner, epoch, sampled.elapsed.Round(time.Second))
+		timedOut = append(timedOut, "sampled")
+	} else if sampled.err != nil {
+		return true, fmt.Errorf("sampled method: %w", sampled.err)
+	} else {
+		sampledResult := sampled.result
+		fmt.Fprintf(w, "%sMiner %s%v @%d: Sampled method: %0.3f FIL (%d of %d sectors, onchain, %0.1fs)\n",
+			prefix, countStr, miner, epoch, util.ToFIL(sampledResult.SectorStats.TerminationPenalty),
+			sampledResult.SectorsTerminated, sampledResult.SectorsCount, sampled.elapsed.Seconds())
+
+		tol := toleranceFor("miner-liquidation", "sampled_penalty", exactTolerance)
+		recorderFrom(ctx).record("sampled_penalty", sampledResult.SectorStats.TerminationPenalty, quickResult.SectorStats.TerminationPenalty)
+		if !tol.Within(sampledResult.SectorStats.TerminationPenalty, quickResult.SectorStats.TerminationPenalty) {
+			fmt.Fprintf(w, "%sMiner %v%v: Assertion failed: Quick vs Sampled don't match (tolerance: %v)\n",
+				prefix, countStr, miner, tol)
+			failCount++
 		}
 	}
 
-	if bar != nil {
-		bar.Close()
-		// fmt.Println()
-		bar = nil
+	// Full
+	var fullResult *terminate.PreviewTerminateSectorsReturn
+	if opts.skipFull {
+		fmt.Fprintf(w, "%sMiner %s%v @%d: Full method: skipped\n", prefix, countStr, miner, epoch)
+	} else {
+		full := <-fullCh
+		if full.timedOut {
+			fmt.Fprintf(w, "%sMiner %s%v @%d: Full method: timed out after %s, %s\n",
+				prefix, countStr, miner, epoch, full.elapsed.Round(time.Second), full.partialResults(quickResult))
+			timedOut = append(timedOut, "full")
+		} else if full.err != nil {
+			return true, fmt.Errorf("full method: %w", full.err)
+		} else {
+			fullResult = full.result
+			fmt.Fprintf(w, "%sMiner %s%v @%d: Full method: %0.3f FIL (%d of %d sectors, onchain, %s)\n",
+				prefix, countStr, miner, epoch, util.ToFIL(fullResult.SectorStats.TerminationPenalty),
+				fullResult.SectorsTerminated, fullResult.SectorsCount, full.elapsed.Round(time.Second))
+		}
 	}
 
 	if minerDetails != nil {
 		apiDiff := new(big.Int).Sub(minerDetails.TerminationPenalty, quickResult.SectorStats.TerminationPenalty)
 		// For testing assertion
 		// apiDiff, _ = new(big.Int).SetString("650000000000000000", 10)
-		fmt.Printf("%sMiner %s%v: Termination penalty via API: %0.3f FIL\n",
+		fmt.Fprintf(w, "%sMiner %s%v: Termination penalty via API: %0.3f FIL\n",
 			prefix, countStr, miner, util.ToFIL(minerDetails.TerminationPenalty))
 
 		// Assert that db value from API is withing range
 		pctApi, _ := getPct(apiDiff, quickResult.SectorStats.TerminationPenalty, agent)
-		if pctApi > maxPctVariance {
-			fmt.Printf("%sMiner %v%v: Assertion failed: API vs Quick %0.3f%% > %0.3f%%\n",
-				prefix, countStr, miner, pctApi, maxPctVariance)
+		tol := toleranceFor("miner-liquidation", "api_penalty", pctTolerance(opts.maxPctVariance))
+		recorderFrom(ctx).record("api_penalty", minerDetails.TerminationPenalty, quickResult.SectorStats.TerminationPenalty)
+		if !tol.Within(minerDetails.TerminationPenalty, quickResult.SectorStats.TerminationPenalty) {
+			fmt.Fprintf(w, "%sMiner %v%v: Assertion failed: API vs Quick %0.3f%% (tolerance: %v)\n",
+				prefix, countStr, miner, pctApi, tol)
 			failCount++
 		}
 	}
 
+	if fullResult == nil {
+		return terminationsFailed(failCount, timedOut)
+	}
+
 	// Variances
 	fullVsQuick := new(big.Int).Sub(
 		fullResult.SectorStats.TerminationPenalty,
@@ -416,7 +500,7 @@ loopFull:
 	)
 
 	if fullVsQuick.Sign() == 0 {
-		fmt.Printf("%sMiner %s%v: Quick method and Full method agree (%d/%d sectors).\n",
+		fmt.Fprintf(w, "%sMiner %s%v: Quick method and Full method agree (%d/%d sectors).\n",
 			prefix, countStr, miner, quickResult.SectorsTerminated, quickResult.SectorsCount)
 	} else {
 		var pctNum float64
@@ -424,27 +508,159 @@ loopFull:
 		if fullVsQuick.Sign() == -1 {
 			fullVsQuick = new(big.Int).Abs(fullVsQuick)
 			pctNum, pctStr = getPct(fullVsQuick, fullResult.SectorStats.TerminationPenalty, agent)
-			fmt.Printf("%sMiner %s%v: Quick method overestimated: %0.3f FIL (%s, %d/%d sectors)\n",
+			fmt.Fprintf(w, "%sMiner %s%v: Quick method overestimated: %0.3f FIL (%s, %d/%d sectors)\n",
 				prefix, countStr, miner, util.ToFIL(fullVsQuick), pctStr,
 				quickResult.SectorsTerminated, quickResult.SectorsCount)
 		} else {
 			pctNum, pctStr = getPct(fullVsQuick, fullResult.SectorStats.TerminationPenalty, agent)
-			fmt.Printf("%sMiner %s%v: Quick method UNDERESTIMATED: %0.3f FIL (%s, %d/%d sectors)\n",
+			fmt.Fprintf(w, "%sMiner %s%v: Quick method UNDERESTIMATED: %0.3f FIL (%s, %d/%d sectors)\n",
 				prefix, countStr, miner, util.ToFIL(fullVsQuick), pctStr,
 				quickResult.SectorsTerminated, quickResult.SectorsCount)
 		}
-		if pctNum > maxPctVariance {
-			fmt.Printf("%sMiner %v%v: Assertion failed: Quick vs Full diff %0.3f%% > %0.3f%%\n",
-				prefix, countStr, miner, pctNum, maxPctVariance)
+		tol := toleranceFor("miner-liquidation", "full_penalty", pctTolerance(opts.maxPctVariance))
+		recorderFrom(ctx).record("full_penalty", quickResult.SectorStats.TerminationPenalty, fullResult.SectorStats.TerminationPenalty)
+		if !tol.Within(quickResult.SectorStats.TerminationPenalty, fullResult.SectorStats.TerminationPenalty) {
+			fmt.Fprintf(w, "%sMiner %v%v: Assertion failed: Quick vs Full diff %0.3f%% (tolerance: %v)\n",
+				prefix, countStr, miner, pctNum, tol)
 			failCount++
 		}
 	}
 
+	return terminationsFailed(failCount, timedOut)
+}
+
+// terminationsFailed returns the outcome of checkTerminations: failed if any
+// comparison failed, otherwise an error if a method timed out, as the values it
+// would have been compared with weren't checked
+func terminationsFailed(failCount int, timedOut []string) (bool, error) {
 	if failCount > 0 {
 		return true, nil
-	} else {
-		return false, nil
 	}
+	if len(timedOut) > 0 {
+		if len(timedOut) > 1 {
+			return true, fmt.Errorf("%s methods timed out", strings.Join(timedOut, " and "))
+		}
+		return true, fmt.Errorf("%s method timed out", timedOut[0])
+	}
+	return false, nil
+}
+
+// previewTerminateSectors runs the onchain termination preview for a miner, either
+// sampled or over every sector, until it completes or ctx is done
+func previewTerminateSectors(
+	ctx context.Context,
+	miner address.Address,
+	epochStr string,
+	sampled bool,
+	showProgress bool,
+) terminationMethodResult {
+	lotus := singleton.Lotus()
+
+	// Buffered so the preview doesn't block forever if we stop listening on timeout
+	errorCh := make(chan error, 1)
+	resultCh := make(chan *terminate.PreviewTerminateSectorsReturn, 1)
+	progressCh := make(chan *terminate.PreviewTerminateSectorsProgress)
+	// Closed when the preview returns
+	done := make(chan struct{})
+
+	start := time.Now()
+	go func() {
+		defer close(done)
+		if sampled {
+			terminate.PreviewTerminateSectors(
+				ctx,
+				&lotus.Api,
+				miner,
+				epochStr,
+				0,            // vmHeight
+				40,           // batchSize
+				270000000000, // gasLimit
+				true,         // useSampling
+				true,         // optimize
+				false,        // offchain
+				21,           // maxPartitions
+				errorCh, progressCh, resultCh)
+		} else {
+			terminate.PreviewTerminateSectors(ctx, &lotus.Api, miner, epochStr, 0, 0, 0,
+				false, false, false, 0, errorCh, progressCh, resultCh)
+		}
+	}()
+
+	var bar *progressbar.ProgressBar
+	defer func() {
+		if bar != nil {
+			bar.Close()
+		}
+	}()
+	var last *terminate.PreviewTerminateSectorsProgress
+	var sectorsProcessed uint64
+
+	for {
+		select {
+		case result := <-resultCh:
+			return terminationMethodResult{result: result, elapsed: time.Since(start)}
+
+		case progress := <-progressCh:
+			// The first progress has the miner info, the others a partition
+			if progress.DeadlinePartitionCount == 0 {
+				continue
+			}
+			if showProgress && bar == nil {
+				bar = progressbar.NewOptions(progress.DeadlinePartitionCount,
+					progressbar.OptionSetDescription("Partitions"),
+					progressbar.OptionSetWriter(os.Stderr),
+					progressbar.OptionSetWidth(10),
+					progressbar.OptionThrottle(65*time.Millisecond),
+					progressbar.OptionShowCount(),
+					progressbar.OptionShowIts(),
+					progressbar.OptionSpinnerType(14),
+					progressbar.OptionFullWidth(),
+					progressbar.OptionSetRenderBlankState(true),
+					progressbar.OptionClearOnFinish())
+			}
+			if last == nil || progress.DeadlinePartitionIndex != last.DeadlinePartitionIndex {
+				if last != nil {
+					sectorsProcessed += last.SectorsCount - last.SliceStart
+				}
+				if bar != nil {
+					bar.Add(1)
+				}
+			} else {
+				sectorsProcessed += progress.SliceStart - last.SliceStart
+			}
+			last = progress
+
+		case err := <-errorCh:
+			return terminationMethodResult{err: err, timedOut: ctx.Err() != nil, elapsed: time.Since(start)}
+
+		case <-ctx.Done():
+			// Keep draining the abandoned preview until it returns, so it can't block
+			go func() {
+				for {
+					select {
+					case <-progressCh:
+					case <-errorCh:
+					case <-done:
+						return
+					}
+				}
+			}()
+			return terminationMethodResult{
+				err:              ctx.Err(),
+				timedOut:         true,
+				elapsed:          time.Since(start),
+				progress:         last,
+				sectorsProcessed: sectorsProcessed,
+			}
+		}
+	}
+}
+
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 func getPct(diffBig *big.Int, referenceBig *big.Int, agent *invariants.Agent) (pctNum float64, pctStr string) {
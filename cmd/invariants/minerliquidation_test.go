@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/go-pools/terminate"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func terminationReturn(penalty *big.Int) *terminate.PreviewTerminateSectorsReturn {
+	return &terminate.PreviewTerminateSectorsReturn{
+		SectorStats:       &terminate.SectorStats{TerminationPenalty: penalty},
+		SectorsTerminated: 3200,
+		SectorsCount:      3200,
+	}
+}
+
+// stubPreviews replaces the termination previews with fixed results until the
+// test ends
+func stubPreviews(t *testing.T, quick, sampled, full terminationMethodResult) {
+	oldQuick, oldOnchain := previewQuick, previewOnchain
+	t.Cleanup(func() {
+		previewQuick, previewOnchain = oldQuick, oldOnchain
+	})
+
+	previewQuick = func(ctx context.Context, miner address.Address, ts *types.TipSet) (*terminate.PreviewTerminateSectorsReturn, error) {
+		return quick.result, quick.err
+	}
+	previewOnchain = func(ctx context.Context, miner address.Address, epochStr string, useSampling bool, showProgress bool) terminationMethodResult {
+		if useSampling {
+			return sampled
+		}
+		return full
+	}
+}
+
+func TestCheckTerminations(t *testing.T) {
+	agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	miners, err := invariants.GetAgentMinersFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+	miner := miners[0]
+	opts := terminationOptions{maxPctVariance: 1}
+
+	penalty := terminationMethodResult{result: terminationReturn(bigFIL(1500))}
+	overestimated := terminationMethodResult{result: terminationReturn(bigFIL(1400))}
+
+	// Every method agrees with the API
+	stubPreviews(t, penalty, penalty, penalty)
+	var w bytes.Buffer
+	failed, err := checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Contains(t, w.String(), "Quick method and Full method agree (3200/3200 sectors).")
+
+	// The quick method overestimates, which also puts the API out of range
+	stubPreviews(t, penalty, overestimated, overestimated)
+	w.Reset()
+	failed, err = checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Assertion failed: Quick vs Sampled don't match (tolerance: exact)")
+	assert.Contains(t, w.String(), "Quick method overestimated: 100.000 FIL")
+	assert.Contains(t, w.String(), "Assertion failed: Quick vs Full diff 7.143% (tolerance: ±1%)")
+
+	// A full method that times out reports how far it got, and makes the check an
+	// error rather than a pass
+	partial := terminationMethodResult{
+		err:      context.DeadlineExceeded,
+		timedOut: true,
+		elapsed:  time.Minute,
+		progress: &terminate.PreviewTerminateSectorsProgress{
+			DeadlinePartitionIndex: 10,
+			DeadlinePartitionCount: 40,
+		},
+		sectorsProcessed: 800,
+	}
+	stubPreviews(t, penalty, penalty, partial)
+	w.Reset()
+	failed, err = checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.EqualError(t, err, "full method timed out")
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Full method: timed out after 1m0s, 10 of 40 partitions and 800 of 3200 sectors processed, "+
+		"full penalty at least 375.000 FIL (pro-rated from the quick method)")
+
+	stubPreviews(t, penalty, terminationMethodResult{err: context.DeadlineExceeded, timedOut: true, elapsed: time.Second},
+		terminationMethodResult{err: context.DeadlineExceeded, timedOut: true, elapsed: time.Minute})
+	w.Reset()
+	_, err = checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.EqualError(t, err, "sampled and full methods timed out")
+	assert.Contains(t, w.String(), "Full method: timed out after 1m0s, before processing any partition")
+
+	// A comparison that failed is reported as a failure even if a method timed out
+	stubPreviews(t, penalty, overestimated, partial)
+	failed, err = checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+
+	// The quick method is the reference, so its errors stop the check
+	stubPreviews(t, terminationMethodResult{err: errors.New("actor not found")}, penalty, penalty)
+	_, err = checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.NotNil(t, err)
+
+	// and so do its timeouts
+	previewQuick = func(ctx context.Context, miner address.Address, ts *types.TipSet) (*terminate.PreviewTerminateSectorsReturn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	opts.quickTimeout = time.Millisecond
+	w.Reset()
+	failed, err = checkTerminations(ctx, &w, 4300600, miner.MinerAddr, agent, &miner, "1/1", opts)
+	assert.EqualError(t, err, "quick method timed out after 0s")
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Quick method: timed out after 0s\n")
+}
+
+func TestLoadAgentMiners(t *testing.T) {
+	server := eventstest.NewServer()
+	defer server.Close()
+
+	report := &runReport{}
+	miners, err := loadAgentMiners(context.Background(), report, server.URL)
+	assert.Nil(t, err)
+	assert.Len(t, miners, 1)
+	assert.EqualValues(t, 1, miners[0].agent.ID)
+	assert.Equal(t, "f01234", miners[0].miner.MinerAddr.String())
+	assert.Equal(t, 1, miners[0].index)
+	assert.Equal(t, 1, miners[0].count)
+	assert.Len(t, report.Results, 0)
+}
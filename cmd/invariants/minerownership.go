@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/glifio/invariants"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// minerOwnershipCmd represents the minerOwnership command
+var minerOwnershipCmd = &cobra.Command{
+	Use:   "miner-ownership [agent-id] [--all] [--random <num>] [--epoch <epoch>]",
+	Short: "Check that every miner registered to an agent is owned by the agent",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		eventsURL := viper.GetString("events_api")
+
+		err := initSingleton(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		epoch, err := cmd.Flags().GetUint64("epoch")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
+		allAgents, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		randomAgents, err := cmd.Flags().GetUint64("random")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sampling, err := getSampleOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		check := func(ctx context.Context, w io.Writer, agent *invariants.Agent) (bool, error) {
+			return bisectOnFailure(ctx, w, epoch, func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+				return checkMinerOwnership(ctx, w, eventsURL, epoch, agent)
+			})
+		}
+
+		report := recheckOnReorg(ctx, selection, func(report *runReport) {
+			if !allAgents && randomAgents == 0 {
+				if len(args) != 1 {
+					cmd.Usage()
+					return
+				}
+
+				agentID, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+				if err != nil {
+					report.Add(agentTarget(agentID), true, err)
+					return
+				}
+				if agent == nil {
+					log.Fatalf("Agent %d not found in REST API", agentID)
+				}
+
+				failed, err := check(ctx, os.Stdout, agent)
+				report.Add(agentTarget(agent.ID), failed, err)
+			} else {
+				if len(args) != 0 {
+					cmd.Usage()
+					return
+				}
+
+				agents, err := invariants.GetAgentsFromAPI(ctx, eventsURL)
+				if err != nil {
+					report.Add("Agents", true, err)
+					return
+				}
+
+				if allAgents {
+					if randomAgents > 0 {
+						cmd.Usage()
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else if randomAgents > 0 {
+					agents, err := sampleAgents(sampling, report, agents, randomAgents)
+					if err != nil {
+						report.Add("Agents", true, err)
+						return
+					}
+					for _, agent := range agents {
+						failed, err := check(ctx, os.Stdout, &agent)
+						report.Add(agentTarget(agent.ID), failed, err)
+					}
+				} else {
+					cmd.Usage()
+				}
+			}
+		})
+
+		report.Exit("Miner ownership tests")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(minerOwnershipCmd)
+	minerOwnershipCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	minerOwnershipCmd.Flags().Uint64("random", 0, "Randomly select agents")
+	addSampleFlags(minerOwnershipCmd)
+	minerOwnershipCmd.Flags().Bool("all", false, "Check all agents")
+}
+
+func checkMinerOwnership(ctx context.Context, w io.Writer, eventsURL string, epoch uint64, agent *invariants.Agent) (failed bool, err error) {
+	agentID := agent.ID
+
+	miners, err := invariants.GetAgentMinersFromAPI(ctx, eventsURL, agentID)
+	if err != nil {
+		return true, err
+	}
+	if len(miners) == 0 {
+		fmt.Fprintf(w, "Agent %d: No miners\n", agentID)
+		return false, nil
+	}
+
+	agentActorID, height, err := invariants.GetAgentActorIDFromNode(ctx, agent, epoch)
+	if err != nil {
+		return true, err
+	}
+
+	var failCount int
+	for i, miner := range miners {
+		countStr := fmt.Sprintf("%d/%d", i+1, len(miners))
+
+		ownership, _, err := invariants.GetMinerOwnershipFromNode(ctx, miner.MinerAddr, epoch)
+		if err != nil {
+			return true, err
+		}
+
+		if ownership.Owner == agentActorID {
+			fmt.Fprintf(w, "Agent %d: Miner %s %v @%d: Success, owner is agent actor %v\n",
+				agentID, countStr, miner.MinerAddr, height, agentActorID)
+		} else {
+			fmt.Fprintf(w, "Agent %d: Miner %s %v @%d: Error, owner is not the agent actor.\n",
+				agentID, countStr, miner.MinerAddr, height)
+			fmt.Fprintf(w, "   Owner: %v\n", ownership.Owner)
+			fmt.Fprintf(w, "   Agent: %v\n", agentActorID)
+			failCount++
+		}
+
+		if ownership.PendingOwner != nil {
+			fmt.Fprintf(w, "Agent %d: Miner %s %v @%d: Error, owner change to %v is pending.\n",
+				agentID, countStr, miner.MinerAddr, height, *ownership.PendingOwner)
+			failCount++
+		}
+	}
+
+	return failCount > 0, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMinerOwnership(t *testing.T) {
+	chain := agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	miner, _ := address.NewIDAddress(1234)
+	other, _ := address.NewIDAddress(999)
+	chain.SetMinerInfo(miner, lotusapi.MinerInfo{Owner: agent1ActorID, Beneficiary: agent1ActorID})
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	failed, err := checkMinerOwnership(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+	assert.Equal(t, "Agent 1: Miner 1/1 f01234 @4300601: Success, owner is agent actor f01001\n", w.String())
+
+	// The owner was changed away from the agent, and changed back pending
+	chain.SetMinerInfo(miner, lotusapi.MinerInfo{Owner: other, PendingOwnerAddress: &agent1ActorID})
+	w.Reset()
+	failed, err = checkMinerOwnership(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "Agent 1: Miner 1/1 f01234 @4300601: Error, owner is not the agent actor.\n   Owner: f0999\n   Agent: f01001\n")
+	assert.Contains(t, w.String(), "Agent 1: Miner 1/1 f01234 @4300601: Error, owner change to f01001 is pending.\n")
+
+	chain.Fail("StateMinerInfo", context.DeadlineExceeded)
+	_, err = checkMinerOwnership(ctx, &w, server.URL, 4300600, agent)
+	assert.NotNil(t, err)
+
+	// Agent 2 has no miners, so the node isn't read
+	agent2, err := invariants.GetAgentFromAPI(ctx, server.URL, 2)
+	assert.Nil(t, err)
+	w.Reset()
+	failed, err = checkMinerOwnership(ctx, &w, server.URL, 4300600, agent2)
+	assert.Nil(t, err)
+	assert.False(t, failed)
+	assert.Equal(t, "Agent 2: No miners\n", w.String())
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/singleton"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// nodeValue is one value queried from a node, or the error the node returned for it
+type nodeValue struct {
+	Agent uint64
+	Name  string
+	Value string
+	Err   error
+}
+
+// nodeDiffCmd represents the nodeDiff command
+var nodeDiffCmd = &cobra.Command{
+	Use:   "node-diff [agent-id] [--all] [--epoch <epoch>] [--max-lag <epochs>]",
+	Short: "Compare the private node with the archive node at the same epoch",
+	Long: `Compare the private node with the archive node at the same epoch
+
+Queries the same tipset, state root, pool, iFIL and agent values from both
+nodes, to detect a node that has forked, fallen behind or has corrupted state
+before blaming the events API. The events API is only used to list agents.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		eventsURL := viper.GetString("events_api")
+
+		epoch, err := cmd.Flags().GetUint64("epoch")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		allAgents, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		maxLag, err := cmd.Flags().GetUint64("max-lag")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if allAgents && len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		var agents []invariants.Agent
+		if allAgents {
+			agents, err = invariants.GetAgentsFromAPI(ctx, eventsURL)
+			if err != nil {
+				fatalInfrastructure(err)
+			}
+		} else if len(args) == 1 {
+			agentID, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				log.Fatal(err)
+			}
+			agent, err := invariants.GetAgentFromAPI(ctx, eventsURL, agentID)
+			if err != nil {
+				fatalInfrastructure(err)
+			}
+			if agent == nil {
+				log.Fatalf("Agent %d not found in REST API", agentID)
+			}
+			agents = append(agents, *agent)
+		}
+
+		private, archive, err := connectNodes(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		defer private.Close()
+		defer archive.Close()
+
+		// The epoch is selected on the private node, which the archive node
+		// has to have reached
+		singleton.UseNode(private)
+		selection, err := selectEpoch(ctx, epoch)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+		epoch = selection.Epoch
+
+		report := &runReport{}
+		err = checkNodeDiff(ctx, report, private, archive, epoch, maxLag, agents)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		report.Exit("Node diff tests")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(nodeDiffCmd)
+	nodeDiffCmd.Flags().Uint64("epoch", 0, "Check at epoch")
+	nodeDiffCmd.Flags().Bool("all", false, "Check all agents")
+	nodeDiffCmd.Flags().Uint64("max-lag", 10, "Epochs the heads of the nodes may differ by")
+}
+
+// connectNodes connects to both the private and the archive node, without using
+// the node singletons or the cache, which are shared by a single node
+func connectNodes(ctx context.Context) (private *singleton.Node, archive *singleton.Node, err error) {
+	err = initRequestRate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chainID := viper.GetInt64("chain_id")
+
+	private, err = singleton.NewNode(ctx, "Private", chainID, singleton.ChainOptions{
+		DialAddr: viper.GetString("lotus_private_addr"),
+		Token:    viper.GetString("lotus_private_token"),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to lotus node: %v", err)
+	}
+
+	archive, err = singleton.NewNode(ctx, "Archive", chainID, singleton.ChainOptions{
+		DialAddr: viper.GetString("lotus_archive_addr"),
+		Token:    viper.GetString("lotus_archive_token"),
+	})
+	if err != nil {
+		private.Close()
+		return nil, nil, fmt.Errorf("failed to connect to lotus archive node: %v", err)
+	}
+
+	if os.Getenv("QUIET") == "" {
+		fmt.Printf("Using private node: %v\n", viper.GetString("lotus_private_addr"))
+		fmt.Printf("Using archive node: %v\n", viper.GetString("lotus_archive_addr"))
+	}
+
+	return private, archive, nil
+}
+
+func checkNodeDiff(
+	ctx context.Context,
+	report *runReport,
+	private *singleton.Node,
+	archive *singleton.Node,
+	epoch uint64,
+	maxLag uint64,
+	agents []invariants.Agent,
+) error {
+	heads := make([]uint64, 2)
+	for i, node := range []*singleton.Node{private, archive} {
+		var err error
+		singleton.UseNode(node)
+		heads[i], err = getHeadEpoch(ctx)
+		if err != nil {
+			return fmt.Errorf("%s node: %v", node.Name, err)
+		}
+	}
+
+	lag := max(heads[0], heads[1]) - min(heads[0], heads[1])
+	if lag > maxLag {
+		fmt.Printf("@%d: Error, node heads are %d epochs apart.\n", epoch, lag)
+	} else {
+		fmt.Printf("@%d: Success, node heads are %d epochs apart.\n", epoch, lag)
+	}
+	fmt.Printf("  Private head: %d\n", heads[0])
+	fmt.Printf("  Archive head: %d\n", heads[1])
+	report.Add("Heads", lag > maxLag, nil)
+
+	if min(heads[0], heads[1]) < epoch {
+		fmt.Printf("@%d: Error, a node hasn't reached the epoch, not comparing state.\n", epoch)
+		report.Skip(fmt.Sprintf("@%d", epoch), "a node hasn't reached the epoch")
+		return nil
+	}
+
+	singleton.UseNode(private)
+	privateValues := getNodeValues(ctx, epoch, agents)
+	singleton.UseNode(archive)
+	archiveValues := getNodeValues(ctx, epoch, agents)
+
+	for i, p := range privateValues {
+		a := archiveValues[i]
+
+		prefix := fmt.Sprintf("@%d", epoch)
+		if p.Agent != 0 {
+			prefix = fmt.Sprintf("Agent %d @%d", p.Agent, epoch)
+		}
+
+		target := fmt.Sprintf("%s %s", prefix, p.Name)
+		if p.Err != nil && a.Err != nil {
+			// Neither node could answer, so there's nothing to compare
+			report.Add(target, true, fmt.Errorf("both nodes: %v", p.Err))
+			continue
+		}
+		if p.Value == a.Value {
+			fmt.Printf("%s: Success, %s matches: %s\n", prefix, p.Name, p.Value)
+			report.Add(target, false, nil)
+			continue
+		}
+		fmt.Printf("%s: Error, %s doesn't match between nodes.\n", prefix, p.Name)
+		fmt.Printf("  Private: %s\n", p.Value)
+		fmt.Printf("  Archive: %s\n", a.Value)
+		report.Add(target, true, nil)
+	}
+
+	return nil
+}
+
+// getNodeValues queries the values compared between nodes from the node in use.
+// Errors are kept as values, since a node failing to answer where the other one
+// can is itself a discrepancy.
+func getNodeValues(ctx context.Context, epoch uint64, agents []invariants.Agent) []nodeValue {
+	values := make([]nodeValue, 0)
+	add := func(agent uint64, name string, value any, err error) {
+		if err != nil {
+			value = fmt.Sprintf("error: %v", err)
+		}
+		values = append(values, nodeValue{Agent: agent, Name: name, Value: fmt.Sprint(value), Err: err})
+	}
+
+	state, _, err := invariants.GetTipSetStateFromNode(ctx, epoch)
+	if err != nil {
+		state = &invariants.TipSetStateResult{}
+	}
+	add(0, "tipset", state.Key, err)
+	add(0, "state root", state.StateRoot, err)
+
+	metrics, _, err := invariants.GetMetricsFromNode(ctx, epoch)
+	if err != nil {
+		metrics = &invariants.MetricsResult{}
+	}
+	add(0, "pool total assets", metrics.PoolTotalAssets, err)
+	add(0, "pool total borrowed", metrics.PoolTotalBorrowed, err)
+	add(0, "agent count", metrics.TotalAgentCount, err)
+
+	ifil, _, err := invariants.GetIFILTotalSupplyFromNode(ctx, epoch)
+	if err != nil {
+		ifil = &invariants.IFILTotalSupply{}
+	}
+	add(0, "iFIL total supply", ifil.IFILTotalSupply, err)
+
+	for _, agent := range agents {
+		balance, _, err := invariants.GetAgentActorBalanceFromNode(ctx, &agent, epoch)
+		if err != nil {
+			balance = &invariants.AgentActorBalanceResult{}
+		}
+		add(agent.ID, "actor balance", balance.ActorBalance, err)
+		add(agent.ID, "liquid assets", balance.LiquidAssets, err)
+
+		econ, _, err := invariants.GetAgentEconFromNode(ctx, agent.AddressNative, epoch)
+		if err != nil {
+			econ = &invariants.AgentEconResult{}
+		}
+		add(agent.ID, "principal", econ.Liability, err)
+	}
+
+	return values
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+type poolResult struct {
+	out    bytes.Buffer
+	failed bool
+	err    error
+	rec    *checkRecorder
+	done   chan struct{}
+	// cancelled is set for items not started before the context was done
+	cancelled error
+}
+
+// runPool calls check for each item using up to concurrency workers, adding the
+// results to report under the name of each item. Output written by each check
+// is buffered and printed in item order, so results are deterministic regardless
+// of which worker finishes first. An error only stops the check it came from.
+// Items not started when ctx is done are skipped, so they're retried on resume.
+func runPool[T any](
+	ctx context.Context,
+	report *runReport,
+	concurrency int,
+	items []T,
+	name func(item T) string,
+	check func(ctx context.Context, w io.Writer, item T) (failed bool, err error),
+) {
+	if concurrency <= 1 {
+		for _, item := range items {
+			if ctx.Err() != nil {
+				report.Skip(name(item), cancelledReason(ctx.Err()))
+				continue
+			}
+			failed, err := check(ctx, os.Stdout, item)
+			report.Add(name(item), failed, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	results := make([]*poolResult, len(items))
+	for i := range results {
+		results[i] = &poolResult{done: make(chan struct{})}
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range items {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				for _, result := range results[i:] {
+					result.cancelled = ctx.Err()
+					close(result.done)
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := results[i]
+				if ctx.Err() != nil {
+					result.cancelled = ctx.Err()
+					close(result.done)
+					continue
+				}
+				var checkCtx context.Context
+				checkCtx, result.rec = withRecorder(ctx)
+				result.failed, result.err = check(checkCtx, &result.out, items[i])
+				close(result.done)
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for i, result := range results {
+		<-result.done
+		os.Stdout.Write(result.out.Bytes())
+		if result.cancelled != nil {
+			report.Skip(name(items[i]), cancelledReason(result.cancelled))
+			continue
+		}
+		report.addRecorded(name(items[i]), report.Epoch, result.failed, result.err, result.rec)
+	}
+}
+
+func cancelledReason(err error) string {
+	return fmt.Sprintf("not started: %v", err)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPool(t *testing.T) {
+	ctx := context.Background()
+	report := &runReport{}
+	runPool(ctx, report, 3, []uint64{1, 2, 3, 4, 5}, agentTarget, func(ctx context.Context, w io.Writer, agentID uint64) (bool, error) {
+		fmt.Fprintf(w, "Agent %d\n", agentID)
+		switch agentID {
+		case 2:
+			return true, errors.New("rpc unavailable")
+		case 4:
+			return true, nil
+		}
+		return false, nil
+	})
+
+	// One check's error doesn't stop the others, and results keep the input order
+	assert.Len(t, report.Results, 5)
+	for i, result := range report.Results {
+		assert.Equal(t, agentTarget(uint64(i+1)), result.Target)
+	}
+	assert.Equal(t, statusError, report.Results[1].Status)
+	assert.EqualError(t, report.Results[1].Err, "rpc unavailable")
+	assert.Equal(t, statusFail, report.Results[3].Status)
+	assert.Equal(t, 3, report.Count(statusPass))
+}
+
+func TestRunPoolCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	report := &runReport{}
+	var started []uint64
+	runPool(ctx, report, 1, []uint64{1, 2, 3}, agentTarget, func(ctx context.Context, w io.Writer, agentID uint64) (bool, error) {
+		started = append(started, agentID)
+		// The run times out during the first check
+		cancel()
+		return true, ctx.Err()
+	})
+
+	// Only the check that was running is an error, the others are skipped
+	assert.Equal(t, []uint64{1}, started)
+	assert.Equal(t, statusError, report.Results[0].Status)
+	assert.Equal(t, 2, report.Count(statusSkipped))
+	assert.Equal(t, "not started: context canceled", report.Results[2].Reason)
+
+	// Checks skipped by concurrent workers don't take the values recorded by
+	// the checks that run one at a time
+	defaultRecorder.take()
+	defaultRecorder.record("available_balance", big.NewInt(1), big.NewInt(2))
+	report = &runReport{}
+	runPool(ctx, report, 2, []uint64{1, 2, 3}, agentTarget, func(ctx context.Context, w io.Writer, agentID uint64) (bool, error) {
+		t.Errorf("agent %d checked after the run was cancelled", agentID)
+		return false, nil
+	})
+	assert.Equal(t, 3, report.Count(statusSkipped))
+	values, _ := defaultRecorder.take()
+	assert.Len(t, values, 1)
+}
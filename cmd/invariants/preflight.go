@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/invariants/singleton"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// archiveCheckEpochs is how far back from head the archive node must have state
+const archiveCheckEpochs = 30 * 2880
+
+// maxSyncLagEpochs is how far behind its sync target the node's head may be
+const maxSyncLagEpochs = 5
+
+var networkNames = map[int64]string{
+	314:    "mainnet",
+	314159: "calibrationnet",
+}
+
+type preflightResult struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// preflightResults are the results of the checks run by initSingleton
+var preflightResults []preflightResult
+
+// preflightCmd represents the preflight command
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check that the Lotus node is healthy enough to check invariants",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		err := initSingleton(ctx)
+		if err != nil {
+			fatalInfrastructure(err)
+		}
+
+		results := preflightResults
+		if results == nil {
+			results, err = runPreflight(ctx)
+			if err != nil {
+				fatalInfrastructure(err)
+			}
+		}
+
+		for _, result := range results {
+			if result.Err != nil {
+				fmt.Printf("Preflight %s: Error, %v\n", result.Name, result.Err)
+			} else {
+				fmt.Printf("Preflight %s: Success, %s\n", result.Name, result.Detail)
+			}
+		}
+		exitOnPreflightFailure(results)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+}
+
+// preflight runs the node health checks if --preflight is set, exiting with the
+// infrastructure status if any of them fail
+func preflight(ctx context.Context) error {
+	enabled, err := rootCmd.PersistentFlags().GetBool("preflight")
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	preflightResults, err = runPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range preflightResults {
+		if result.Err != nil {
+			fmt.Printf("Preflight %s: Error, %v\n", result.Name, result.Err)
+		}
+	}
+	exitOnPreflightFailure(preflightResults)
+	return nil
+}
+
+func exitOnPreflightFailure(results []preflightResult) {
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Println("INFRASTRUCTURE: Node preflight checks failed, invariants weren't checked.")
+			os.Exit(exitInfrastructure)
+		}
+	}
+}
+
+// runPreflight checks the node in use: its head is recent, it isn't stuck syncing,
+// it's on the configured chain and, for the archive node, it has old state
+func runPreflight(ctx context.Context) ([]preflightResult, error) {
+	useArchiveNode, err := rootCmd.PersistentFlags().GetBool("archive")
+	if err != nil {
+		return nil, err
+	}
+
+	maxHeadDelay, err := rootCmd.PersistentFlags().GetDuration("max-head-delay")
+	if err != nil {
+		return nil, err
+	}
+
+	// A replayed head is compared with when it was recorded
+	now := time.Now()
+	if !replayedAt.IsZero() {
+		now = replayedAt
+	}
+
+	lotus := singleton.Lotus()
+
+	head, err := lotus.Api.ChainHead(ctx)
+	if err != nil {
+		return []preflightResult{{Name: "head", Err: fmt.Errorf("couldn't get chain head: %v", err)}}, nil
+	}
+
+	results := []preflightResult{
+		checkPreflightHead(head, maxHeadDelay, now),
+		checkPreflightSync(ctx, head),
+		checkPreflightChainID(ctx),
+		checkPreflightNetworkName(ctx),
+	}
+	if useArchiveNode {
+		results = append(results, checkPreflightArchive(ctx, head))
+	}
+	return results, nil
+}
+
+func checkPreflightHead(head *types.TipSet, maxDelay time.Duration, now time.Time) preflightResult {
+	result := preflightResult{Name: "head"}
+
+	delay := now.Sub(time.Unix(int64(head.MinTimestamp()), 0)).Truncate(time.Second)
+	if delay > maxDelay {
+		result.Err = fmt.Errorf("head @%d is %v behind wall clock (max %v)", head.Height(), delay, maxDelay)
+	} else {
+		result.Detail = fmt.Sprintf("head @%d is %v behind wall clock", head.Height(), delay)
+	}
+	return result
+}
+
+func checkPreflightSync(ctx context.Context, head *types.TipSet) preflightResult {
+	result := preflightResult{Name: "sync"}
+
+	state, err := singleton.Lotus().Api.SyncState(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't get sync state: %v", err)
+		return result
+	}
+
+	for _, sync := range state.ActiveSyncs {
+		switch sync.Stage {
+		case lotusapi.StageSyncErrored:
+			result.Err = fmt.Errorf("sync worker %d errored: %s", sync.WorkerID, sync.Message)
+			return result
+		case lotusapi.StageIdle, lotusapi.StageSyncComplete:
+			continue
+		}
+		if sync.Target != nil && sync.Target.Height()-head.Height() > maxSyncLagEpochs {
+			result.Err = fmt.Errorf("node is syncing, head @%d is %d epochs behind target @%d (%s)",
+				head.Height(), sync.Target.Height()-head.Height(), sync.Target.Height(), sync.Stage)
+			return result
+		}
+	}
+
+	result.Detail = fmt.Sprintf("%d sync workers, none behind", len(state.ActiveSyncs))
+	return result
+}
+
+func checkPreflightChainID(ctx context.Context) preflightResult {
+	result := preflightResult{Name: "chain ID"}
+
+	chainID, err := singleton.Lotus().Api.EthChainId(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't get chain ID: %v", err)
+		return result
+	}
+
+	expected := viper.GetInt64("chain_id")
+	if int64(chainID) != expected {
+		result.Err = fmt.Errorf("node chain ID %d doesn't match CHAIN_ID %d", chainID, expected)
+	} else {
+		result.Detail = fmt.Sprintf("node chain ID matches CHAIN_ID %d", expected)
+	}
+	return result
+}
+
+func checkPreflightNetworkName(ctx context.Context) preflightResult {
+	result := preflightResult{Name: "network"}
+
+	name, err := singleton.Lotus().Api.StateNetworkName(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't get network name: %v", err)
+		return result
+	}
+
+	expected, ok := networkNames[viper.GetInt64("chain_id")]
+	if !ok {
+		result.Detail = fmt.Sprintf("network %q, no expected name for CHAIN_ID", name)
+	} else if string(name) != expected {
+		result.Err = fmt.Errorf("node network %q doesn't match %q", name, expected)
+	} else {
+		result.Detail = fmt.Sprintf("node network is %q", name)
+	}
+	return result
+}
+
+func checkPreflightArchive(ctx context.Context, head *types.TipSet) preflightResult {
+	result := preflightResult{Name: "archive"}
+	lotus := singleton.Lotus()
+
+	if head.Height() <= archiveCheckEpochs {
+		result.Detail = "chain is younger than the archive check"
+		return result
+	}
+
+	ts, err := lotus.Api.ChainGetTipSetByHeight(ctx, head.Height()-abi.ChainEpoch(archiveCheckEpochs), types.EmptyTSK)
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't get tipset %d epochs before head: %v", archiveCheckEpochs, err)
+		return result
+	}
+
+	_, err = lotus.Api.StateGetActor(ctx, builtin.StorageMarketActorAddr, ts.Key())
+	if err != nil {
+		result.Err = fmt.Errorf("no state @%d: %v", ts.Height(), err)
+	} else {
+		result.Detail = fmt.Sprintf("state @%d is available", ts.Height())
+	}
+	return result
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+)
+
+type checkStatus string
+
+const (
+	statusPass    checkStatus = "pass"
+	statusFail    checkStatus = "fail"
+	statusError   checkStatus = "error"
+	statusSkipped checkStatus = "skipped"
+	// statusKnown is a failure covered by an unexpired known issue
+	statusKnown checkStatus = "known"
+)
+
+// Process exit statuses, so an invariant failure (the indexer is wrong) can be
+// told apart from an infrastructure error (the API or node couldn't be checked)
+const (
+	exitFail           = 1
+	exitInfrastructure = 2
+)
+
+type checkResult struct {
+	Target string
+	Epoch  uint64
+	Status checkStatus
+	Err    error
+	Reason string
+	// Values are the values compared, and Duration how long the check took, for
+	// the result history
+	Values   []comparedValue
+	Duration time.Duration
+	// Resumed is a result from an earlier run, read from the checkpoint
+	Resumed bool
+}
+
+// runReport collects the result of every check a command runs
+type runReport struct {
+	// Epoch is the epoch the checks run at, if they all run at the same one
+	Epoch uint64
+
+	mu      sync.Mutex
+	Results []checkResult
+	// Sample is the seed and targets picked with --random
+	Sample *runSample
+	// checkpoint saves the completed checks, if the run can be resumed
+	checkpoint *checkpoint
+}
+
+// Add records the result of checking target at the report's epoch. An error
+// means the check couldn't complete, regardless of failed.
+func (r *runReport) Add(target string, failed bool, err error) checkStatus {
+	return r.AddAt(target, r.Epoch, failed, err)
+}
+
+// AddAt records the result of checking target at epoch. A failure covered by a
+// known issue is recorded as known, unless the issue has expired.
+func (r *runReport) AddAt(target string, epoch uint64, failed bool, err error) checkStatus {
+	return r.addRecorded(target, epoch, failed, err, defaultRecorder)
+}
+
+// addRecorded records the result of a check along with the values rec recorded
+// during the check
+func (r *runReport) addRecorded(target string, epoch uint64, failed bool, err error, rec *checkRecorder) checkStatus {
+	result := checkResult{Target: target, Epoch: epoch, Status: statusPass, Err: err}
+	result.Values, result.Duration = rec.take()
+	if err != nil {
+		result.Status = statusError
+		fmt.Printf("%s: Error, check didn't complete: %v\n", target, err)
+	} else if failed {
+		result.Status = statusFail
+		if issue := findKnownIssue(target, epoch, time.Now()); issue != nil {
+			if issue.Expired(time.Now()) {
+				result.Reason = fmt.Sprintf("known issue %s expired on %s", issue.Ticket, issue.Expires)
+				fmt.Printf("%s: Error, known issue %s expired on %s and still fails.\n",
+					target, issue.Ticket, issue.Expires)
+			} else {
+				result.Status = statusKnown
+				result.Reason = fmt.Sprintf("%s, accepted until %s", issue.Ticket, issue.Expires)
+				fmt.Printf("%s: Known issue %s, accepted until %s.\n", target, issue.Ticket, issue.Expires)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.Results = append(r.Results, result)
+	cp := r.checkpoint
+	r.mu.Unlock()
+
+	if cp != nil {
+		err := cp.Save(result)
+		if err != nil {
+			log.Printf("failed to save %s to checkpoint: %v", target, err)
+		}
+	}
+	return result.Status
+}
+
+// Skip records that target wasn't checked
+func (r *runReport) Skip(target string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, checkResult{Target: target, Status: statusSkipped, Reason: reason})
+}
+
+func (r *runReport) Count(status checkStatus) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *runReport) countResumed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int
+	for _, result := range r.Results {
+		if result.Resumed {
+			count++
+		}
+	}
+	return count
+}
+
+// Print writes a summary table of the results, listing every check that didn't pass
+func (r *runReport) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if resumed := r.countResumed(); resumed > 0 {
+		fmt.Fprintf(tw, "\nSummary, with %d targets checked by the resumed run:\n", resumed)
+	} else {
+		fmt.Fprintln(tw, "\nSummary:")
+	}
+	if r.Sample != nil {
+		fmt.Fprintf(tw, "  sampled with %s\n", r.Sample)
+	}
+	for _, status := range []checkStatus{statusPass, statusFail, statusKnown, statusError, statusSkipped} {
+		fmt.Fprintf(tw, "  %s\t%d\n", status, r.Count(status))
+	}
+	for _, result := range r.Results {
+		switch result.Status {
+		case statusFail:
+			if result.Reason != "" {
+				fmt.Fprintf(tw, "  %s\t%s: %s\n", result.Status, result.Target, result.Reason)
+			} else {
+				fmt.Fprintf(tw, "  %s\t%s\n", result.Status, result.Target)
+			}
+		case statusKnown:
+			fmt.Fprintf(tw, "  %s\t%s: %s\n", result.Status, result.Target, result.Reason)
+		case statusError:
+			fmt.Fprintf(tw, "  %s\t%s: %v\n", result.Status, result.Target, result.Err)
+		case statusSkipped:
+			fmt.Fprintf(tw, "  %s\t%s: %s\n", result.Status, result.Target, result.Reason)
+		}
+	}
+	tw.Flush()
+}
+
+// Exit prints the summary, saves the results to the history, and exits with
+// exitFail if any invariant failed, or with exitInfrastructure if none failed but
+// some couldn't be checked. Known issues don't fail the run.
+func (r *runReport) Exit(name string) {
+	r.Print(os.Stdout)
+
+	err := saveHistory(r)
+	if err != nil {
+		log.Printf("failed to save results to history: %v", err)
+	}
+
+	if r.Count(statusFail) > 0 {
+		log.Printf("FAIL: %s had errors.", name)
+		os.Exit(exitFail)
+	}
+	if r.Count(statusError) > 0 {
+		log.Printf("INFRASTRUCTURE: %s couldn't be completed.", name)
+		os.Exit(exitInfrastructure)
+	}
+}
+
+// fatalInfrastructure exits with exitInfrastructure when the API or node can't be
+// reached before any invariant is checked
+func fatalInfrastructure(err error) {
+	log.Printf("INFRASTRUCTURE: %v", err)
+	os.Exit(exitInfrastructure)
+}
+
+func agentTarget(agentID uint64) string {
+	return fmt.Sprintf("Agent %d", agentID)
+}
+
+func minerTarget(miner address.Address) string {
+	return fmt.Sprintf("Miner %v", miner)
+}
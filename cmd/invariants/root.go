@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/glifio/invariants/singleton"
+	"github.com/glifio/invariants/snapshot"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -41,9 +46,22 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentPreRunE = preRun
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "mainnet", "config file (default is ./mainnet.env)")
 	rootCmd.PersistentFlags().Bool("archive", true, "use archive Lotus node")
+	rootCmd.PersistentFlags().String("epoch-policy", DefaultEpochPolicy, "epoch to check when --epoch isn't set: latest, head-<n> or finalized")
+	rootCmd.PersistentFlags().Bool("bisect", false, "on failure, bisect to find the first failing epoch")
+	rootCmd.PersistentFlags().Uint64("bisect-step", 2880, "initial number of epochs to step back when searching for a passing epoch to bisect from")
+	rootCmd.PersistentFlags().String("cache", "", "cache node results for finalized epochs in this file (disabled if empty)")
+	rootCmd.PersistentFlags().Bool("preflight", true, "check the Lotus node is healthy before checking invariants")
+	rootCmd.PersistentFlags().Duration("max-head-delay", 5*time.Minute, "how far behind wall clock the node head may be in the preflight check")
+	rootCmd.PersistentFlags().Float64("max-rps", 0, "maximum requests per second to the Lotus node (0 for no limit)")
+	rootCmd.PersistentFlags().String("record", "", "record the events API and Lotus responses of the run to this directory")
+	rootCmd.PersistentFlags().String("replay", "", "rerun offline from the responses recorded to this directory with --record")
+	rootCmd.PersistentFlags().String("known-issues", "", "JSON file of accepted discrepancies, reported as known until they expire")
+	rootCmd.PersistentFlags().String("results-db", "", "save the results of every run to this SQLite database, read by the history command (disabled if empty)")
+	rootCmd.PersistentFlags().String("snapshot", "", "read chain state from this CAR snapshot instead of a Lotus node (metrics, ifil-total-supply and agent-balances)")
 
 	viper.BindEnv("port")
 	viper.BindEnv("chain_id")
@@ -79,6 +97,10 @@ func initConfig() {
 			fmt.Println(err)
 		}
 	}
+
+	if err := initCapture(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func initSingleton(ctx context.Context) error {
@@ -87,18 +109,34 @@ func initSingleton(ctx context.Context) error {
 		return err
 	}
 
+	err = initRequestRate()
+	if err != nil {
+		return err
+	}
+
+	snapshotPath, err := rootCmd.PersistentFlags().GetString("snapshot")
+	if err != nil {
+		return err
+	}
+	if snapshotPath != "" {
+		return initSnapshot(snapshotPath)
+	}
+
 	if !useArchiveNode {
 		if os.Getenv("QUIET") == "" {
 			fmt.Printf("Using private node: %v\n", viper.GetString("lotus_private_addr"))
 		}
-		singleton.InitPoolsSDK(
+		err = singleton.InitPoolsSDK(
 			ctx,
 			viper.GetInt64("chain_id"),
 			viper.GetString("lotus_private_addr"),
 			viper.GetString("lotus_private_token"),
 		)
+		if err != nil {
+			return err
+		}
 
-		err := singleton.ConnectLotus(singleton.ChainOptions{
+		err = singleton.ConnectLotus(singleton.ChainOptions{
 			DialAddr: viper.GetString("lotus_private_addr"),
 			Token:    viper.GetString("lotus_private_token"),
 		})
@@ -109,14 +147,17 @@ func initSingleton(ctx context.Context) error {
 		if os.Getenv("QUIET") == "" {
 			fmt.Printf("Using archive node: %v\n", viper.GetString("lotus_archive_addr"))
 		}
-		singleton.InitPoolsSDK(
+		err = singleton.InitPoolsSDK(
 			ctx,
 			viper.GetInt64("chain_id"),
 			viper.GetString("lotus_archive_addr"),
 			viper.GetString("lotus_archive_token"),
 		)
+		if err != nil {
+			return err
+		}
 
-		err := singleton.ConnectArchiveLotus(singleton.ChainOptions{
+		err = singleton.ConnectArchiveLotus(singleton.ChainOptions{
 			DialAddr: viper.GetString("lotus_archive_addr"),
 			Token:    viper.GetString("lotus_archive_token"),
 		})
@@ -124,5 +165,141 @@ func initSingleton(ctx context.Context) error {
 			return fmt.Errorf("failed to connect to lotus archive node: %v", err)
 		}
 	}
+
+	err = preflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	cachePath, err := rootCmd.PersistentFlags().GetString("cache")
+	if err != nil {
+		return err
+	}
+	if cachePath != "" {
+		err = initCache(ctx, cachePath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preRun loads the tolerances and the known issues for the command, and opens the
+// results history
+func preRun(cmd *cobra.Command, args []string) error {
+	err := checkSnapshotCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	tolerances, err = loadTolerances()
+	if err != nil {
+		return err
+	}
+
+	resultsPath, err := rootCmd.PersistentFlags().GetString("results-db")
+	if err != nil {
+		return err
+	}
+	if resultsPath != "" && cmd != historyCmd {
+		err = openResultsHistory(resultsPath, cmd.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	knownIssuesPath, err := rootCmd.PersistentFlags().GetString("known-issues")
+	if err != nil {
+		return err
+	}
+	if knownIssuesPath != "" {
+		knownIssues, err = loadKnownIssues(knownIssuesPath, cmd.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotCommands are the commands that can run against a snapshot
+var snapshotCommands = map[string]bool{
+	"metrics":           true,
+	"ifil-total-supply": true,
+	"agent-balances":    true,
+}
+
+// checkSnapshotCommand fails commands that need a Lotus node if --snapshot is set
+func checkSnapshotCommand(cmd *cobra.Command) error {
+	snapshotPath, err := rootCmd.PersistentFlags().GetString("snapshot")
+	if err != nil {
+		return err
+	}
+	if snapshotPath == "" {
+		return nil
+	}
+	if !snapshotCommands[cmd.Name()] {
+		return fmt.Errorf("%s needs a Lotus node and can't be run with --snapshot", cmd.Name())
+	}
+	// The miner count is read through the go-pools SDK, which needs a node
+	if cmd == metricsCmd {
+		minerCount, err := cmd.Flags().GetBool("miner-count")
+		if err != nil {
+			return err
+		}
+		if minerCount {
+			return errors.New("--miner-count needs a Lotus node and can't be used with --snapshot")
+		}
+	}
+	return nil
+}
+
+// initSnapshot reads chain state from the snapshot at path instead of connecting
+// to a Lotus node. The preflight checks and the cache are about the node, so
+// they're skipped.
+func initSnapshot(path string) error {
+	chain, err := snapshot.Open(path, viper.GetInt64("chain_id"))
+	if err != nil {
+		return fmt.Errorf("failed to load snapshot: %v", err)
+	}
+	singleton.UseChain(chain)
+
+	if os.Getenv("QUIET") == "" {
+		from, to := chain.Range()
+		fmt.Printf("Using snapshot: %v (@%d to @%d)\n", path, from, to)
+	}
+	return nil
+}
+
+// initRequestRate limits the requests made to the Lotus nodes if --max-rps is set
+func initRequestRate() error {
+	maxRPS, err := rootCmd.PersistentFlags().GetFloat64("max-rps")
+	if err != nil {
+		return err
+	}
+	// Replayed responses don't reach the nodes
+	if maxRPS > 0 && replayedAt.IsZero() {
+		var hosts []string
+		for _, key := range []string{"lotus_private_addr", "lotus_archive_addr"} {
+			u, err := url.Parse(viper.GetString(key))
+			if err == nil && u.Host != "" {
+				hosts = append(hosts, u.Host)
+			}
+		}
+		singleton.LimitRequestRate(maxRPS, hosts...)
+	}
+	return nil
+}
+
+func initCache(ctx context.Context, path string) error {
+	err := singleton.OpenCache(path, viper.GetInt64("chain_id"))
+	if err != nil {
+		return err
+	}
+
+	finalized, err := getFinalizedEpoch(ctx)
+	if err != nil {
+		return err
+	}
+	singleton.Cache().SetFinalizedHeight(finalized)
 	return nil
 }
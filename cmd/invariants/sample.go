@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glifio/go-pools/util"
+	"github.com/glifio/invariants"
+	"github.com/spf13/cobra"
+)
+
+// Strategies weighting the agents picked by --random
+const (
+	sampleUniform     = "uniform"
+	samplePrincipal   = "principal"
+	sampleMiners      = "miners"
+	sampleLastChecked = "last-checked"
+)
+
+var sampleStrategies = []string{sampleUniform, samplePrincipal, sampleMiners, sampleLastChecked}
+
+// Strategies picking the miners checked by miner-liquidation --random
+const (
+	samplePenalty  = "penalty"
+	sampleQAP      = "qap"
+	samplePerAgent = "per-agent"
+)
+
+var minerSampleStrategies = []string{sampleUniform, samplePenalty, sampleQAP, samplePerAgent}
+
+// sampleOptions choose the targets checked with --random
+type sampleOptions struct {
+	seed     int64
+	strategy string
+	// skipChecked leaves out the targets checked this recently, according to the
+	// results history
+	skipChecked time.Duration
+}
+
+// runSample is the seed and the targets a run picked, printed in its summary so
+// the same targets can be checked again
+type runSample struct {
+	Seed        int64
+	Strategy    string
+	SkipChecked time.Duration
+	Targets     []string
+}
+
+func (s *runSample) String() string {
+	flags := fmt.Sprintf("--seed %d", s.Seed)
+	if s.Strategy != "" {
+		flags += " --sample " + s.Strategy
+	}
+	if s.SkipChecked > 0 {
+		flags += fmt.Sprintf(" --skip-checked %v", s.SkipChecked)
+	}
+	return fmt.Sprintf("%s: %s", flags, strings.Join(s.Targets, ", "))
+}
+
+func addSeedFlag(cmd *cobra.Command) {
+	cmd.Flags().Int64("seed", 0, "Seed for --random, picking the same targets again from the same weights (a new one is picked and printed if not set)")
+}
+
+func addSampleFlags(cmd *cobra.Command) {
+	addSeedFlag(cmd)
+	cmd.Flags().String("sample", sampleUniform, "How --random weights agents: uniform, principal (borrowed), miners or last-checked (needs --results-db)")
+}
+
+func addMinerSampleFlags(cmd *cobra.Command) {
+	addSeedFlag(cmd)
+	cmd.Flags().String("sample", sampleUniform, "How --random picks miners: uniform, penalty (termination penalty), qap or per-agent (spread evenly over agents)")
+	cmd.Flags().Duration("skip-checked", 0, "Don't pick miners checked this recently, according to --results-db")
+}
+
+// getSeed returns the seed set with --seed, or a new one
+func getSeed(cmd *cobra.Command) (int64, error) {
+	if !cmd.Flags().Changed("seed") {
+		return time.Now().UnixNano(), nil
+	}
+	return cmd.Flags().GetInt64("seed")
+}
+
+func getSampleOptions(cmd *cobra.Command) (sampleOptions, error) {
+	return readSampleOptions(cmd, sampleStrategies)
+}
+
+func getMinerSampleOptions(cmd *cobra.Command) (sampleOptions, error) {
+	opts, err := readSampleOptions(cmd, minerSampleStrategies)
+	if err != nil {
+		return opts, err
+	}
+	opts.skipChecked, err = cmd.Flags().GetDuration("skip-checked")
+	return opts, err
+}
+
+func readSampleOptions(cmd *cobra.Command, strategies []string) (sampleOptions, error) {
+	var opts sampleOptions
+	var err error
+	opts.seed, err = getSeed(cmd)
+	if err != nil {
+		return opts, err
+	}
+	opts.strategy, err = cmd.Flags().GetString("sample")
+	if err != nil {
+		return opts, err
+	}
+	for _, strategy := range strategies {
+		if opts.strategy == strategy {
+			return opts, nil
+		}
+	}
+	return opts, fmt.Errorf("unknown --sample %q: want %s", opts.strategy, strings.Join(strategies, ", "))
+}
+
+// sampleWeighted picks n of count items without replacement, each with a chance
+// proportional to its weight, and returns their indexes in the order picked.
+// Each item gets the key u^(1/weight) for a uniform u, and the n largest keys
+// are picked (Efraimidis and Spirakis).
+func sampleWeighted(rng *rand.Rand, count int, n int, weight func(i int) float64) []int {
+	keys := make([]float64, count)
+	indexes := make([]int, count)
+	for i := range keys {
+		// log(u)/weight orders the same as u^(1/weight), without underflowing
+		keys[i] = math.Log(1-rng.Float64()) / weight(i)
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return keys[indexes[a]] > keys[indexes[b]]
+	})
+	if n > count {
+		n = count
+	}
+	return indexes[:n]
+}
+
+// agentWeights returns the weight of each agent for strategy. Every weight is at
+// least 1, so agents without principal or miners are still picked sometimes.
+// With last-checked, agents never checked weigh as much as the one checked
+// longest ago.
+func agentWeights(strategy string, agents []invariants.Agent, lastChecked map[string]time.Time, now time.Time) []float64 {
+	weights := make([]float64, len(agents))
+	for i, agent := range agents {
+		weights[i] = 1
+		switch strategy {
+		case samplePrincipal:
+			if agent.PrincipalBalance != nil {
+				principal, _ := util.ToFIL(agent.PrincipalBalance).Float64()
+				weights[i] += math.Max(principal, 0)
+			}
+		case sampleMiners:
+			weights[i] += float64(agent.Miners)
+		case sampleLastChecked:
+			if checkedAt, ok := lastChecked[agentTarget(agent.ID)]; ok {
+				weights[i] += math.Max(now.Sub(checkedAt).Hours(), 0)
+			} else {
+				weights[i] = math.Inf(1)
+			}
+		}
+	}
+
+	if strategy == sampleLastChecked {
+		oldest := 1.0
+		for _, weight := range weights {
+			if !math.IsInf(weight, 1) {
+				oldest = math.Max(oldest, weight)
+			}
+		}
+		for i, weight := range weights {
+			if math.IsInf(weight, 1) {
+				weights[i] = oldest
+			}
+		}
+	}
+	return weights
+}
+
+// sampleAgents picks n agents for --random, and adds the seed and the agents
+// picked to the report. The agents are sorted by ID first, so with uniform the
+// same seed picks the same agents as long as the API lists the same ones. The
+// other strategies also need the same weights, which follow the API's live
+// values or the results history.
+func sampleAgents(opts sampleOptions, report *runReport, agents []invariants.Agent, n uint64) ([]invariants.Agent, error) {
+	sorted := append([]invariants.Agent(nil), agents...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	var lastChecked map[string]time.Time
+	if opts.strategy == sampleLastChecked {
+		if resultsHistory.db == nil {
+			return nil, fmt.Errorf("--sample %s needs --results-db", sampleLastChecked)
+		}
+		var err error
+		lastChecked, err = resultsHistory.db.LastChecked(resultsHistory.invariant)
+		if err != nil {
+			return nil, err
+		}
+	}
+	weights := agentWeights(opts.strategy, sorted, lastChecked, time.Now())
+
+	rng := rand.New(rand.NewSource(opts.seed))
+	var picked []invariants.Agent
+	sample := &runSample{Seed: opts.seed, Strategy: opts.strategy}
+	for _, i := range sampleWeighted(rng, len(sorted), int(min(n, uint64(len(sorted)))), func(i int) float64 { return weights[i] }) {
+		picked = append(picked, sorted[i])
+		sample.Targets = append(sample.Targets, agentTarget(sorted[i].ID))
+	}
+
+	fmt.Printf("Sampled %d of %d agents with %s\n", len(picked), len(sorted), sample)
+	report.Sample = sample
+	return picked, nil
+}
+
+// agentMiner is a miner of an agent, as listed by the API
+type agentMiner struct {
+	agent invariants.Agent
+	miner invariants.MinerDetailsResult
+	// index of the miner among the agent's count miners, from 1
+	index int
+	count int
+}
+
+// minerWeights returns the weight of each miner for strategy, at least 1 so
+// miners without penalty or power are still picked sometimes
+func minerWeights(strategy string, miners []agentMiner) []float64 {
+	weights := make([]float64, len(miners))
+	for i, m := range miners {
+		weights[i] = 1
+		switch strategy {
+		case samplePenalty:
+			if m.miner.TerminationPenalty != nil {
+				penalty, _ := util.ToFIL(m.miner.TerminationPenalty).Float64()
+				weights[i] += math.Max(penalty, 0)
+			}
+		case sampleQAP:
+			if m.miner.QAP != nil {
+				// In TiB
+				qap, _ := new(big.Float).SetInt(m.miner.QAP).Float64()
+				weights[i] += math.Max(qap/(1<<40), 0)
+			}
+		}
+	}
+	return weights
+}
+
+// sampleStratified picks n of the miners, spread as evenly as possible over their
+// agents: one miner of each agent, in a random order of agents, then a second,
+// and so on. Miners are grouped by agent, in order.
+func sampleStratified(rng *rand.Rand, miners []agentMiner, n int) []int {
+	var groups [][]int
+	for i, m := range miners {
+		if i == 0 || m.agent.ID != miners[i-1].agent.ID {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], i)
+	}
+	rng.Shuffle(len(groups), func(i, j int) {
+		groups[i], groups[j] = groups[j], groups[i]
+	})
+	for _, group := range groups {
+		rng.Shuffle(len(group), func(i, j int) {
+			group[i], group[j] = group[j], group[i]
+		})
+	}
+
+	var picked []int
+	for round := 0; len(picked) < n; round++ {
+		added := false
+		for _, group := range groups {
+			if round < len(group) && len(picked) < n {
+				picked = append(picked, group[round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return picked
+}
+
+// sampleAgentMiners picks n miners for miner-liquidation --random, leaving out
+// the ones checked within opts.skipChecked, and adds the seed and the miners
+// picked to the report. The miners are sorted by agent and address first, so with
+// uniform or per-agent the same seed picks the same miners as long as the API
+// lists the same ones, and nothing is skipped. penalty and qap also need the
+// API's penalties and power to be the same.
+func sampleAgentMiners(opts sampleOptions, report *runReport, miners []agentMiner, n uint64) ([]agentMiner, error) {
+	sorted := append([]agentMiner(nil), miners...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].agent.ID != sorted[j].agent.ID {
+			return sorted[i].agent.ID < sorted[j].agent.ID
+		}
+		return sorted[i].miner.MinerAddr.String() < sorted[j].miner.MinerAddr.String()
+	})
+
+	if opts.skipChecked > 0 {
+		if resultsHistory.db == nil {
+			return nil, fmt.Errorf("--skip-checked needs --results-db")
+		}
+		lastChecked, err := resultsHistory.db.LastChecked(resultsHistory.invariant)
+		if err != nil {
+			return nil, err
+		}
+		var unchecked []agentMiner
+		for _, m := range sorted {
+			if checkedAt, ok := lastChecked[minerTarget(m.miner.MinerAddr)]; !ok || time.Since(checkedAt) >= opts.skipChecked {
+				unchecked = append(unchecked, m)
+			}
+		}
+		fmt.Printf("Skipping %d miners checked in the last %v\n", len(sorted)-len(unchecked), opts.skipChecked)
+		sorted = unchecked
+	}
+
+	rng := rand.New(rand.NewSource(opts.seed))
+	count := int(min(n, uint64(len(sorted))))
+	var indexes []int
+	if opts.strategy == samplePerAgent {
+		indexes = sampleStratified(rng, sorted, count)
+	} else {
+		weights := minerWeights(opts.strategy, sorted)
+		indexes = sampleWeighted(rng, len(sorted), count, func(i int) float64 { return weights[i] })
+	}
+
+	var picked []agentMiner
+	sample := &runSample{Seed: opts.seed, Strategy: opts.strategy, SkipChecked: opts.skipChecked}
+	for _, i := range indexes {
+		picked = append(picked, sorted[i])
+		sample.Targets = append(sample.Targets, minerTarget(sorted[i].miner.MinerAddr))
+	}
+
+	fmt.Printf("Sampled %d of %d miners with %s\n", len(picked), len(sorted), sample)
+	report.Sample = sample
+	return picked, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/history"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleTestAgents() []invariants.Agent {
+	var agents []invariants.Agent
+	for id := uint64(20); id >= 1; id-- {
+		agents = append(agents, invariants.Agent{ID: id, Miners: id % 3, PrincipalBalance: big.NewInt(0)})
+	}
+	// Agent 7 has borrowed far more than the others
+	agents[13].PrincipalBalance = bigFIL(1000000)
+	return agents
+}
+
+func TestSampleWeighted(t *testing.T) {
+	uniform := func(i int) float64 { return 1 }
+	picked := sampleWeighted(rand.New(rand.NewSource(42)), 10, 4, uniform)
+	assert.Len(t, picked, 4)
+	assert.Equal(t, picked, sampleWeighted(rand.New(rand.NewSource(42)), 10, 4, uniform))
+	assert.NotEqual(t, picked, sampleWeighted(rand.New(rand.NewSource(43)), 10, 4, uniform))
+	assert.Len(t, sampleWeighted(rand.New(rand.NewSource(42)), 3, 4, uniform), 3)
+
+	// Index 0 weighs as much as the 9 others together
+	var first int
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		if sampleWeighted(rng, 10, 1, func(i int) float64 {
+			if i == 0 {
+				return 9
+			}
+			return 1
+		})[0] == 0 {
+			first++
+		}
+	}
+	assert.InDelta(t, 500, first, 60)
+}
+
+func TestAgentWeights(t *testing.T) {
+	agents := []invariants.Agent{
+		{ID: 1, Miners: 2, PrincipalBalance: bigFIL(100)},
+		{ID: 2, Miners: 0, PrincipalBalance: big.NewInt(0)},
+		{ID: 3, Miners: 5},
+	}
+	assert.Equal(t, []float64{1, 1, 1}, agentWeights(sampleUniform, agents, nil, time.Now()))
+	assert.Equal(t, []float64{101, 1, 1}, agentWeights(samplePrincipal, agents, nil, time.Now()))
+	assert.Equal(t, []float64{3, 1, 6}, agentWeights(sampleMiners, agents, nil, time.Now()))
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	lastChecked := map[string]time.Time{
+		"Agent 1": now.Add(-time.Hour),
+		"Agent 2": now.Add(-24 * time.Hour),
+	}
+	assert.Equal(t, []float64{2, 25, 25}, agentWeights(sampleLastChecked, agents, lastChecked, now))
+}
+
+func TestSampleAgents(t *testing.T) {
+	agents := sampleTestAgents()
+
+	report := &runReport{}
+	picked, err := sampleAgents(sampleOptions{seed: 42, strategy: sampleUniform}, report, agents, 5)
+	assert.Nil(t, err)
+	assert.Len(t, picked, 5)
+	assert.Equal(t, int64(42), report.Sample.Seed)
+	assert.Len(t, report.Sample.Targets, 5)
+	assert.Equal(t, agentTarget(picked[0].ID), report.Sample.Targets[0])
+
+	// The same seed picks the same agents, whatever order the API lists them in
+	reversed := make([]invariants.Agent, len(agents))
+	for i, agent := range agents {
+		reversed[len(agents)-1-i] = agent
+	}
+	again, err := sampleAgents(sampleOptions{seed: 42, strategy: sampleUniform}, &runReport{}, reversed, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, picked, again)
+
+	var w bytes.Buffer
+	report.Print(&w)
+	assert.Contains(t, w.String(), "  sampled with --seed 42 --sample uniform: "+report.Sample.Targets[0]+", ")
+
+	picked, err = sampleAgents(sampleOptions{seed: 42, strategy: samplePrincipal}, &runReport{}, agents, 1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, picked[0].ID)
+
+	picked, err = sampleAgents(sampleOptions{seed: 42, strategy: sampleUniform}, &runReport{}, agents, 50)
+	assert.Nil(t, err)
+	assert.Len(t, picked, 20)
+}
+
+func TestSampleAgentsLastChecked(t *testing.T) {
+	agents := sampleTestAgents()
+	opts := sampleOptions{seed: 42, strategy: sampleLastChecked}
+
+	_, err := sampleAgents(opts, &runReport{}, agents, 5)
+	assert.ErrorContains(t, err, "needs --results-db")
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	assert.Nil(t, openResultsHistory(path, "agent-econ"))
+	defer func() {
+		resultsHistory.db.Close()
+		resultsHistory.db = nil
+	}()
+
+	// Every agent but 3 was checked a minute ago
+	var results []history.Result
+	for _, agent := range agents {
+		if agent.ID != 3 {
+			results = append(results, history.Result{Target: agentTarget(agent.ID), Status: history.StatusPass})
+		}
+	}
+	_, err = resultsHistory.db.AddRun("agent-econ", time.Now().Add(-time.Minute), results)
+	assert.Nil(t, err)
+	_, err = resultsHistory.db.AddRun("agent-econ", time.Now().Add(-1000*time.Hour), []history.Result{
+		{Target: agentTarget(3), Status: history.StatusPass},
+	})
+	assert.Nil(t, err)
+
+	picked, err := sampleAgents(opts, &runReport{}, agents, 1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, picked[0].ID)
+}
+
+func TestGetSampleOptions(t *testing.T) {
+	cmd := &cobra.Command{Use: "agent-econ"}
+	addSampleFlags(cmd)
+
+	opts, err := getSampleOptions(cmd)
+	assert.Nil(t, err)
+	assert.NotZero(t, opts.seed)
+	assert.Equal(t, sampleUniform, opts.strategy)
+
+	cmd.Flags().Set("seed", "0")
+	cmd.Flags().Set("sample", "miners")
+	opts, err = getSampleOptions(cmd)
+	assert.Nil(t, err)
+	assert.Zero(t, opts.seed)
+	assert.Equal(t, sampleMiners, opts.strategy)
+
+	cmd.Flags().Set("sample", "risky")
+	_, err = getSampleOptions(cmd)
+	assert.ErrorContains(t, err, `unknown --sample "risky"`)
+}
+
+// sampleTestMiners lists 2 miners for agent 1, 10 for agent 2 and 3 for agent 3
+func sampleTestMiners() []agentMiner {
+	var miners []agentMiner
+	for agentID, count := range map[uint64]int{1: 2, 2: 10, 3: 3} {
+		for i := 1; i <= count; i++ {
+			addr, _ := address.NewIDAddress(agentID*1000 + uint64(i))
+			miners = append(miners, agentMiner{
+				agent: invariants.Agent{ID: agentID},
+				miner: invariants.MinerDetailsResult{MinerAddr: addr, TerminationPenalty: bigFIL(1), QAP: big.NewInt(1 << 40)},
+				index: i,
+				count: count,
+			})
+		}
+	}
+	return miners
+}
+
+func TestMinerWeights(t *testing.T) {
+	miners := []agentMiner{
+		{miner: invariants.MinerDetailsResult{TerminationPenalty: bigFIL(10), QAP: big.NewInt(3 << 40)}},
+		{miner: invariants.MinerDetailsResult{}},
+	}
+	assert.Equal(t, []float64{1, 1}, minerWeights(sampleUniform, miners))
+	assert.Equal(t, []float64{11, 1}, minerWeights(samplePenalty, miners))
+	assert.Equal(t, []float64{4, 1}, minerWeights(sampleQAP, miners))
+}
+
+func TestSampleAgentMiners(t *testing.T) {
+	miners := sampleTestMiners()
+	// The last miner of agent 2 has a far larger termination penalty and power
+	largest := miners[0]
+	for _, m := range miners {
+		if m.agent.ID == 2 && m.index == 10 {
+			largest = m
+		}
+	}
+	largest.miner.TerminationPenalty = bigFIL(1000000)
+	largest.miner.QAP = largest.miner.QAP.Lsh(largest.miner.QAP, 20)
+	for i, m := range miners {
+		if m.miner.MinerAddr == largest.miner.MinerAddr {
+			miners[i] = largest
+		}
+	}
+
+	report := &runReport{}
+	picked, err := sampleAgentMiners(sampleOptions{seed: 7, strategy: sampleUniform}, report, miners, 4)
+	assert.Nil(t, err)
+	assert.Len(t, picked, 4)
+	assert.Equal(t, sampleUniform, report.Sample.Strategy)
+	assert.Equal(t, minerTarget(picked[0].miner.MinerAddr), report.Sample.Targets[0])
+
+	// Picks every miner, each once
+	picked, err = sampleAgentMiners(sampleOptions{seed: 7, strategy: sampleUniform}, &runReport{}, miners, 100)
+	assert.Nil(t, err)
+	assert.Len(t, picked, len(miners))
+	seen := make(map[address.Address]bool)
+	for _, m := range picked {
+		seen[m.miner.MinerAddr] = true
+	}
+	assert.Len(t, seen, len(miners))
+
+	for _, strategy := range []string{samplePenalty, sampleQAP} {
+		picked, err = sampleAgentMiners(sampleOptions{seed: 7, strategy: strategy}, &runReport{}, miners, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, largest.miner.MinerAddr, picked[0].miner.MinerAddr, strategy)
+	}
+
+	// Every agent gets a miner before any gets a second
+	picked, err = sampleAgentMiners(sampleOptions{seed: 7, strategy: samplePerAgent}, &runReport{}, miners, 6)
+	assert.Nil(t, err)
+	perAgent := make(map[uint64]int)
+	for _, m := range picked {
+		perAgent[m.agent.ID]++
+	}
+	assert.Equal(t, map[uint64]int{1: 2, 2: 2, 3: 2}, perAgent)
+	again, err := sampleAgentMiners(sampleOptions{seed: 7, strategy: samplePerAgent}, &runReport{}, miners, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, picked, again)
+}
+
+func TestSampleAgentMinersSkipChecked(t *testing.T) {
+	miners := sampleTestMiners()
+	opts := sampleOptions{seed: 7, strategy: sampleUniform, skipChecked: 24 * time.Hour}
+
+	_, err := sampleAgentMiners(opts, &runReport{}, miners, 5)
+	assert.ErrorContains(t, err, "--skip-checked needs --results-db")
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	assert.Nil(t, openResultsHistory(path, "miner-liquidation"))
+	defer func() {
+		resultsHistory.db.Close()
+		resultsHistory.db = nil
+	}()
+
+	// Every miner but 2 was checked an hour ago, one of them errored
+	var recent, old []history.Result
+	for i, m := range miners {
+		result := history.Result{Target: minerTarget(m.miner.MinerAddr), Status: history.StatusPass}
+		switch i {
+		case 0:
+			result.Status = history.StatusError
+			recent = append(recent, result)
+		case 1:
+			old = append(old, result)
+		default:
+			recent = append(recent, result)
+		}
+	}
+	_, err = resultsHistory.db.AddRun("miner-liquidation", time.Now().Add(-time.Hour), recent)
+	assert.Nil(t, err)
+	_, err = resultsHistory.db.AddRun("miner-liquidation", time.Now().Add(-48*time.Hour), old)
+	assert.Nil(t, err)
+
+	report := &runReport{}
+	picked, err := sampleAgentMiners(opts, report, miners, 5)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{minerTarget(miners[0].miner.MinerAddr), minerTarget(miners[1].miner.MinerAddr)},
+		report.Sample.Targets)
+	assert.Len(t, picked, 2)
+
+	var w bytes.Buffer
+	report.Print(&w)
+	assert.Contains(t, w.String(), "  sampled with --seed 7 --sample uniform --skip-checked 24h0m0s: ")
+}
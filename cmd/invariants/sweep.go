@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/glifio/invariants/singleton"
+	"github.com/spf13/cobra"
+)
+
+// sweepValue is one value compared between the API and the node at an epoch
+type sweepValue struct {
+	Name      string
+	Field     string
+	API       *big.Int
+	Node      *big.Int
+	Tolerance tolerance
+}
+
+// newSweepValue creates a sweep value compared with the tolerance configured for
+// field of invariant
+func newSweepValue(invariant string, field string, name string, api *big.Int, node *big.Int) sweepValue {
+	return sweepValue{name, field, api, node, toleranceFor(invariant, field, exactTolerance)}
+}
+
+// sweepRange is the range of epochs set with --from, --to and --step
+type sweepRange struct {
+	From uint64
+	To   uint64
+	Step uint64
+}
+
+func addSweepFlags(cmd *cobra.Command) {
+	cmd.Flags().Uint64("from", 0, "Sweep epochs starting at this epoch")
+	cmd.Flags().Uint64("to", 0, "Sweep epochs up to this epoch (defaults to the selected epoch)")
+	cmd.Flags().Uint64("step", 2880, "Epochs between samples when sweeping")
+}
+
+// getSweepRange returns the sweep range, or nil if --from isn't set
+func getSweepRange(cmd *cobra.Command, epoch uint64) (*sweepRange, error) {
+	from, err := cmd.Flags().GetUint64("from")
+	if err != nil {
+		return nil, err
+	}
+	if from == 0 {
+		return nil, nil
+	}
+
+	to, err := cmd.Flags().GetUint64("to")
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		to = epoch
+	}
+
+	step, err := cmd.Flags().GetUint64("step")
+	if err != nil {
+		return nil, err
+	}
+
+	if step == 0 {
+		return nil, fmt.Errorf("--step must be greater than 0")
+	}
+	if from > to {
+		return nil, fmt.Errorf("--from %d is after --to %d", from, to)
+	}
+
+	return &sweepRange{From: from, To: to, Step: step}, nil
+}
+
+// sweepEpochs evaluates values at every step of the range, printing to w a CSV
+// time series of the API and node values and the tolerance they're compared
+// with, and adding the result of each epoch to report, as target at the epoch.
+// With --bisect, check is used to find the exact first failing epoch.
+func sweepEpochs(
+	ctx context.Context,
+	w io.Writer,
+	report *runReport,
+	target string,
+	r *sweepRange,
+	values func(ctx context.Context, epoch uint64) ([]sweepValue, error),
+	check epochCheck,
+) {
+	chain := singleton.Chain()
+
+	var firstFail, lastPass uint64
+	var checked, failCount int
+
+	fmt.Fprintln(w, "epoch,name,api,node,diff,tolerance,status")
+	for epoch := r.From; epoch <= r.To; epoch += r.Step {
+		epochTarget := strings.TrimSpace(fmt.Sprintf("%s @%d", target, epoch))
+		rec := recorderFrom(ctx)
+		rec.take()
+
+		ts, err := chain.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(epoch))
+		if err != nil {
+			report.AddAt(epochTarget, epoch, true, err)
+			continue
+		}
+		if uint64(ts.Height()) != epoch {
+			fmt.Fprintf(w, "%d,,,,,,null round\n", epoch)
+			report.Skip(epochTarget, "null round")
+			continue
+		}
+
+		vals, err := values(ctx, epoch)
+		if err != nil {
+			report.AddAt(epochTarget, epoch, true, err)
+			continue
+		}
+		checked++
+
+		failed := false
+		for _, v := range vals {
+			diff := new(big.Int).Sub(v.Node, v.API)
+			status := "pass"
+			if !v.Tolerance.Within(v.API, v.Node) {
+				status = "fail"
+				failed = true
+			}
+			fmt.Fprintf(w, "%d,%s,%v,%v,%v,%v,%s\n", epoch, v.Name, v.API, v.Node, diff, v.Tolerance, status)
+			rec.record(v.Field, v.API, v.Node)
+		}
+		report.addRecorded(epochTarget, epoch, failed, nil, rec)
+
+		if failed {
+			failCount++
+			if firstFail == 0 {
+				firstFail = epoch
+			}
+		} else if firstFail == 0 {
+			lastPass = epoch
+		}
+	}
+
+	if firstFail == 0 {
+		fmt.Fprintf(w, "Sweep @%d to @%d: Success, all %d epochs match.\n", r.From, r.To, checked)
+		return
+	}
+
+	fmt.Fprintf(w, "Sweep @%d to @%d: Error, %d of %d epochs don't match.\n", r.From, r.To, failCount, checked)
+	if lastPass != 0 {
+		fmt.Fprintf(w, "  Last passing epoch before first failure: @%d\n", lastPass)
+	}
+	fmt.Fprintf(w, "  First failing epoch: @%d\n", firstFail)
+
+	bisect, err := rootCmd.PersistentFlags().GetBool("bisect")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bisect {
+		bisectCheck(ctx, w, check, lastPass, firstFail)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/glifio/invariants/chaintest"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSweepRange(t *testing.T) {
+	cmd := &cobra.Command{Use: "metrics"}
+	addSweepFlags(cmd)
+
+	// Not sweeping without --from
+	sweep, err := getSweepRange(cmd, 4301000)
+	assert.Nil(t, err)
+	assert.Nil(t, sweep)
+
+	// --to defaults to the selected epoch
+	cmd.Flags().Set("from", "4300000")
+	sweep, err = getSweepRange(cmd, 4301000)
+	assert.Nil(t, err)
+	assert.Equal(t, &sweepRange{From: 4300000, To: 4301000, Step: 2880}, sweep)
+
+	cmd.Flags().Set("to", "4300500")
+	cmd.Flags().Set("step", "100")
+	sweep, err = getSweepRange(cmd, 4301000)
+	assert.Nil(t, err)
+	assert.Equal(t, &sweepRange{From: 4300000, To: 4300500, Step: 100}, sweep)
+
+	cmd.Flags().Set("step", "0")
+	_, err = getSweepRange(cmd, 4301000)
+	assert.EqualError(t, err, "--step must be greater than 0")
+
+	cmd.Flags().Set("step", "100")
+	cmd.Flags().Set("from", "4300600")
+	_, err = getSweepRange(cmd, 4301000)
+	assert.EqualError(t, err, "--from 4300600 is after --to 4300500")
+}
+
+func TestSweepEpochs(t *testing.T) {
+	chain := chaintest.New(4301000)
+	chain.Use(t)
+	chain.NullRound(4300200)
+	ctx := context.Background()
+
+	// The API value drifts from the node from 4300300
+	node := chaintest.Constant(big.NewInt(100))
+	api := chaintest.Step(big.NewInt(100), 4300300, big.NewInt(101))
+	values := func(ctx context.Context, epoch uint64) ([]sweepValue, error) {
+		if epoch == 4300400 {
+			return nil, errors.New("node unavailable")
+		}
+		return []sweepValue{
+			newSweepValue("metrics", "pool_total_assets", "poolTotalAssets", api(epoch), node(epoch)),
+		}, nil
+	}
+	check := func(ctx context.Context, w io.Writer, epoch uint64) (bool, error) {
+		return false, nil
+	}
+
+	var w bytes.Buffer
+	report := &runReport{}
+	sweepEpochs(ctx, &w, report, "", &sweepRange{From: 4300000, To: 4300500, Step: 100}, values, check)
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	assert.Equal(t, []string{
+		"epoch,name,api,node,diff,tolerance,status",
+		"4300000,poolTotalAssets,100,100,0,exact,pass",
+		"4300100,poolTotalAssets,100,100,0,exact,pass",
+		"4300200,,,,,,null round",
+		"4300300,poolTotalAssets,101,100,-1,exact,fail",
+		"4300500,poolTotalAssets,101,100,-1,exact,fail",
+		"Sweep @4300000 to @4300500: Error, 2 of 4 epochs don't match.",
+		"  Last passing epoch before first failure: @4300100",
+		"  First failing epoch: @4300300",
+	}, lines)
+
+	assert.Equal(t, 2, report.Count(statusPass))
+	assert.Equal(t, 2, report.Count(statusFail))
+	assert.Equal(t, 1, report.Count(statusError))
+	assert.Equal(t, 1, report.Count(statusSkipped))
+	assert.Equal(t, "@4300200", report.Results[2].Target)
+	assert.EqualValues(t, 4300300, report.Results[3].Epoch)
+
+	// Every epoch matching
+	w.Reset()
+	report = &runReport{}
+	sweepEpochs(ctx, &w, report, "Agent 1", &sweepRange{From: 4300000, To: 4300250, Step: 100}, values, check)
+	assert.Contains(t, w.String(), "Sweep @4300000 to @4300250: Success, all 2 epochs match.\n")
+	assert.Equal(t, "Agent 1 @4300000", report.Results[0].Target)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// tolerancePrefix starts the config keys setting tolerances, for example
+// TOLERANCE_METRICS_POOL_TOTAL_ASSETS=0.01% or TOLERANCE_AGENT_ECON=exact
+const tolerancePrefix = "tolerance_"
+
+// tolerance is how far a value may be from its reference and still match: an
+// absolute amount in the value's units (attoFIL for balances), a percentage of
+// the reference, or exact, the zero value
+type tolerance struct {
+	abs *big.Int
+	pct float64
+}
+
+var exactTolerance = tolerance{}
+
+func absTolerance(abs *big.Int) tolerance {
+	return tolerance{abs: abs}
+}
+
+func pctTolerance(pct float64) tolerance {
+	return tolerance{pct: pct}
+}
+
+// parseTolerance reads "exact", an amount such as "1000" or a percentage such
+// as "0.5%"
+func parseTolerance(s string) (tolerance, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "exact" {
+		return exactTolerance, nil
+	}
+	if pctStr, ok := strings.CutSuffix(s, "%"); ok {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(pctStr), 64)
+		if err != nil || pct < 0 || math.IsNaN(pct) || math.IsInf(pct, 0) {
+			return exactTolerance, fmt.Errorf("invalid tolerance %q: want a non-negative percentage", s)
+		}
+		return pctTolerance(pct), nil
+	}
+	abs, ok := new(big.Int).SetString(s, 10)
+	if !ok || abs.Sign() < 0 {
+		return exactTolerance, fmt.Errorf("invalid tolerance %q: want exact, an amount or a percentage", s)
+	}
+	return absTolerance(abs), nil
+}
+
+func (t tolerance) String() string {
+	switch {
+	case t.abs != nil:
+		return fmt.Sprintf("±%v attoFIL", t.abs)
+	case t.pct != 0:
+		return fmt.Sprintf("±%v%%", strconv.FormatFloat(t.pct, 'f', -1, 64))
+	default:
+		return "exact"
+	}
+}
+
+// Within reports whether value is within the tolerance of reference
+func (t tolerance) Within(value *big.Int, reference *big.Int) bool {
+	diff := new(big.Int).Sub(value, reference)
+	diff.Abs(diff)
+	if t.abs != nil {
+		return diff.Cmp(t.abs) <= 0
+	}
+	if diff.Sign() == 0 {
+		return true
+	}
+	// diff <= pct% of |reference|
+	lhs := new(big.Float).Mul(new(big.Float).SetInt(diff), big.NewFloat(100))
+	rhs := new(big.Float).Mul(new(big.Float).SetInt(new(big.Int).Abs(reference)), big.NewFloat(t.pct))
+	return lhs.Cmp(rhs) <= 0
+}
+
+// tolerances are the tolerances set in the config file, by config key
+var tolerances map[string]tolerance
+
+// loadTolerances reads every tolerance set in the config file
+func loadTolerances() (map[string]tolerance, error) {
+	loaded := make(map[string]tolerance)
+	for _, key := range viper.AllKeys() {
+		if !strings.HasPrefix(key, tolerancePrefix) {
+			continue
+		}
+		t, err := parseTolerance(viper.GetString(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.ToUpper(key), err)
+		}
+		loaded[key] = t
+	}
+	return loaded, nil
+}
+
+// toleranceKey is the config key for the tolerance of field of invariant, or of
+// the whole invariant if field is empty
+func toleranceKey(invariant string, field string) string {
+	key := tolerancePrefix + strings.ReplaceAll(invariant, "-", "_")
+	if field != "" {
+		key += "_" + field
+	}
+	return key
+}
+
+// toleranceFor returns the tolerance configured for field of invariant, then for
+// the invariant, falling back to def
+func toleranceFor(invariant string, field string, def tolerance) tolerance {
+	if t, ok := tolerances[toleranceKey(invariant, field)]; ok {
+		return t
+	}
+	if t, ok := tolerances[toleranceKey(invariant, "")]; ok {
+		return t
+	}
+	return def
+}
+
+// compareValues reports whether the API value matches the node value within the
+// tolerance configured for field of invariant, which is returned for messages.
+// The values are recorded for the result history.
+func compareValues(ctx context.Context, invariant string, field string, api *big.Int, node *big.Int) (bool, tolerance) {
+	t := toleranceFor(invariant, field, exactTolerance)
+	recorderFrom(ctx).record(field, api, node)
+	return t.Within(api, node), t
+}
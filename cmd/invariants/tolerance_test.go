@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/glifio/invariants"
+	"github.com/glifio/invariants/eventstest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTolerance(t *testing.T) {
+	for s, expected := range map[string]string{
+		"":      "exact",
+		"exact": "exact",
+		"1000":  "±1000 attoFIL",
+		"0":     "±0 attoFIL",
+		"0.5%":  "±0.5%",
+		" 2 % ": "±2%",
+	} {
+		tol, err := parseTolerance(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, tol.String(), s)
+	}
+
+	for _, s := range []string{"-1", "-0.5%", "abc", "1e18", "%", "NaN%"} {
+		_, err := parseTolerance(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestToleranceWithin(t *testing.T) {
+	reference := big.NewInt(1000)
+
+	assert.True(t, exactTolerance.Within(big.NewInt(1000), reference))
+	assert.False(t, exactTolerance.Within(big.NewInt(1001), reference))
+
+	abs := absTolerance(big.NewInt(10))
+	assert.True(t, abs.Within(big.NewInt(1010), reference))
+	assert.True(t, abs.Within(big.NewInt(990), reference))
+	assert.False(t, abs.Within(big.NewInt(1011), reference))
+
+	pct := pctTolerance(1)
+	assert.True(t, pct.Within(big.NewInt(1010), reference))
+	assert.True(t, pct.Within(big.NewInt(990), reference))
+	assert.False(t, pct.Within(big.NewInt(989), reference))
+	// Percentages of a zero reference only match it exactly
+	assert.True(t, pct.Within(big.NewInt(0), big.NewInt(0)))
+	assert.False(t, pct.Within(big.NewInt(1), big.NewInt(0)))
+}
+
+func TestLoadTolerances(t *testing.T) {
+	defer viper.Reset()
+	defer func() { tolerances = nil }()
+
+	viper.Set("tolerance_metrics", "0.01%")
+	viper.Set("tolerance_metrics_agent_count", "exact")
+	viper.Set("tolerance_agent_balances_available_balance", "1000")
+
+	var err error
+	tolerances, err = loadTolerances()
+	assert.Nil(t, err)
+
+	assert.Equal(t, "±0.01%", toleranceFor("metrics", "pool_total_assets", exactTolerance).String())
+	assert.Equal(t, "exact", toleranceFor("metrics", "agent_count", exactTolerance).String())
+	assert.Equal(t, "±1000 attoFIL", toleranceFor("agent-balances", "available_balance", exactTolerance).String())
+	assert.Equal(t, "exact", toleranceFor("agent-econ", "liability", exactTolerance).String())
+	assert.Equal(t, "±5%", toleranceFor("miner-liquidation", "full_penalty", pctTolerance(5)).String())
+
+	viper.Set("tolerance_agent_econ", "lots")
+	_, err = loadTolerances()
+	assert.ErrorContains(t, err, "TOLERANCE_AGENT_ECON")
+}
+
+func TestCheckAgentBalanceTolerance(t *testing.T) {
+	agent1Chain(t)
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	defer func() { tolerances = nil }()
+
+	agent, err := invariants.GetAgentFromAPI(ctx, server.URL, 1)
+	assert.Nil(t, err)
+
+	// The API is off by 1 attoFIL after the push
+	server.SetField("/agent/1/tx", "type", "push", "availableBalance", "1500000000000000000001")
+
+	var w bytes.Buffer
+	failed, err := checkAgentBalance(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+
+	tolerances = map[string]tolerance{
+		toleranceKey("agent-balances", "available_balance"): absTolerance(big.NewInt(1)),
+	}
+	w.Reset()
+	failed, err = checkAgentBalance(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.False(t, failed, w.String())
+
+	server.SetField("/agent/1/tx", "type", "push", "availableBalance", "1500000000000000000002")
+	w.Reset()
+	failed, err = checkAgentBalance(ctx, &w, server.URL, 4300600, agent)
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Contains(t, w.String(), "doesn't match node (tolerance: ±1 attoFIL).")
+}
@@ -4,14 +4,11 @@ import (
 	"context"
 
 	"github.com/filecoin-project/go-state-types/abi"
-	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/glifio/invariants/singleton"
 )
 
 func getHeadEpoch(ctx context.Context) (uint64, error) {
-	lotus := singleton.Lotus()
-
-	ts, err := lotus.Api.ChainHead(ctx)
+	ts, err := singleton.Chain().ChainHead(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -20,12 +17,27 @@ func getHeadEpoch(ctx context.Context) (uint64, error) {
 }
 
 func getNextEpoch(ctx context.Context, epoch uint64) (uint64, error) {
-	lotus := singleton.Lotus()
+	chain := singleton.Chain()
+	cache := singleton.Cache()
+
+	var height uint64
+	if cache.Get("ChainGetTipSetAfterHeight", epoch+1, nil, &height) {
+		return height, nil
+	}
 
-	ts, err := lotus.Api.ChainGetTipSetAfterHeight(ctx, abi.ChainEpoch(epoch+1), types.EmptyTSK)
+	ts, err := chain.ChainGetTipSetAfterHeight(ctx, abi.ChainEpoch(epoch+1))
 	if err != nil {
 		return 0, err
 	}
+	height = uint64(ts.Height())
+
+	// Only cache once the tipset found is final too, so later null rounds are settled
+	if cache.IsFinalized(height) {
+		err = cache.Put("ChainGetTipSetAfterHeight", epoch+1, nil, height)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	return uint64(ts.Height()), nil
+	return height, nil
 }
@@ -0,0 +1,242 @@
+// Package eventstest serves fixture files in place of the pools-events REST API,
+// so the API side of the invariants can be checked without a live server.
+package eventstest
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// Fixtures returns the fixtures served by NewServer: two agents, their
+// transactions, econ and miners, and metrics and iFIL supply at a few heights
+func Fixtures() fs.FS {
+	fsys, err := fs.Sub(fixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// Server is a fake pools-events API. Requests are answered from files in fsys:
+//
+//	/agent                          agent.json
+//	/agent/{id}/tx                  agent/{id}/tx.json
+//	/agent/{id}/econ                agent/{id}/econ.json
+//	/agent/{id}/miners              agent/{id}/miners.json
+//	/agent/{id}/available-balance   agent/{id}/available-balance.json
+//	/metrics                        metrics.json
+//	/metrics/{height}               metrics/{height}.json
+//	/ifil/{height}/total-supply     ifil/{height}.json
+//
+// For the height endpoints, the fixture with the highest height at or below the
+// requested one is served, as the API would return the latest values.
+type Server struct {
+	*httptest.Server
+
+	fsys fs.FS
+
+	mu        sync.Mutex
+	failures  map[string]int
+	mutations map[string][]func(v any) any
+	requests  []string
+}
+
+// NewServer starts a server answering from the default fixtures
+func NewServer() *Server {
+	return NewServerFS(Fixtures())
+}
+
+// NewServerFS starts a server answering from the fixtures in fsys, for example
+// os.DirFS of a directory laid out like the default fixtures
+func NewServerFS(fsys fs.FS) *Server {
+	s := &Server{
+		fsys:      fsys,
+		failures:  make(map[string]int),
+		mutations: make(map[string][]func(v any) any),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /agent", s.serveFile("agent.json"))
+	for _, endpoint := range []string{"tx", "econ", "miners", "available-balance"} {
+		mux.HandleFunc("GET /agent/{id}/"+endpoint, func(w http.ResponseWriter, r *http.Request) {
+			s.serveFile(path.Join("agent", r.PathValue("id"), endpoint+".json"))(w, r)
+		})
+	}
+	mux.HandleFunc("GET /metrics", s.serveFile("metrics.json"))
+	mux.HandleFunc("GET /metrics/{height}", s.serveHeight("metrics"))
+	mux.HandleFunc("GET /ifil/{height}/total-supply", s.serveHeight("ifil"))
+
+	s.Server = httptest.NewServer(s.record(mux))
+	return s
+}
+
+// Fail makes requests with a path matching pattern (see path.Match, for example
+// "/agent/*/tx") respond with status instead of the fixture
+func (s *Server) Fail(pattern string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[pattern] = status
+}
+
+// Mutate changes the decoded JSON of responses with a path matching pattern before
+// they're sent, to inject mismatches with the node
+func (s *Server) Mutate(pattern string, fn func(v any) any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mutations[pattern] = append(s.mutations[pattern], fn)
+}
+
+// SetField sets a field of the responses with a path matching pattern. For list
+// responses, the field is set on the elements where match is set to matchValue, or
+// on all of them if match is empty.
+func (s *Server) SetField(pattern string, match string, matchValue any, field string, value any) {
+	set := func(obj map[string]any) {
+		if match == "" || fmt.Sprint(obj[match]) == fmt.Sprint(matchValue) {
+			obj[field] = value
+		}
+	}
+	s.Mutate(pattern, func(v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			set(v)
+		case []any:
+			for _, elem := range v {
+				if obj, ok := elem.(map[string]any); ok {
+					set(obj)
+				}
+			}
+		}
+		return v
+	})
+}
+
+// Reset removes all failures and mutations
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[string]int)
+	s.mutations = make(map[string][]func(v any) any)
+}
+
+// Requests returns the paths requested so far, in order
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.Path)
+		status := 0
+		for pattern, failStatus := range s.failures {
+			if ok, _ := path.Match(pattern, r.URL.Path); ok {
+				status = failStatus
+			}
+		}
+		s.mu.Unlock()
+
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveFile(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := fs.ReadFile(s.fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.write(w, r, data)
+	}
+}
+
+// serveHeight serves the fixture in dir with the highest height at or below the
+// requested height
+func (s *Server) serveHeight(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		height, err := strconv.ParseUint(r.PathValue("height"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := fs.ReadDir(s.fsys, dir)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		heights := make([]uint64, 0, len(entries))
+		for _, entry := range entries {
+			h, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ".json"), 10, 64)
+			if err == nil {
+				heights = append(heights, h)
+			}
+		}
+		sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+		i := sort.Search(len(heights), func(i int) bool { return heights[i] > height })
+		if i == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveFile(path.Join(dir, fmt.Sprintf("%d.json", heights[i-1])))(w, r)
+	}
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, data []byte) {
+	s.mu.Lock()
+	var mutations []func(v any) any
+	for pattern, fns := range s.mutations {
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			mutations = append(mutations, fns...)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(mutations) > 0 {
+		var v any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err := decoder.Decode(&v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, fn := range mutations {
+			v = fn(v)
+		}
+		data, err = json.Marshal(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
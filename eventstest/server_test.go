@@ -0,0 +1,61 @@
+package eventstest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestServer(t *testing.T) {
+	server := NewServerFS(fstest.MapFS{
+		"agent.json":       {Data: []byte(`[{"id":1,"availableBalance":"10"},{"id":2,"availableBalance":"20"}]`)},
+		"ifil/100.json":    {Data: []byte(`{"height":100,"iFILTotalSupply":"1"}`)},
+		"ifil/200.json":    {Data: []byte(`{"height":200,"iFILTotalSupply":"2"}`)},
+		"agent/1/tx.json":  {Data: []byte(`[]`)},
+		"metrics/notes.md": {Data: []byte(`not a height`)},
+	})
+	defer server.Close()
+
+	status, body := get(t, server.URL+"/ifil/150/total-supply")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"height":100,"iFILTotalSupply":"1"}`, body)
+
+	status, _ = get(t, server.URL+"/ifil/99/total-supply")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = get(t, server.URL+"/agent/2/tx")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	server.SetField("/agent", "id", 2, "availableBalance", "21")
+	_, body = get(t, server.URL+"/agent")
+	assert.JSONEq(t, `[{"id":1,"availableBalance":"10"},{"id":2,"availableBalance":"21"}]`, body)
+
+	server.Fail("/agent/*/tx", http.StatusBadGateway)
+	status, _ = get(t, server.URL+"/agent/1/tx")
+	assert.Equal(t, http.StatusBadGateway, status)
+
+	server.Reset()
+	status, body = get(t, server.URL+"/agent/1/tx")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `[]`, body)
+
+	assert.Equal(t, []string{
+		"/ifil/150/total-supply", "/ifil/99/total-supply", "/agent/2/tx", "/agent", "/agent/1/tx", "/agent/1/tx",
+	}, server.Requests())
+}
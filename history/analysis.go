@@ -0,0 +1,163 @@
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// Statuses stored for a check, as reported by the run
+const (
+	StatusPass    = "pass"
+	StatusFail    = "fail"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+	StatusKnown   = "known"
+)
+
+// TargetRun is the outcome of checking a target in one run: it failed if any of
+// its results failed
+type TargetRun struct {
+	RunID     int64
+	Invariant string
+	Target    string
+	CheckedAt time.Time
+	// Epoch is the first epoch that failed, or the last one checked
+	Epoch  uint64
+	Failed bool
+	// Conclusive is false if the target was only skipped or couldn't be checked
+	Conclusive bool
+}
+
+type targetKey struct {
+	invariant string
+	target    string
+}
+
+// TargetRuns groups results by invariant and target, in the order of the runs
+func TargetRuns(results []Result) [][]TargetRun {
+	index := make(map[targetKey]int)
+	var grouped [][]TargetRun
+	for _, r := range results {
+		key := targetKey{r.Invariant, r.Target}
+		i, ok := index[key]
+		if !ok {
+			i = len(grouped)
+			index[key] = i
+			grouped = append(grouped, nil)
+		}
+
+		runs := grouped[i]
+		if len(runs) == 0 || runs[len(runs)-1].RunID != r.RunID {
+			runs = append(runs, TargetRun{
+				RunID:     r.RunID,
+				Invariant: r.Invariant,
+				Target:    r.Target,
+				CheckedAt: r.CheckedAt,
+				Epoch:     r.Epoch,
+			})
+		}
+		run := &runs[len(runs)-1]
+		failed := r.Status == StatusFail || r.Status == StatusKnown
+		if !run.Failed {
+			run.Epoch = r.Epoch
+		}
+		run.Failed = run.Failed || failed
+		run.Conclusive = run.Conclusive || failed || r.Status == StatusPass
+		grouped[i] = runs
+	}
+
+	sort.Slice(grouped, func(i, j int) bool {
+		a, b := grouped[i][0], grouped[j][0]
+		if a.Invariant != b.Invariant {
+			return a.Invariant < b.Invariant
+		}
+		return a.Target < b.Target
+	})
+	return grouped
+}
+
+// Streak is a target that failed in its latest conclusive run, and in every
+// conclusive run since Since
+type Streak struct {
+	Invariant  string
+	Target     string
+	Since      time.Time
+	SinceEpoch uint64
+	// Runs is the number of conclusive runs in the streak
+	Runs int
+	// LastPass is the last run the target passed, or zero if it never did
+	LastPass time.Time
+}
+
+// FailingStreaks returns the targets that are failing, with how long they've
+// been failing for. Runs that couldn't check a target don't end its streak.
+func FailingStreaks(results []Result) []Streak {
+	var streaks []Streak
+	for _, runs := range TargetRuns(results) {
+		var streak *Streak
+		for i := len(runs) - 1; i >= 0; i-- {
+			run := runs[i]
+			if !run.Conclusive {
+				continue
+			}
+			if !run.Failed {
+				if streak != nil {
+					streak.LastPass = run.CheckedAt
+				}
+				break
+			}
+			if streak == nil {
+				streak = &Streak{Invariant: run.Invariant, Target: run.Target}
+			}
+			streak.Since = run.CheckedAt
+			streak.SinceEpoch = run.Epoch
+			streak.Runs++
+		}
+		if streak != nil {
+			streaks = append(streaks, *streak)
+		}
+	}
+	return streaks
+}
+
+// Flap is a target whose status keeps changing between passing and failing
+type Flap struct {
+	Invariant string
+	Target    string
+	// Changes is the number of times the status changed in Runs conclusive runs
+	Changes int
+	Runs    int
+}
+
+// Flapping returns the targets whose status changed at least minChanges times
+// in their last runs conclusive runs
+func Flapping(results []Result, runs int, minChanges int) []Flap {
+	var flaps []Flap
+	for _, targetRuns := range TargetRuns(results) {
+		var conclusive []TargetRun
+		for _, run := range targetRuns {
+			if run.Conclusive {
+				conclusive = append(conclusive, run)
+			}
+		}
+		if len(conclusive) > runs {
+			conclusive = conclusive[len(conclusive)-runs:]
+		}
+
+		var changes int
+		for i := 1; i < len(conclusive); i++ {
+			if conclusive[i].Failed != conclusive[i-1].Failed {
+				changes++
+			}
+		}
+		if len(conclusive) > 0 && changes >= minChanges {
+			flaps = append(flaps, Flap{
+				Invariant: conclusive[0].Invariant,
+				Target:    conclusive[0].Target,
+				Changes:   changes,
+				Runs:      len(conclusive),
+			})
+		}
+	}
+	return flaps
+}
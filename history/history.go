@@ -0,0 +1,236 @@
+// Package history stores the results of every run in a local SQLite database,
+// so failures can be followed across runs: how long a target has been failing,
+// which invariants flap, and how the difference between the API and the node
+// changes over time.
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	invariant   TEXT NOT NULL,
+	started_at  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id      INTEGER NOT NULL REFERENCES runs(id),
+	target      TEXT NOT NULL,
+	epoch       INTEGER NOT NULL,
+	field       TEXT NOT NULL,
+	api         TEXT,
+	node        TEXT,
+	status      TEXT NOT NULL,
+	message     TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_invariant ON runs (invariant, started_at);
+CREATE INDEX IF NOT EXISTS results_run ON results (run_id);
+`
+
+// Result is one stored check result. A check comparing several fields is stored
+// as one result per field, and a check that didn't compare any values, for
+// example because it errored, as a single result without a field.
+type Result struct {
+	RunID     int64
+	Invariant string
+	Target    string
+	// Epoch is 0 for checks of the latest values
+	Epoch uint64
+	Field string
+	// API and Node are nil if the check didn't compare values
+	API      *big.Int
+	Node     *big.Int
+	Status   string
+	Message  string
+	Duration time.Duration
+	// CheckedAt is when the run started
+	CheckedAt time.Time
+}
+
+// Delta returns API - Node, or nil if the result has no values
+func (r *Result) Delta() *big.Int {
+	if r.API == nil || r.Node == nil {
+		return nil
+	}
+	return new(big.Int).Sub(r.API, r.Node)
+}
+
+// DB is a result history database
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the history database at path
+func Open(path string) (*DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, and a run only writes once
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("PRAGMA busy_timeout = 5000")
+	if err == nil {
+		_, err = db.Exec(schema)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history %s: %v", path, err)
+	}
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// AddRun stores the results of a run of invariant started at startedAt,
+// returning the run's ID
+func (d *DB) AddRun(invariant string, startedAt time.Time, results []Result) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO runs (invariant, started_at) VALUES (?, ?)", invariant, startedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO results
+		(run_id, target, epoch, field, api, node, status, message, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, r := range results {
+		_, err := stmt.Exec(runID, r.Target, int64(r.Epoch), r.Field, bigString(r.API), bigString(r.Node),
+			r.Status, r.Message, r.Duration.Milliseconds())
+		if err != nil {
+			return 0, err
+		}
+	}
+	return runID, tx.Commit()
+}
+
+// Filter selects results. Empty fields match everything.
+type Filter struct {
+	Invariant string
+	Target    string
+	Field     string
+	Since     time.Time
+}
+
+// Results returns the results matching filter, oldest run first
+func (d *DB) Results(filter Filter) ([]Result, error) {
+	query := `SELECT r.run_id, runs.invariant, r.target, r.epoch, r.field, r.api, r.node,
+		r.status, r.message, r.duration_ms, runs.started_at
+		FROM results r JOIN runs ON runs.id = r.run_id WHERE 1 = 1`
+	var args []any
+	if filter.Invariant != "" {
+		query += " AND runs.invariant = ?"
+		args = append(args, filter.Invariant)
+	}
+	if filter.Target != "" {
+		query += " AND r.target = ?"
+		args = append(args, filter.Target)
+	}
+	if filter.Field != "" {
+		query += " AND r.field = ?"
+		args = append(args, filter.Field)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND runs.started_at >= ?"
+		args = append(args, filter.Since.UnixMilli())
+	}
+	query += " ORDER BY runs.started_at, r.run_id, r.rowid"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var r Result
+		var epoch, durationMS, startedAt int64
+		var api, node sql.NullString
+		err := rows.Scan(&r.RunID, &r.Invariant, &r.Target, &epoch, &r.Field, &api, &node,
+			&r.Status, &r.Message, &durationMS, &startedAt)
+		if err != nil {
+			return nil, err
+		}
+		r.Epoch = uint64(epoch)
+		r.API = parseBig(api)
+		r.Node = parseBig(node)
+		r.Duration = time.Duration(durationMS) * time.Millisecond
+		r.CheckedAt = time.UnixMilli(startedAt)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func bigString(v *big.Int) sql.NullString {
+	if v == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: v.String(), Valid: true}
+}
+
+func parseBig(s sql.NullString) *big.Int {
+	if !s.Valid {
+		return nil
+	}
+	v, ok := new(big.Int).SetString(s.String, 10)
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// LastChecked returns when each target of invariant was last checked by a check
+// that completed
+func (d *DB) LastChecked(invariant string) (map[string]time.Time, error) {
+	rows, err := d.db.Query(`SELECT r.target, MAX(runs.started_at)
+		FROM results r JOIN runs ON runs.id = r.run_id
+		WHERE runs.invariant = ? AND r.status IN (?, ?, ?)
+		GROUP BY r.target`, invariant, StatusPass, StatusFail, StatusKnown)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastChecked := make(map[string]time.Time)
+	for rows.Next() {
+		var target string
+		var startedAt int64
+		err := rows.Scan(&target, &startedAt)
+		if err != nil {
+			return nil, err
+		}
+		lastChecked[target] = time.UnixMilli(startedAt)
+	}
+	return lastChecked, rows.Err()
+}
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"bytes"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// addRuns stores a run of invariant for target every hour from start, with the
+// given statuses
+func addRuns(t *testing.T, db *DB, invariant string, target string, statuses ...string) {
+	for i, status := range statuses {
+		result := Result{Target: target, Epoch: uint64(4300000 + 120*i), Status: status}
+		if status == StatusFail || status == StatusPass {
+			result.Field = "available_balance"
+			result.API = big.NewInt(1000)
+			result.Node = big.NewInt(1000)
+			if status == StatusFail {
+				result.Node = big.NewInt(int64(1000 - 10*i))
+			}
+		}
+		_, err := db.AddRun(invariant, start.Add(time.Duration(i)*time.Hour), []Result{result})
+		assert.Nil(t, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "history", "results.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	runID, err := db.AddRun("metrics", start, []Result{
+		{Epoch: 4300000, Field: "pool_total_assets", API: big.NewInt(5), Node: big.NewInt(7), Status: StatusFail,
+			Duration: 1500 * time.Millisecond},
+		{Epoch: 4300000, Field: "agent_count", API: big.NewInt(2), Node: big.NewInt(2), Status: StatusFail},
+	})
+	assert.Nil(t, err)
+	_, err = db.AddRun("agent-balances", start, []Result{
+		{Target: "Agent 1", Status: StatusError, Message: "deadline exceeded"},
+	})
+	assert.Nil(t, err)
+
+	results, err := db.Results(Filter{Invariant: "metrics"})
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, runID, results[0].RunID)
+	assert.Equal(t, "pool_total_assets", results[0].Field)
+	assert.EqualValues(t, 4300000, results[0].Epoch)
+	assert.Equal(t, big.NewInt(-2), results[0].Delta())
+	assert.Equal(t, 1500*time.Millisecond, results[0].Duration)
+	assert.True(t, start.Equal(results[0].CheckedAt))
+
+	results, err = db.Results(Filter{Target: "Agent 1"})
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].API)
+	assert.Nil(t, results[0].Delta())
+	assert.Equal(t, "deadline exceeded", results[0].Message)
+
+	results, err = db.Results(Filter{Field: "agent_count"})
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+
+	results, err = db.Results(Filter{Since: start.Add(time.Minute)})
+	assert.Nil(t, err)
+	assert.Len(t, results, 0)
+}
+
+func TestLastChecked(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	addRuns(t, db, "agent-balances", "Agent 1", StatusPass, StatusFail, StatusError)
+	addRuns(t, db, "agent-balances", "Agent 2", StatusError)
+	addRuns(t, db, "agent-econ", "Agent 3", StatusPass)
+
+	lastChecked, err := db.LastChecked("agent-balances")
+	assert.Nil(t, err)
+	assert.Len(t, lastChecked, 1)
+	assert.True(t, start.Add(time.Hour).Equal(lastChecked["Agent 1"]))
+}
+
+func TestFailingStreaks(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// Errors and skips don't end a streak
+	addRuns(t, db, "agent-balances", "Agent 1", StatusPass, StatusFail, StatusError, StatusKnown, StatusSkipped, StatusFail)
+	addRuns(t, db, "agent-balances", "Agent 2", StatusFail, StatusFail)
+	addRuns(t, db, "agent-balances", "Agent 3", StatusFail, StatusPass)
+
+	results, err := db.Results(Filter{})
+	assert.Nil(t, err)
+	streaks := FailingStreaks(results)
+	assert.Len(t, streaks, 2)
+
+	assert.Equal(t, "Agent 1", streaks[0].Target)
+	assert.True(t, start.Add(time.Hour).Equal(streaks[0].Since))
+	assert.EqualValues(t, 4300120, streaks[0].SinceEpoch)
+	assert.Equal(t, 3, streaks[0].Runs)
+	assert.True(t, start.Equal(streaks[0].LastPass))
+
+	assert.Equal(t, "Agent 2", streaks[1].Target)
+	assert.Equal(t, 2, streaks[1].Runs)
+	assert.True(t, streaks[1].LastPass.IsZero())
+}
+
+func TestFlapping(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	addRuns(t, db, "metrics", "", StatusPass, StatusFail, StatusPass, StatusError, StatusFail, StatusPass)
+	addRuns(t, db, "ifil-total-supply", "", StatusPass, StatusFail, StatusFail, StatusFail)
+
+	results, err := db.Results(Filter{})
+	assert.Nil(t, err)
+
+	flaps := Flapping(results, 10, 3)
+	assert.Equal(t, []Flap{{Invariant: "metrics", Changes: 4, Runs: 5}}, flaps)
+
+	// Only the last 3 conclusive runs
+	flaps = Flapping(results, 3, 2)
+	assert.Equal(t, []Flap{{Invariant: "metrics", Changes: 2, Runs: 3}}, flaps)
+}
+
+func TestPlot(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	addRuns(t, db, "agent-balances", "Agent 1", StatusPass, StatusFail, StatusError, StatusFail)
+	results, err := db.Results(Filter{Target: "Agent 1"})
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	PlotDelta(&w, results, 10)
+	lines := strings.Split(strings.TrimRight(w.String(), "\n"), "\n")
+	assert.Len(t, lines, 4, w.String())
+	assert.Contains(t, lines[1], "2026-10-01 12:00:00  @4300000  available_balance                   0            |")
+	assert.Contains(t, lines[2], "2026-10-01 13:00:00  @4300120  available_balance                 +10            |###")
+	assert.Contains(t, lines[3], "2026-10-01 15:00:00  @4300360  available_balance                 +30            |##########")
+
+	w.Reset()
+	assert.Nil(t, WriteCSV(&w, results))
+	lines = strings.Split(strings.TrimRight(w.String(), "\n"), "\n")
+	assert.Len(t, lines, 5)
+	assert.Equal(t, "run,checked,invariant,target,epoch,field,api,node,delta,status,duration_ms", lines[0])
+	assert.Equal(t, "3,2026-10-01T14:00:00Z,agent-balances,Agent 1,4300240,,,,,error,0", lines[3])
+	assert.Equal(t, "4,2026-10-01T15:00:00Z,agent-balances,Agent 1,4300360,available_balance,1000,970,30,fail,0", lines[4])
+}
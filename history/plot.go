@@ -0,0 +1,101 @@
+package history
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PlotDelta draws the API - node difference of the results with values as a
+// horizontal bar chart, one line per result, with bars of up to width
+// characters on either side of the axis
+func PlotDelta(w io.Writer, results []Result, width int) {
+	var rows []Result
+	maxAbs := new(big.Int)
+	for _, r := range results {
+		delta := r.Delta()
+		if delta == nil {
+			continue
+		}
+		rows = append(rows, r)
+		if new(big.Int).Abs(delta).Cmp(maxAbs) > 0 {
+			maxAbs = new(big.Int).Abs(delta)
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(w, "No values to plot.")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "checked\tepoch\tfield\tdelta (API - node)\t\t")
+	for _, r := range rows {
+		delta := r.Delta()
+		bar := barLength(delta, maxAbs, width)
+		left := strings.Repeat(" ", width)
+		right := ""
+		if delta.Sign() < 0 {
+			left = strings.Repeat(" ", width-bar) + strings.Repeat("#", bar)
+		} else {
+			right = strings.Repeat("#", bar)
+		}
+		fmt.Fprintf(tw, "%s\t@%d\t%s\t%s\t\t%s|%s\n",
+			r.CheckedAt.UTC().Format(time.DateTime), r.Epoch, r.Field, signed(delta), left, right)
+	}
+	tw.Flush()
+}
+
+// barLength scales |delta| to at most width, drawing any difference as at least
+// one character
+func barLength(delta *big.Int, maxAbs *big.Int, width int) int {
+	if delta.Sign() == 0 || maxAbs.Sign() == 0 {
+		return 0
+	}
+	scaled := new(big.Int).Mul(new(big.Int).Abs(delta), big.NewInt(int64(width)))
+	scaled.Quo(scaled, maxAbs)
+	if scaled.Sign() == 0 {
+		return 1
+	}
+	return int(scaled.Int64())
+}
+
+func signed(v *big.Int) string {
+	if v.Sign() > 0 {
+		return "+" + v.String()
+	}
+	return v.String()
+}
+
+// WriteCSV writes the results as CSV, with the API - node difference
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"run", "checked", "invariant", "target", "epoch", "field", "api", "node", "delta", "status", "duration_ms"})
+	for _, r := range results {
+		cw.Write([]string{
+			fmt.Sprint(r.RunID),
+			r.CheckedAt.UTC().Format(time.RFC3339),
+			r.Invariant,
+			r.Target,
+			fmt.Sprint(r.Epoch),
+			r.Field,
+			optional(r.API),
+			optional(r.Node),
+			optional(r.Delta()),
+			r.Status,
+			fmt.Sprint(r.Duration.Milliseconds()),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func optional(v *big.Int) string {
+	if v == nil {
+		return ""
+	}
+	return v.String()
+}
@@ -69,9 +69,11 @@ func GetIFILTotalSupplyFromNode(ctx context.Context, height uint64) (*IFILTotalS
 	}
 
 	blockNumber := big.NewInt(int64(height))
-	q := singleton.PoolsSDK.Query()
+	chain := singleton.Chain()
 
-	totalSupply, err := q.IFILSupply(ctx, blockNumber)
+	totalSupply, err := singleton.Cached("IFILSupply", height, nil, func() (*big.Int, error) {
+		return chain.IFILSupply(ctx, blockNumber)
+	})
 	if err != nil {
 		return nil, height, err
 	}
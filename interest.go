@@ -0,0 +1,162 @@
+package invariants
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/go-pools/econ"
+	"github.com/glifio/invariants/singleton"
+)
+
+const (
+	TxTypeBorrow = "borrow"
+	TxTypePay    = "pay"
+)
+
+var wad = big.NewInt(1e18)
+
+type AgentInterestResult struct {
+	Height       uint64
+	Account      abigen.Account
+	Rate         *big.Int
+	InterestOwed *big.Int
+}
+
+// GetAgentInterestFromNode calls the node to get the Infinity Pool account for an agent
+// and the interest owed on it at height
+func GetAgentInterestFromNode(ctx context.Context, agent *Agent, height uint64) (*AgentInterestResult, uint64, error) {
+	height, err := getNextEpoch(ctx, height)
+	if err != nil {
+		return nil, height, err
+	}
+
+	blockNumber := big.NewInt(int64(height))
+	chain := singleton.Chain()
+
+	account, err := chain.AgentAccount(ctx, agent.AddressNative, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	rate, err := chain.PoolRate(ctx, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	result := AgentInterestResult{
+		Height:       height,
+		Account:      account,
+		Rate:         rate,
+		InterestOwed: econ.InterestOwed(ctx, account, rate, abi.ChainEpoch(height)),
+	}
+
+	return &result, height, nil
+}
+
+// GetPoolRateFromNode calls the node to get the Infinity Pool per epoch rate at height
+func GetPoolRateFromNode(ctx context.Context, height uint64) (*big.Int, uint64, error) {
+	height, err := getNextEpoch(ctx, height)
+	if err != nil {
+		return nil, height, err
+	}
+
+	rate, err := singleton.Chain().PoolRate(ctx, big.NewInt(int64(height)))
+	if err != nil {
+		return nil, height, err
+	}
+
+	return rate, height, nil
+}
+
+type InterestPayment struct {
+	Tx            Transaction
+	InterestOwed  *big.Int
+	InterestPaid  *big.Int
+	PrincipalPaid *big.Int
+}
+
+type ReplayedAccount struct {
+	Account  abigen.Account
+	Payments []InterestPayment
+}
+
+// ReplayAgentAccount replays the borrows and payments from the transaction history of an
+// agent up to and including height, mirroring the accounting of the Infinity Pool.
+// rateAt returns the pool rate in effect at an epoch.
+func ReplayAgentAccount(
+	ctx context.Context,
+	txs []Transaction,
+	height uint64,
+	rateAt func(epoch uint64) (*big.Int, error),
+) (*ReplayedAccount, error) {
+	account := abigen.Account{
+		StartEpoch: big.NewInt(0),
+		Principal:  big.NewInt(0),
+		EpochsPaid: big.NewInt(0),
+	}
+	payments := make([]InterestPayment, 0)
+
+	for _, tx := range txs {
+		if tx.Height > height {
+			break
+		}
+		epoch := new(big.Int).SetUint64(tx.Height)
+
+		switch strings.ToLower(tx.Type) {
+		case TxTypeBorrow:
+			if account.Principal.Sign() == 0 {
+				account.StartEpoch = epoch
+				account.EpochsPaid = epoch
+			}
+			account.Principal = new(big.Int).Add(account.Principal, tx.Amount)
+
+		case TxTypePay:
+			rate, err := rateAt(tx.Height)
+			if err != nil {
+				return nil, err
+			}
+			owed := econ.InterestOwed(ctx, account, rate, abi.ChainEpoch(tx.Height))
+			payment := InterestPayment{
+				Tx:            tx,
+				InterestOwed:  owed,
+				InterestPaid:  new(big.Int).Set(tx.Amount),
+				PrincipalPaid: big.NewInt(0),
+			}
+
+			if tx.Amount.Cmp(owed) <= 0 {
+				// Partial interest payment only moves epochsPaid forward
+				interestPerEpoch := new(big.Int).Mul(account.Principal, rate)
+				interestPerEpoch.Div(interestPerEpoch, wad)
+				if interestPerEpoch.Sign() > 0 {
+					epochsForward := new(big.Int).Mul(tx.Amount, wad)
+					epochsForward.Div(epochsForward, interestPerEpoch)
+					account.EpochsPaid = new(big.Int).Add(account.EpochsPaid, epochsForward)
+				}
+			} else {
+				payment.InterestPaid = owed
+				payment.PrincipalPaid = new(big.Int).Sub(tx.Amount, owed)
+				if payment.PrincipalPaid.Cmp(account.Principal) >= 0 {
+					// Any excess is refunded and the account is closed
+					payment.PrincipalPaid = new(big.Int).Set(account.Principal)
+					account.StartEpoch = big.NewInt(0)
+					account.Principal = big.NewInt(0)
+					account.EpochsPaid = big.NewInt(0)
+				} else {
+					account.Principal = new(big.Int).Sub(account.Principal, payment.PrincipalPaid)
+					account.EpochsPaid = epoch
+				}
+			}
+			payments = append(payments, payment)
+		}
+	}
+
+	return &ReplayedAccount{Account: account, Payments: payments}, nil
+}
+
+// InterestOwedAt computes the interest owed on an account at height
+func InterestOwedAt(ctx context.Context, account abigen.Account, rate *big.Int, height uint64) *big.Int {
+	return econ.InterestOwed(ctx, account, rate, abi.ChainEpoch(height))
+}
@@ -0,0 +1,130 @@
+package invariants
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/glifio/go-pools/abigen"
+	"github.com/stretchr/testify/assert"
+)
+
+// The pool rate is per epoch with two WADs of precision: at rate1, 1000 FIL of
+// principal owes 1e12 attoFIL per epoch
+var (
+	rate1 = big.NewInt(1e9)
+	rate2 = big.NewInt(2e9)
+)
+
+func init() {
+	rate1.Mul(rate1, wad)
+	rate2.Mul(rate2, wad)
+}
+
+func interestTx(txType string, height uint64, amount *big.Int) Transaction {
+	return Transaction{Type: txType, Height: height, Amount: amount}
+}
+
+// rateChangingAt returns rate1 before epoch and rate2 from epoch on
+func rateChangingAt(epoch uint64) func(uint64) (*big.Int, error) {
+	return func(e uint64) (*big.Int, error) {
+		if e < epoch {
+			return rate1, nil
+		}
+		return rate2, nil
+	}
+}
+
+func TestReplayAgentAccountBorrows(t *testing.T) {
+	ctx := context.Background()
+	txs := []Transaction{
+		interestTx("borrow", 100, bigFIL(1000)),
+		interestTx("Borrow", 200, bigFIL(500)),
+	}
+
+	// The rate changes between the borrows, interest accrues from the first one at
+	// the rate in effect when it's paid
+	replayed, err := ReplayAgentAccount(ctx, txs, 300, rateChangingAt(150))
+	assert.Nil(t, err)
+	assert.Len(t, replayed.Payments, 0)
+	assert.EqualValues(t, 100, replayed.Account.StartEpoch.Int64())
+	assert.EqualValues(t, 100, replayed.Account.EpochsPaid.Int64())
+	assert.Equal(t, bigFIL(1500), replayed.Account.Principal)
+
+	// 1500 FIL at rate2 owes 3e12 per epoch, for 200 epochs
+	assert.Equal(t, big.NewInt(6e14), InterestOwedAt(ctx, replayed.Account, rate2, 300))
+	assert.Equal(t, big.NewInt(0), InterestOwedAt(ctx, replayed.Account, rate2, 100))
+
+	// Transactions after height aren't replayed
+	replayed, err = ReplayAgentAccount(ctx, txs, 199, rateChangingAt(150))
+	assert.Nil(t, err)
+	assert.Equal(t, bigFIL(1000), replayed.Account.Principal)
+}
+
+func TestReplayAgentAccountPayments(t *testing.T) {
+	ctx := context.Background()
+	txs := []Transaction{
+		interestTx("borrow", 100, bigFIL(1000)),
+		interestTx("borrow", 200, bigFIL(500)),
+		// Half the 6e14 owed moves epochsPaid forward by 100 epochs
+		interestTx("pay", 300, big.NewInt(3e14)),
+		// 1 attoFIL more than the 6e14 owed pays 1 attoFIL of principal
+		interestTx("pay", 400, big.NewInt(6e14+1)),
+	}
+
+	replayed, err := ReplayAgentAccount(ctx, txs, 400, rateChangingAt(150))
+	assert.Nil(t, err)
+	assert.Len(t, replayed.Payments, 2)
+
+	partial := replayed.Payments[0]
+	assert.Equal(t, big.NewInt(6e14), partial.InterestOwed)
+	assert.Equal(t, big.NewInt(3e14), partial.InterestPaid)
+	assert.Equal(t, big.NewInt(0), partial.PrincipalPaid)
+
+	over := replayed.Payments[1]
+	assert.Equal(t, big.NewInt(6e14), over.InterestOwed)
+	assert.Equal(t, big.NewInt(6e14), over.InterestPaid)
+	assert.Equal(t, big.NewInt(1), over.PrincipalPaid)
+
+	principal := new(big.Int).Sub(bigFIL(1500), big.NewInt(1))
+	assert.Equal(t, principal, replayed.Account.Principal)
+	assert.EqualValues(t, 400, replayed.Account.EpochsPaid.Int64())
+	assert.Equal(t, big.NewInt(0), InterestOwedAt(ctx, replayed.Account, rate2, 400))
+
+	// Paying exactly what's owed pays no principal
+	txs[3].Amount = big.NewInt(6e14)
+	replayed, err = ReplayAgentAccount(ctx, txs, 400, rateChangingAt(150))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(0), replayed.Payments[1].PrincipalPaid)
+	assert.Equal(t, bigFIL(1500), replayed.Account.Principal)
+	assert.EqualValues(t, 400, replayed.Account.EpochsPaid.Int64())
+
+	// Paying more than the principal and interest closes the account
+	txs = append(txs, interestTx("pay", 500, bigFIL(2000)))
+	replayed, err = ReplayAgentAccount(ctx, txs, 500, rateChangingAt(150))
+	assert.Nil(t, err)
+	payoff := replayed.Payments[2]
+	assert.Equal(t, big.NewInt(3e14), payoff.InterestPaid)
+	assert.Equal(t, bigFIL(1500), payoff.PrincipalPaid)
+	assert.Equal(t, abigen.Account{StartEpoch: big.NewInt(0), Principal: big.NewInt(0), EpochsPaid: big.NewInt(0)},
+		replayed.Account)
+
+	// A borrow after closing starts a new account
+	txs = append(txs, interestTx("borrow", 600, bigFIL(10)))
+	replayed, err = ReplayAgentAccount(ctx, txs, 600, rateChangingAt(150))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 600, replayed.Account.StartEpoch.Int64())
+	assert.Equal(t, bigFIL(10), replayed.Account.Principal)
+}
+
+func TestReplayAgentAccountRateError(t *testing.T) {
+	txs := []Transaction{
+		interestTx("borrow", 100, bigFIL(1000)),
+		interestTx("pay", 300, big.NewInt(1)),
+	}
+	_, err := ReplayAgentAccount(context.Background(), txs, 300, func(uint64) (*big.Int, error) {
+		return nil, errors.New("node unavailable")
+	})
+	assert.EqualError(t, err, "node unavailable")
+}
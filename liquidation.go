@@ -0,0 +1,153 @@
+package invariants
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/glifio/invariants/singleton"
+)
+
+// Lotus limits eth_getLogs to a day of epochs by default
+const maxFilterHeightRange = 2880
+
+type AgentDefaultResult struct {
+	Height         uint64
+	Defaulted      bool
+	Administration common.Address
+	Liquidated     bool
+	MaxDTE         *big.Int
+	Principal      *big.Int
+}
+
+// GetAgentDefaultFromNode calls the node to get the default and liquidation state of an agent
+func GetAgentDefaultFromNode(ctx context.Context, agent *Agent, height uint64) (*AgentDefaultResult, uint64, error) {
+	chain := singleton.Chain()
+
+	height, err := getNextEpoch(ctx, height)
+	if err != nil {
+		return nil, height, err
+	}
+
+	blockNumber := big.NewInt(int64(height))
+
+	status, err := chain.AgentStatus(ctx, agent.AddressNative, agent.ID, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	principal, err := chain.AgentPrincipal(ctx, agent.AddressNative, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	result := AgentDefaultResult{
+		Height:         height,
+		Defaulted:      status.Defaulted,
+		Administration: status.Administration,
+		Liquidated:     status.Liquidated,
+		MaxDTE:         status.MaxDTE,
+		Principal:      principal,
+	}
+
+	return &result, height, nil
+}
+
+// GetAgentLiquidationValueFromNode calls the node to get the liquidation value of
+// the miners of an agent at height: what terminating all their sectors would
+// recover
+func GetAgentLiquidationValueFromNode(ctx context.Context, agent *Agent, height uint64) (*big.Int, error) {
+	chain := singleton.Chain()
+
+	ts, err := chain.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(height))
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.AgentLiquidationValue(ctx, agent.AddressNative, ts)
+}
+
+type WriteOff struct {
+	AgentID        uint64
+	Height         uint64
+	TxHash         common.Hash
+	RecoveredFunds *big.Int
+	LostFunds      *big.Int
+	InterestPaid   *big.Int
+}
+
+// WriteOffs are the Infinity Pool write offs of every agent, read from the node a
+// day of epochs at a time. Each day is read once and shared by the agents checked
+// in a run, and days older than finality are kept in the node cache.
+type WriteOffs struct {
+	mu   sync.Mutex
+	days map[uint64]writeOffDay
+}
+
+// writeOffDay is the write offs of a day of epochs, read up to end
+type writeOffDay struct {
+	end       uint64
+	writeOffs []WriteOff
+}
+
+func NewWriteOffs() *WriteOffs {
+	return &WriteOffs{days: make(map[uint64]writeOffDay)}
+}
+
+// GetAgentWriteOffsFromNode calls the node to get the Infinity Pool write offs for an agent
+// between two heights
+func (w *WriteOffs) GetAgentWriteOffsFromNode(ctx context.Context, agentID uint64, minHeight uint64, maxHeight uint64) ([]WriteOff, error) {
+	writeOffs := make([]WriteOff, 0)
+	// Days start at multiples of the range, so every run reads the same ones
+	for start := minHeight - minHeight%maxFilterHeightRange; start <= maxHeight; start += maxFilterHeightRange {
+		end := min(start+maxFilterHeightRange-1, maxHeight)
+		day, err := w.day(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, writeOff := range day {
+			if writeOff.AgentID == agentID && writeOff.Height >= minHeight && writeOff.Height <= maxHeight {
+				writeOffs = append(writeOffs, writeOff)
+			}
+		}
+	}
+
+	return writeOffs, nil
+}
+
+// day returns the write offs of every agent from start to at least end
+func (w *WriteOffs) day(ctx context.Context, start uint64, end uint64) ([]WriteOff, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if day, ok := w.days[start]; ok && day.end >= end {
+		return day.writeOffs, nil
+	}
+
+	chain := singleton.Chain()
+	writeOffs, err := singleton.Cached("PoolWriteOffs", end, []any{start}, func() ([]WriteOff, error) {
+		events, err := chain.PoolWriteOffs(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+		writeOffs := make([]WriteOff, 0, len(events))
+		for _, event := range events {
+			writeOffs = append(writeOffs, WriteOff{
+				AgentID:        event.AgentID.Uint64(),
+				Height:         event.Raw.BlockNumber,
+				TxHash:         event.Raw.TxHash,
+				RecoveredFunds: event.RecoveredFunds,
+				LostFunds:      event.LostFunds,
+				InterestPaid:   event.InterestPaid,
+			})
+		}
+		return writeOffs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.days[start] = writeOffDay{end, writeOffs}
+	return writeOffs, nil
+}
@@ -0,0 +1,261 @@
+package invariants
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	gethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/invariants/singleton"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+type AgentMessage struct {
+	Cid     cid.Cid
+	TxHash  common.Hash
+	Message *types.Message
+	Receipt *types.MessageReceipt
+	// Decoded contract call, empty if the message isn't an EVM call to a known contract
+	Call string
+	// Decoded events emitted by the agent, the Infinity Pool or WFIL
+	Events []string
+}
+
+type contractABI struct {
+	name string
+	abi  *gethabi.ABI
+}
+
+// GetAgentMessagesFromNode calls the node to get the messages included at height that
+// touch the agent, the Infinity Pool or WFIL, with their receipts and the contract calls
+// and events decoded using the go-pools ABIs. They're the messages executed between
+// the balance at height and the one before, both read after getNextEpoch.
+func GetAgentMessagesFromNode(ctx context.Context, agent *Agent, height uint64) ([]AgentMessage, uint64, error) {
+	chain := singleton.Chain()
+
+	// The state at height is the parent state of the next tipset, which has the
+	// receipts of the messages included in its parent
+	execHeight, err := getNextEpoch(ctx, height)
+	if err != nil {
+		return nil, height, err
+	}
+	execTs, err := chain.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(execHeight))
+	if err != nil {
+		return nil, height, err
+	}
+	ts, err := chain.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(execHeight-1))
+	if err != nil {
+		return nil, height, err
+	}
+	height = uint64(ts.Height())
+
+	msgs, err := chain.ChainGetMessagesInTipset(ctx, ts.Key())
+	if err != nil {
+		return nil, height, err
+	}
+	receipts, err := chain.ChainGetParentReceipts(ctx, execTs.Key())
+	if err != nil {
+		return nil, height, err
+	}
+	if len(msgs) != len(receipts) {
+		return nil, height, fmt.Errorf("%d messages but %d receipts @%d", len(msgs), len(receipts), height)
+	}
+
+	contracts := map[common.Address]contractABI{}
+	for addr, meta := range map[common.Address]struct {
+		name string
+		meta *bind.MetaData
+	}{
+		agent.AddressNative:         {"Agent", abigen.AgentMetaData},
+		chain.InfinityPoolAddress(): {"InfinityPool", abigen.InfinityPoolMetaData},
+		chain.WFILAddress():         {"WFIL", abigen.WFILMetaData},
+	} {
+		parsed, err := meta.meta.GetAbi()
+		if err != nil {
+			return nil, height, err
+		}
+		contracts[addr] = contractABI{meta.name, parsed}
+	}
+
+	// The f0 and f4 addresses of the contracts, so messages sent to either form match
+	recipients, err := contractRecipients(contracts, func(delegated address.Address) (address.Address, error) {
+		return chain.StateLookupID(ctx, delegated, ts.Key())
+	})
+	if err != nil {
+		return nil, height, err
+	}
+
+	// Events emitted by the contracts, which also catch internal calls to them
+	addresses := make([]common.Address, 0, len(contracts))
+	for addr := range contracts {
+		addresses = append(addresses, addr)
+	}
+	logs, err := chain.ContractLogs(ctx, addresses, height, execHeight-1)
+	if err != nil {
+		return nil, height, err
+	}
+	logsByCid := map[cid.Cid][]gethtypes.Log{}
+	hashByCid := map[cid.Cid]common.Hash{}
+	for _, log := range logs {
+		if log.Address != agent.AddressNative && !logMentionsAgent(log, agent) {
+			// Pool and WFIL events for other agents and accounts
+			continue
+		}
+		msgCid, err := chain.EthGetMessageCidByTransactionHash(ctx, log.TxHash)
+		if err != nil {
+			return nil, height, err
+		}
+		if msgCid == nil {
+			continue
+		}
+		logsByCid[*msgCid] = append(logsByCid[*msgCid], log)
+		hashByCid[*msgCid] = log.TxHash
+	}
+
+	result := make([]AgentMessage, 0)
+	for i, msg := range msgs {
+		contract, toContract := recipients[msg.Message.To]
+		msgLogs := logsByCid[msg.Cid]
+		if !toContract && len(msgLogs) == 0 {
+			continue
+		}
+
+		agentMsg := AgentMessage{
+			Cid:     msg.Cid,
+			TxHash:  hashByCid[msg.Cid],
+			Message: msg.Message,
+			Receipt: receipts[i],
+			Events:  make([]string, 0),
+		}
+		if agentMsg.TxHash == (common.Hash{}) {
+			txHash, err := chain.EthGetTransactionHashByCid(ctx, msg.Cid)
+			if err == nil && txHash != nil {
+				agentMsg.TxHash = *txHash
+			}
+		}
+		if toContract {
+			agentMsg.Call = decodeCall(msg.Message, contracts[contract])
+		}
+		for _, log := range msgLogs {
+			agentMsg.Events = append(agentMsg.Events, decodeEvent(log, contracts[log.Address]))
+		}
+		result = append(result, agentMsg)
+	}
+
+	return result, height, nil
+}
+
+// contractRecipients maps the delegated (f4) address of each contract, and the ID
+// (f0) address lookupID returns for it, to the contract
+func contractRecipients(
+	contracts map[common.Address]contractABI,
+	lookupID func(delegated address.Address) (address.Address, error),
+) (map[address.Address]common.Address, error) {
+	recipients := make(map[address.Address]common.Address, 2*len(contracts))
+	for addr := range contracts {
+		delegated, err := DelegatedFromEthAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		id, err := lookupID(delegated)
+		if err != nil {
+			return nil, err
+		}
+		recipients[delegated] = addr
+		recipients[id] = addr
+	}
+	return recipients, nil
+}
+
+// logMentionsAgent reports whether an indexed topic of the log is the agent address or ID
+func logMentionsAgent(log gethtypes.Log, agent *Agent) bool {
+	addrTopic := common.BytesToHash(agent.AddressNative.Bytes())
+	idTopic := common.BigToHash(new(big.Int).SetUint64(agent.ID))
+	for _, topic := range log.Topics[min(1, len(log.Topics)):] {
+		if topic == addrTopic || topic == idTopic {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeCall(msg *types.Message, contract contractABI) string {
+	if msg.Method != builtin.MethodsEVM.InvokeContract {
+		if msg.Method == builtin.MethodSend {
+			return fmt.Sprintf("%s: send", contract.name)
+		}
+		return fmt.Sprintf("%s: method %d", contract.name, msg.Method)
+	}
+
+	calldata, err := cbg.ReadByteArray(bytes.NewReader(msg.Params), uint64(len(msg.Params)))
+	if err != nil || len(calldata) < 4 {
+		return fmt.Sprintf("%s: fallback", contract.name)
+	}
+
+	method, err := contract.abi.MethodById(calldata[:4])
+	if err != nil {
+		return fmt.Sprintf("%s: unknown selector 0x%x", contract.name, calldata[:4])
+	}
+
+	args, err := method.Inputs.Unpack(calldata[4:])
+	if err != nil {
+		return fmt.Sprintf("%s.%s(<undecodable>)", contract.name, method.Name)
+	}
+	return fmt.Sprintf("%s.%s(%s)", contract.name, method.Name, formatArgs(method.Inputs, args))
+}
+
+func decodeEvent(log gethtypes.Log, contract contractABI) string {
+	if len(log.Topics) == 0 {
+		return fmt.Sprintf("%s: anonymous event", contract.name)
+	}
+
+	event, err := contract.abi.EventByID(log.Topics[0])
+	if err != nil {
+		return fmt.Sprintf("%s: unknown event %v", contract.name, log.Topics[0])
+	}
+
+	values := map[string]interface{}{}
+	err = event.Inputs.UnpackIntoMap(values, log.Data)
+	if err != nil {
+		return fmt.Sprintf("%s.%s(<undecodable>)", contract.name, event.Name)
+	}
+	indexed := make(gethabi.Arguments, 0)
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	err = gethabi.ParseTopicsIntoMap(values, indexed, log.Topics[1:])
+	if err != nil {
+		return fmt.Sprintf("%s.%s(<undecodable>)", contract.name, event.Name)
+	}
+
+	args := make([]interface{}, 0, len(event.Inputs))
+	for _, input := range event.Inputs {
+		args = append(args, values[input.Name])
+	}
+	return fmt.Sprintf("%s.%s(%s)", contract.name, event.Name, formatArgs(event.Inputs, args))
+}
+
+func formatArgs(inputs gethabi.Arguments, args []interface{}) string {
+	parts := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i < len(inputs) && inputs[i].Name != "" {
+			parts = append(parts, fmt.Sprintf("%s: %v", inputs[i].Name, arg))
+		} else {
+			parts = append(parts, fmt.Sprint(arg))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,148 @@
+package invariants
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/invariants/chaintest"
+	"github.com/stretchr/testify/assert"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// testMessage is a message from f0100 to to, calling the contract with calldata
+// if it's set
+func testMessage(t *testing.T, to address.Address, nonce uint64, calldata []byte) lotusapi.Message {
+	from, _ := address.NewIDAddress(100)
+	msg := &types.Message{From: from, To: to, Nonce: nonce, Value: types.NewInt(0), GasFeeCap: types.NewInt(0), GasPremium: types.NewInt(0)}
+	if calldata != nil {
+		var params bytes.Buffer
+		if err := cbg.WriteByteArray(&params, calldata); err != nil {
+			t.Fatal(err)
+		}
+		msg.Method = builtin.MethodsEVM.InvokeContract
+		msg.Params = params.Bytes()
+	}
+	return lotusapi.Message{Cid: msg.Cid(), Message: msg}
+}
+
+func TestGetAgentMessagesFromNode(t *testing.T) {
+	chain := chaintest.New(4300600)
+	chain.Use(t)
+	chain.NullRound(4300101)
+	ctx := context.Background()
+
+	agent := &Agent{ID: 1, AddressNative: common.HexToAddress("0x090fc62ec8f5a4f9c326f3043a765288c58d9adb")}
+	for i, addr := range []common.Address{agent.AddressNative, chaintest.InfinityPool, chaintest.WFIL} {
+		delegated, err := DelegatedFromEthAddress(addr)
+		assert.Nil(t, err)
+		id, _ := address.NewIDAddress(uint64(1001 + i))
+		chain.SetActorID(delegated, id)
+	}
+	agentID, _ := address.NewIDAddress(1001)
+	router, _ := address.NewIDAddress(2000)
+
+	agentABI, err := abigen.AgentMetaData.GetAbi()
+	assert.Nil(t, err)
+	poolABI, err := abigen.InfinityPoolMetaData.GetAbi()
+	assert.Nil(t, err)
+	owner := common.HexToAddress("0x01")
+	calldata, err := agentABI.Pack("transferOwnership", owner)
+	assert.Nil(t, err)
+	borrowLog := func(agentID int64) gethtypes.Log {
+		data, err := poolABI.Events["Borrow"].Inputs.NonIndexed().Pack(bigFIL(10))
+		assert.Nil(t, err)
+		return gethtypes.Log{
+			Address: chaintest.InfinityPool,
+			Topics:  []common.Hash{poolABI.Events["Borrow"].ID, common.BigToHash(big.NewInt(agentID))},
+			Data:    data,
+		}
+	}
+
+	// A call to the agent, a borrow through another contract, a borrow by another
+	// agent and an unrelated send, included at 4300100 and executed past the null
+	// round at 4300102
+	transfer := testMessage(t, agentID, 0, calldata)
+	borrow := testMessage(t, router, 1, []byte{1, 2, 3, 4})
+	otherBorrow := testMessage(t, router, 2, []byte{1, 2, 3, 4})
+	send := testMessage(t, router, 3, nil)
+	chain.AddMessages(4300100, transfer, borrow, otherBorrow, send)
+	for _, msg := range []lotusapi.Message{transfer, borrow, otherBorrow, send} {
+		chain.SetReceipt(msg.Cid, types.MessageReceipt{ExitCode: exitcode.Ok, GasUsed: 1})
+	}
+	chain.AddLogs(4300100, transfer.Cid, common.HexToHash("0xaa"))
+	chain.AddLogs(4300100, borrow.Cid, common.HexToHash("0xbb"), borrowLog(1))
+	chain.AddLogs(4300100, otherBorrow.Cid, common.HexToHash("0xcc"), borrowLog(2))
+
+	// The balance changes between 4300099 and 4300100, or the null round after it
+	for _, height := range []uint64{4300100, 4300101} {
+		msgs, msgHeight, err := GetAgentMessagesFromNode(ctx, agent, height)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(4300100), msgHeight)
+		if assert.Len(t, msgs, 2) {
+			assert.Equal(t, transfer.Cid, msgs[0].Cid)
+			assert.Equal(t, common.HexToHash("0xaa"), msgs[0].TxHash)
+			assert.Equal(t, "Agent.transferOwnership(newOwner: "+owner.String()+")", msgs[0].Call)
+			assert.Empty(t, msgs[0].Events)
+			assert.Equal(t, borrow.Cid, msgs[1].Cid)
+			assert.Equal(t, common.HexToHash("0xbb"), msgs[1].TxHash)
+			assert.Empty(t, msgs[1].Call, "internal call")
+			assert.Equal(t, []string{"InfinityPool.Borrow(agent: 1, amount: 10000000000000000000)"}, msgs[1].Events)
+			assert.Equal(t, exitcode.Ok, msgs[1].Receipt.ExitCode)
+		}
+	}
+
+	// Nothing was included at 4300099, which executed at 4300100
+	msgs, msgHeight, err := GetAgentMessagesFromNode(ctx, agent, 4300099)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4300099), msgHeight)
+	assert.Empty(t, msgs)
+
+	chain.Fail("ChainGetParentReceipts", errors.New("receipts unavailable"))
+	_, _, err = GetAgentMessagesFromNode(ctx, agent, 4300100)
+	assert.EqualError(t, err, "receipts unavailable")
+}
+
+func TestContractRecipients(t *testing.T) {
+	agent := common.HexToAddress("0x090fc62ec8f5a4f9c326f3043a765288c58d9adb")
+	pool := common.HexToAddress("0xe764acf02d8b7c21d2b6a8f0a96c78541e0dc3fd")
+	contracts := map[common.Address]contractABI{
+		agent: {name: "Agent"},
+		pool:  {name: "InfinityPool"},
+	}
+	agentID, _ := address.NewIDAddress(1001)
+	poolID, _ := address.NewIDAddress(1002)
+	agentDelegated, err := DelegatedFromEthAddress(agent)
+	assert.Nil(t, err)
+
+	var lookups int
+	recipients, err := contractRecipients(contracts, func(delegated address.Address) (address.Address, error) {
+		lookups++
+		assert.Equal(t, address.Delegated, delegated.Protocol())
+		if delegated == agentDelegated {
+			return agentID, nil
+		}
+		return poolID, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, lookups)
+	assert.Len(t, recipients, 4)
+	assert.Equal(t, agent, recipients[agentID])
+	assert.Equal(t, pool, recipients[poolID])
+	assert.Equal(t, agent, recipients[agentDelegated])
+
+	_, err = contractRecipients(contracts, func(address.Address) (address.Address, error) {
+		return address.Undef, errors.New("actor not found")
+	})
+	assert.EqualError(t, err, "actor not found")
+}
@@ -128,46 +128,26 @@ func getMetrics(ctx context.Context, url string) (*MetricsResult, error) {
 
 // GetMetricsFromNode calls the Lotus node to get the metrics
 func GetMetricsFromNode(ctx context.Context, height uint64) (*MetricsResult, uint64, error) {
-	sdk := singleton.PoolsSDK
+	chain := singleton.Chain()
 
 	height, err := getNextEpoch(ctx, height)
 	if err != nil {
 		return nil, height, err
 	}
 
-	ethClient, err := sdk.Extern().ConnectEthClient()
-	if err != nil {
-		return nil, height, err
-	}
-	defer ethClient.Close()
-
 	blockNumber := big.NewInt(int64(height))
 
-	infinityPool := sdk.Query().InfinityPool()
-
-	poolCaller, err := abigen.NewInfinityPoolCaller(infinityPool, ethClient)
-	if err != nil {
-		return nil, height, err
-	}
-
-	totalAssets, err := poolCaller.TotalAssets(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
-	if err != nil {
-		return nil, height, err
-	}
-
-	totalBorrowed, err := poolCaller.TotalBorrowed(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+	totalAssets, err := chain.PoolTotalAssets(ctx, blockNumber)
 	if err != nil {
 		return nil, height, err
 	}
 
-	agentFactory := sdk.Query().AgentFactory()
-
-	agentFactoryCaller, err := abigen.NewAgentFactoryCaller(agentFactory, ethClient)
+	totalBorrowed, err := chain.PoolTotalBorrowed(ctx, blockNumber)
 	if err != nil {
 		return nil, height, err
 	}
 
-	agentCount, err := agentFactoryCaller.AgentCount(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+	agentCount, err := chain.AgentCount(ctx, blockNumber)
 	if err != nil {
 		return nil, height, err
 	}
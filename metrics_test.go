@@ -2,56 +2,165 @@ package invariants
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"math/big"
+	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 
+	"github.com/glifio/invariants/chaintest"
+	"github.com/glifio/invariants/eventstest"
 	"github.com/glifio/invariants/singleton"
 	"github.com/stretchr/testify/assert"
 )
 
-var eventsURL string
+var connectOnce sync.Once
 
-func init() {
-	eventsURL = os.Getenv("EVENTS_API")
-}
+// connectLiveNode connects to the node and API from the environment, skipping the
+// test if they aren't set
+func connectLiveNode(t *testing.T) string {
+	eventsURL := os.Getenv("EVENTS_API")
+	if eventsURL == "" || os.Getenv("LOTUS_PRIVATE_ADDR") == "" || os.Getenv("CHAIN_ID") == "" {
+		t.Skip("EVENTS_API, LOTUS_PRIVATE_ADDR and CHAIN_ID aren't set")
+	}
+
+	chainID, err := strconv.ParseInt(os.Getenv("CHAIN_ID"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func init() {
-	chainID, err := strconv.Atoi(os.Getenv("CHAIN_ID"))
+	connectOnce.Do(func() {
+		err = singleton.InitPoolsSDK(
+			context.Background(),
+			chainID,
+			os.Getenv("LOTUS_PRIVATE_ADDR"),
+			os.Getenv("LOTUS_PRIVATE_TOKEN"),
+		)
+		if err != nil {
+			return
+		}
+		err = singleton.ConnectLotus(singleton.ChainOptions{
+			DialAddr: os.Getenv("LOTUS_PRIVATE_ADDR"),
+			Token:    os.Getenv("LOTUS_PRIVATE_TOKEN"),
+		})
+	})
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 
-	singleton.InitPoolsSDK(
-		context.Background(),
-		int64(chainID),
-		os.Getenv("LOTUS_PRIVATE_ADDR"),
-		os.Getenv("LOTUS_PRIVATE_TOKEN"),
-	)
+	return eventsURL
 }
 
 // TestMetrics calls the REST API, and compares against on-chain
 func TestMetrics(t *testing.T) {
+	eventsURL := connectLiveNode(t)
 	ctx := context.Background()
 
 	metricsFromAPI, err := GetMetricsFromAPI(ctx, eventsURL)
 	assert.Nil(t, err)
 
-	fmt.Printf("Jim rest %+v\n", metricsFromAPI)
-
 	if metricsFromAPI.Height == 0 {
 		t.Fatal("Height is zero")
 	}
 
 	height := metricsFromAPI.Height
-	metricsFromNode, err := GetMetricsFromNode(ctx, height)
+	metricsFromNode, _, err := GetMetricsFromNode(ctx, height)
 	assert.Nil(t, err)
 
-	fmt.Printf("Jim chain %+v\n", metricsFromNode)
-
 	assert.Equal(t, metricsFromAPI.PoolTotalAssets, metricsFromNode.PoolTotalAssets, "Total assets should be equal")
 	assert.Equal(t, metricsFromAPI.PoolTotalBorrowed, metricsFromNode.PoolTotalBorrowed, "Total borrowed should be equal")
 	assert.Equal(t, metricsFromAPI.TotalAgentCount, metricsFromNode.TotalAgentCount, "Agent count should be equal")
 }
+
+func TestGetMetricsFromAPI(t *testing.T) {
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	metrics, err := GetMetricsFromAPI(ctx, server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4300200), metrics.Height)
+	assert.Equal(t, uint64(2), metrics.TotalAgentCount)
+
+	// Between fixtures, the values of the previous height are served
+	metrics, err = GetMetricsFromAPIAtHeight(ctx, server.URL, 4300150)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4300100), metrics.Height)
+	assert.Equal(t, 0, metrics.PoolTotalBorrowed.Cmp(bigFIL(10000)))
+
+	_, err = GetMetricsFromAPIAtHeight(ctx, server.URL, 4299999)
+	assert.NotNil(t, err)
+
+	server.SetField("/metrics/*", "", nil, "poolTotalAssets", "1")
+	metrics, err = GetMetricsFromAPIAtHeight(ctx, server.URL, 4300100)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, metrics.PoolTotalAssets.Cmp(big.NewInt(1)))
+
+	server.Fail("/metrics", http.StatusServiceUnavailable)
+	_, err = GetMetricsFromAPI(ctx, server.URL)
+	assert.NotNil(t, err)
+}
+
+func TestGetIFILTotalSupplyFromAPI(t *testing.T) {
+	server := eventstest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	supply, err := GetIFILTotalSupplyFromAPI(ctx, server.URL, 4300200)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4300150), supply.Height)
+	assert.Equal(t, 0, supply.IFILTotalSupply.Cmp(bigFIL(38500)))
+}
+
+func TestGetMetricsFromNode(t *testing.T) {
+	chain := chaintest.New(4300300)
+	chain.Use(t)
+	chain.SetPoolTotalAssets(chaintest.Constant(bigFIL(40000)))
+	chain.SetPoolTotalBorrowed(chaintest.Step(big.NewInt(0), 4300101, bigFIL(10000)))
+	chain.SetAgentCount(func(epoch uint64) *big.Int {
+		// One agent created at 4300100 and another at 4300200
+		return big.NewInt(int64((epoch - 4300001) / 100))
+	})
+	ctx := context.Background()
+
+	server := eventstest.NewServer()
+	defer server.Close()
+
+	for _, height := range []uint64{4300000, 4300100, 4300200} {
+		metricsFromAPI, err := GetMetricsFromAPIAtHeight(ctx, server.URL, height)
+		assert.Nil(t, err)
+		metricsFromNode, nodeHeight, err := GetMetricsFromNode(ctx, height)
+		assert.Nil(t, err)
+		assert.Equal(t, height+1, nodeHeight)
+		assert.Equal(t, 0, metricsFromAPI.PoolTotalAssets.Cmp(metricsFromNode.PoolTotalAssets), "@%d", height)
+		assert.Equal(t, 0, metricsFromAPI.PoolTotalBorrowed.Cmp(metricsFromNode.PoolTotalBorrowed), "@%d", height)
+		assert.Equal(t, metricsFromAPI.TotalAgentCount, metricsFromNode.TotalAgentCount, "@%d", height)
+	}
+
+	chain.Fail("PoolTotalBorrowed", errors.New("connection refused"))
+	_, _, err := GetMetricsFromNode(ctx, 4300200)
+	assert.NotNil(t, err)
+}
+
+func TestGetIFILTotalSupplyFromNode(t *testing.T) {
+	chain := chaintest.New(4300300)
+	chain.Use(t)
+	chain.NullRound(4300151, 4300152)
+	chain.SetIFILSupply(chaintest.Step(bigFIL(40000), 4300151, bigFIL(38500)))
+	ctx := context.Background()
+
+	supply, height, err := GetIFILTotalSupplyFromNode(ctx, 4300150)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4300153), height)
+	assert.Equal(t, 0, supply.IFILTotalSupply.Cmp(bigFIL(38500)))
+
+	supply, _, err = GetIFILTotalSupplyFromNode(ctx, 4300149)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, supply.IFILTotalSupply.Cmp(bigFIL(40000)))
+}
+
+func bigFIL(fil int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(fil), big.NewInt(1e18))
+}
@@ -0,0 +1,64 @@
+package invariants
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/glifio/invariants/singleton"
+)
+
+type MinerOwnershipResult struct {
+	Height       uint64
+	Owner        address.Address
+	PendingOwner *address.Address
+	Beneficiary  address.Address
+}
+
+// GetMinerOwnershipFromNode calls the node to get the owner of a miner
+func GetMinerOwnershipFromNode(ctx context.Context, miner address.Address, height uint64) (*MinerOwnershipResult, uint64, error) {
+	chain := singleton.Chain()
+
+	ts, err := chain.ChainGetTipSetAfterHeight(ctx, abi.ChainEpoch(height+1))
+	if err != nil {
+		return nil, height, err
+	}
+	height = uint64(ts.Height())
+
+	info, err := chain.StateMinerInfo(ctx, miner, ts.Key())
+	if err != nil {
+		return nil, height, err
+	}
+
+	result := MinerOwnershipResult{
+		Height:       height,
+		Owner:        info.Owner,
+		PendingOwner: info.PendingOwnerAddress,
+		Beneficiary:  info.Beneficiary,
+	}
+
+	return &result, height, nil
+}
+
+// GetAgentActorIDFromNode calls the node to resolve the ID address of an agent actor
+func GetAgentActorIDFromNode(ctx context.Context, agent *Agent, height uint64) (address.Address, uint64, error) {
+	chain := singleton.Chain()
+
+	ts, err := chain.ChainGetTipSetAfterHeight(ctx, abi.ChainEpoch(height+1))
+	if err != nil {
+		return address.Undef, height, err
+	}
+	height = uint64(ts.Height())
+
+	agentAddr, err := DelegatedFromEthAddress(agent.AddressNative)
+	if err != nil {
+		return address.Undef, height, err
+	}
+
+	actorID, err := chain.StateLookupID(ctx, agentAddr, ts.Key())
+	if err != nil {
+		return address.Undef, height, err
+	}
+
+	return actorID, height, nil
+}
@@ -0,0 +1,123 @@
+package invariants
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/glifio/invariants/singleton"
+)
+
+type AgentOwnershipResult struct {
+	Height          uint64
+	ID              uint64
+	FactoryID       uint64
+	Owner           common.Address
+	Operator        common.Address
+	PendingOwner    common.Address
+	PendingOperator common.Address
+	ActorID         address.Address
+	ActorIDNative   address.Address
+}
+
+// GetAgentOwnershipFromNode calls the node to get the owner, operator and IDs for an agent
+func GetAgentOwnershipFromNode(ctx context.Context, agent *Agent, height uint64) (*AgentOwnershipResult, uint64, error) {
+	chain := singleton.Chain()
+
+	ts, err := chain.ChainGetTipSetAfterHeight(ctx, abi.ChainEpoch(height+1))
+	if err != nil {
+		return nil, height, err
+	}
+	height = uint64(ts.Height())
+
+	agentAddr, err := ParseFilecoinAddress(agent.Address)
+	if err != nil {
+		return nil, height, err
+	}
+
+	actorID, err := chain.StateLookupID(ctx, agentAddr, ts.Key())
+	if err != nil {
+		return nil, height, err
+	}
+
+	agentAddrNative, err := DelegatedFromEthAddress(agent.AddressNative)
+	if err != nil {
+		return nil, height, err
+	}
+
+	actorIDNative, err := chain.StateLookupID(ctx, agentAddrNative, ts.Key())
+	if err != nil {
+		return nil, height, err
+	}
+
+	blockNumber := big.NewInt(int64(height))
+
+	roles, err := chain.AgentRoles(ctx, agent.AddressNative, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	factoryID, err := chain.AgentFactoryID(ctx, agent.AddressNative, blockNumber)
+	if err != nil {
+		return nil, height, err
+	}
+
+	result := AgentOwnershipResult{
+		Height:          height,
+		ID:              roles.ID.Uint64(),
+		FactoryID:       factoryID.Uint64(),
+		Owner:           roles.Owner,
+		Operator:        roles.Operator,
+		PendingOwner:    roles.PendingOwner,
+		PendingOperator: roles.PendingOperator,
+		ActorID:         actorID,
+		ActorIDNative:   actorIDNative,
+	}
+
+	return &result, height, nil
+}
+
+// ParseFilecoinAddress parses a Filecoin address, accepting 0x-prefixed Ethereum addresses as well
+func ParseFilecoinAddress(s string) (address.Address, error) {
+	if strings.HasPrefix(s, "0x") {
+		ethAddr, err := ethtypes.ParseEthAddress(s)
+		if err != nil {
+			return address.Undef, err
+		}
+		return ethAddr.ToFilecoinAddress()
+	}
+	addr, err := address.NewFromString(s)
+	if err != nil {
+		return address.Undef, fmt.Errorf("invalid address %q: %v", s, err)
+	}
+	return addr, nil
+}
+
+// ParseEthAddress parses an Ethereum address, accepting f410 delegated addresses as well
+func ParseEthAddress(s string) (common.Address, error) {
+	if strings.HasPrefix(s, "0x") {
+		if !common.IsHexAddress(s) {
+			return common.Address{}, fmt.Errorf("invalid address %q", s)
+		}
+		return common.HexToAddress(s), nil
+	}
+	addr, err := address.NewFromString(s)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid address %q: %v", s, err)
+	}
+	ethAddr, err := ethtypes.EthAddressFromFilecoinAddress(addr)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return common.Address(ethAddr), nil
+}
+
+// DelegatedFromEthAddress converts an Ethereum address to an f410 delegated address
+func DelegatedFromEthAddress(addr common.Address) (address.Address, error) {
+	return ethtypes.EthAddress(addr).ToFilecoinAddress()
+}
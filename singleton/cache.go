@@ -0,0 +1,143 @@
+package singleton
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FinalityEpochs is the number of epochs after which a tipset can't be reorged
+const FinalityEpochs = 900
+
+// NodeCache persists node query results for epochs older than finality, so
+// historical queries don't need to go back to the node when rerun
+type NodeCache struct {
+	db        *bolt.DB
+	bucket    []byte
+	finalized atomic.Uint64
+}
+
+var nodeCache *NodeCache
+
+// OpenCache opens (or creates) the on-disk cache at path. Results are kept in a
+// separate bucket per chain ID.
+func OpenCache(path string, chainID int64) error {
+	if nodeCache != nil {
+		log.Fatal("Node cache already initialized")
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open cache %s: %v", path, err)
+	}
+
+	bucket := []byte(fmt.Sprintf("chain-%d", chainID))
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	nodeCache = &NodeCache{db: db, bucket: bucket}
+	return nil
+}
+
+// Cache returns the node cache, or nil if it isn't enabled
+func Cache() *NodeCache {
+	return nodeCache
+}
+
+// SetFinalizedHeight sets the highest epoch whose results may be cached
+func (c *NodeCache) SetFinalizedHeight(height uint64) {
+	if c != nil {
+		c.finalized.Store(height)
+	}
+}
+
+// IsFinalized reports whether results at height may be cached
+func (c *NodeCache) IsFinalized(height uint64) bool {
+	return c != nil && height <= c.finalized.Load()
+}
+
+// Get looks up a cached result and decodes it into v
+func (c *NodeCache) Get(method string, height uint64, args []any, v any) bool {
+	if !c.IsFinalized(height) {
+		return false
+	}
+	var data []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		data = tx.Bucket(c.bucket).Get(cacheKey(method, height, args))
+		if data != nil {
+			data = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	if data == nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// Put stores a result, as long as height is older than finality
+func (c *NodeCache) Put(method string, height uint64, args []any, v any) error {
+	if !c.IsFinalized(height) {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(c.bucket).Put(cacheKey(method, height, args), data)
+	})
+}
+
+func (c *NodeCache) Close() {
+	if c != nil {
+		c.db.Close()
+	}
+}
+
+func cacheKey(method string, height uint64, args []any) []byte {
+	parts := []string{method, fmt.Sprint(height)}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+	return []byte(strings.Join(parts, "/"))
+}
+
+// Cached returns the cached result of method at height for args if there is one,
+// otherwise it calls fetch and caches the result if height is older than finality
+func Cached[T any](method string, height uint64, args []any, fetch func() (T, error)) (T, error) {
+	var result T
+	if nodeCache.Get(method, height, args, &result) {
+		return result, nil
+	}
+
+	result, err := fetch()
+	if err != nil {
+		return result, err
+	}
+
+	err = nodeCache.Put(method, height, args, result)
+	if err != nil {
+		log.Printf("failed to cache %s @%d: %v\n", method, height, err)
+	}
+
+	return result, nil
+}
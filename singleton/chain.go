@@ -0,0 +1,407 @@
+package singleton
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/go-pools/constants"
+	"github.com/glifio/go-pools/vc"
+	"github.com/ipfs/go-cid"
+)
+
+// ChainBackend is the chain and pool state the invariants are checked against.
+// By default it's the Lotus node and go-pools SDK; tests replace it with an
+// in-memory chain from the chaintest package.
+type ChainBackend interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	// ChainGetTipSetByHeight returns the tipset at height, or the one before it if
+	// height is a null round
+	ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error)
+	// ChainGetTipSetAfterHeight returns the tipset at height, or the one after it if
+	// height is a null round
+	ChainGetTipSetAfterHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error)
+	ChainGetMessagesInTipset(ctx context.Context, tsk types.TipSetKey) ([]lotusapi.Message, error)
+	// ChainGetParentReceipts returns the receipts of the messages in the parent of
+	// the tipset tsk, in the order ChainGetMessagesInTipset returns the messages
+	ChainGetParentReceipts(ctx context.Context, tsk types.TipSetKey) ([]*types.MessageReceipt, error)
+
+	// EthGetMessageCidByTransactionHash returns the message of an eth transaction,
+	// or nil if it isn't known
+	EthGetMessageCidByTransactionHash(ctx context.Context, txHash common.Hash) (*cid.Cid, error)
+	// EthGetTransactionHashByCid returns the eth transaction hash of a message, or
+	// nil if it isn't known
+	EthGetTransactionHashByCid(ctx context.Context, msg cid.Cid) (*common.Hash, error)
+	// ContractLogs returns the events emitted by contracts in the blocks from start
+	// to end
+	ContractLogs(ctx context.Context, contracts []common.Address, start uint64, end uint64) ([]gethtypes.Log, error)
+
+	// State reads see the parent state of the tipset tsk
+	StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
+	StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error)
+	StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error)
+
+	AgentLiquidAssets(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error)
+	AgentPrincipal(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error)
+	AgentRoles(ctx context.Context, agent common.Address, blockNumber *big.Int) (*AgentRoles, error)
+	// AgentFactoryID returns the ID the agent factory registered agent with
+	AgentFactoryID(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error)
+	AgentStatus(ctx context.Context, agent common.Address, agentID uint64, blockNumber *big.Int) (*AgentStatus, error)
+	// AgentAccount returns the Infinity Pool account of agent
+	AgentAccount(ctx context.Context, agent common.Address, blockNumber *big.Int) (abigen.Account, error)
+	// AgentLiquidationValue returns what terminating every sector of the miners of
+	// agent would recover at ts
+	AgentLiquidationValue(ctx context.Context, agent common.Address, ts *types.TipSet) (*big.Int, error)
+	WFILBalance(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	IFILSupply(ctx context.Context, blockNumber *big.Int) (*big.Int, error)
+	PoolTotalAssets(ctx context.Context, blockNumber *big.Int) (*big.Int, error)
+	PoolTotalBorrowed(ctx context.Context, blockNumber *big.Int) (*big.Int, error)
+	// PoolRate returns the Infinity Pool per epoch rate, with two WADs of precision
+	PoolRate(ctx context.Context, blockNumber *big.Int) (*big.Int, error)
+	// PoolWriteOffs returns the Infinity Pool write offs of every agent logged from
+	// start to end, at most a day of epochs apart
+	PoolWriteOffs(ctx context.Context, start uint64, end uint64) ([]*abigen.InfinityPoolWriteOff, error)
+	AgentCount(ctx context.Context, blockNumber *big.Int) (*big.Int, error)
+
+	InfinityPoolAddress() common.Address
+	WFILAddress() common.Address
+}
+
+// AgentRoles are the ID and the owner and operator roles of an agent contract
+type AgentRoles struct {
+	ID              *big.Int
+	Owner           common.Address
+	Operator        common.Address
+	PendingOwner    common.Address
+	PendingOperator common.Address
+}
+
+// AgentStatus is the default state of an agent, from the agent contract and the
+// agent police
+type AgentStatus struct {
+	Defaulted      bool
+	Administration common.Address
+	Liquidated     bool
+	MaxDTE         *big.Int
+}
+
+var chainBackend ChainBackend
+
+// Chain returns the backend set with UseChain, or the Lotus node and go-pools SDK
+func Chain() ChainBackend {
+	if chainBackend != nil {
+		return chainBackend
+	}
+	return nodeChain{}
+}
+
+// UseChain makes chain the backend returned by Chain(), or restores the Lotus node
+// if chain is nil. It must not be called while queries are running.
+func UseChain(chain ChainBackend) {
+	chainBackend = chain
+}
+
+// nodeChain reads from the Lotus node returned by Lotus() and the PoolsSDK
+type nodeChain struct{}
+
+func (nodeChain) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return Lotus().Api.ChainHead(ctx)
+}
+
+func (nodeChain) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error) {
+	return Lotus().Api.ChainGetTipSetByHeight(ctx, height, types.EmptyTSK)
+}
+
+func (nodeChain) ChainGetTipSetAfterHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error) {
+	return Lotus().Api.ChainGetTipSetAfterHeight(ctx, height, types.EmptyTSK)
+}
+
+func (nodeChain) ChainGetMessagesInTipset(ctx context.Context, tsk types.TipSetKey) ([]lotusapi.Message, error) {
+	return Lotus().Api.ChainGetMessagesInTipset(ctx, tsk)
+}
+
+func (nodeChain) ChainGetParentReceipts(ctx context.Context, tsk types.TipSetKey) ([]*types.MessageReceipt, error) {
+	ts, err := Lotus().Api.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return Lotus().Api.ChainGetParentReceipts(ctx, ts.Cids()[0])
+}
+
+func (nodeChain) EthGetMessageCidByTransactionHash(ctx context.Context, txHash common.Hash) (*cid.Cid, error) {
+	ethHash := ethtypes.EthHash(txHash)
+	return Lotus().Api.EthGetMessageCidByTransactionHash(ctx, &ethHash)
+}
+
+func (nodeChain) EthGetTransactionHashByCid(ctx context.Context, msg cid.Cid) (*common.Hash, error) {
+	ethHash, err := Lotus().Api.EthGetTransactionHashByCid(ctx, msg)
+	if err != nil || ethHash == nil {
+		return nil, err
+	}
+	txHash := common.Hash(*ethHash)
+	return &txHash, nil
+}
+
+func (nodeChain) ContractLogs(ctx context.Context, contracts []common.Address, start uint64, end uint64) ([]gethtypes.Log, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	return ethClient.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(start),
+		ToBlock:   new(big.Int).SetUint64(max(start, end)),
+		Addresses: contracts,
+	})
+}
+
+func (nodeChain) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return Lotus().Api.StateLookupID(ctx, addr, tsk)
+}
+
+func (nodeChain) StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error) {
+	return Lotus().Api.StateMinerInfo(ctx, miner, tsk)
+}
+
+func (nodeChain) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return Lotus().Api.StateGetActor(ctx, addr, tsk)
+}
+
+func (nodeChain) AgentLiquidAssets(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return PoolsSDK.Query().AgentLiquidAssets(ctx, agent, blockNumber)
+}
+
+func (nodeChain) AgentPrincipal(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return PoolsSDK.Query().AgentPrincipal(ctx, agent, blockNumber)
+}
+
+func (nodeChain) AgentRoles(ctx context.Context, agent common.Address, blockNumber *big.Int) (*AgentRoles, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	return CallAgentRoles(ethClient, agent, &bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (nodeChain) AgentFactoryID(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	caller, err := abigen.NewAgentFactoryCaller(PoolsSDK.Query().AgentFactory(), ethClient)
+	if err != nil {
+		return nil, err
+	}
+	return caller.Agents(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber}, agent)
+}
+
+func (nodeChain) AgentStatus(ctx context.Context, agent common.Address, agentID uint64, blockNumber *big.Int) (*AgentStatus, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: blockNumber}
+	return CallAgentStatus(ethClient, PoolsSDK.Query().AgentPolice(), agent, agentID, opts)
+}
+
+func (nodeChain) AgentAccount(ctx context.Context, agent common.Address, blockNumber *big.Int) (abigen.Account, error) {
+	return PoolsSDK.Query().AgentAccount(ctx, agent, constants.INFINITY_POOL_ID, blockNumber)
+}
+
+func (nodeChain) AgentLiquidationValue(ctx context.Context, agent common.Address, ts *types.TipSet) (*big.Int, error) {
+	summary, err := PoolsSDK.Query().AgentPreviewTerminationPrecise(ctx, agent, ts)
+	if err != nil {
+		return nil, err
+	}
+	return summary.LiquidationValue(), nil
+}
+
+func (nodeChain) WFILBalance(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	caller, err := abigen.NewWFILCaller(PoolsSDK.Query().WFIL(), ethClient)
+	if err != nil {
+		return nil, err
+	}
+	return caller.BalanceOf(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber}, account)
+}
+
+func (nodeChain) IFILSupply(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	return PoolsSDK.Query().IFILSupply(ctx, blockNumber)
+}
+
+func (nodeChain) PoolTotalAssets(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	return callInfinityPool(ctx, blockNumber, func(caller *abigen.InfinityPoolCaller, opts *bind.CallOpts) (*big.Int, error) {
+		return caller.TotalAssets(opts)
+	})
+}
+
+func (nodeChain) PoolTotalBorrowed(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	return callInfinityPool(ctx, blockNumber, func(caller *abigen.InfinityPoolCaller, opts *bind.CallOpts) (*big.Int, error) {
+		return caller.TotalBorrowed(opts)
+	})
+}
+
+func (nodeChain) PoolRate(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	nullishVC, err := vc.NullishVerifiableCredential(*vc.EmptyAgentData())
+	if err != nil {
+		return nil, err
+	}
+	return callInfinityPool(ctx, blockNumber, func(caller *abigen.InfinityPoolCaller, opts *bind.CallOpts) (*big.Int, error) {
+		return caller.GetRate(opts, *nullishVC)
+	})
+}
+
+func (nodeChain) PoolWriteOffs(ctx context.Context, start uint64, end uint64) ([]*abigen.InfinityPoolWriteOff, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	filterer, err := abigen.NewInfinityPoolFilterer(PoolsSDK.Query().InfinityPool(), ethClient)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := filterer.FilterWriteOff(&bind.FilterOpts{Context: ctx, Start: start, End: &end}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	writeOffs := make([]*abigen.InfinityPoolWriteOff, 0)
+	for iter.Next() {
+		writeOffs = append(writeOffs, iter.Event)
+	}
+	return writeOffs, iter.Error()
+}
+
+func (nodeChain) AgentCount(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	caller, err := abigen.NewAgentFactoryCaller(PoolsSDK.Query().AgentFactory(), ethClient)
+	if err != nil {
+		return nil, err
+	}
+	return caller.AgentCount(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (nodeChain) InfinityPoolAddress() common.Address {
+	return PoolsSDK.Query().InfinityPool()
+}
+
+func (nodeChain) WFILAddress() common.Address {
+	return PoolsSDK.Query().WFIL()
+}
+
+// CallAgentRoles reads the roles of agent through caller, which is a node's eth
+// client or a backend that runs the calls itself
+func CallAgentRoles(caller bind.ContractCaller, agent common.Address, opts *bind.CallOpts) (*AgentRoles, error) {
+	agentCaller, err := abigen.NewAgentCaller(agent, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles AgentRoles
+	roles.ID, err = agentCaller.Id(opts)
+	if err != nil {
+		return nil, err
+	}
+	roles.Owner, err = agentCaller.Owner(opts)
+	if err != nil {
+		return nil, err
+	}
+	roles.Operator, err = agentCaller.Operator(opts)
+	if err != nil {
+		return nil, err
+	}
+	roles.PendingOwner, err = agentCaller.PendingOwner(opts)
+	if err != nil {
+		return nil, err
+	}
+	roles.PendingOperator, err = agentCaller.PendingOperator(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &roles, nil
+}
+
+// CallAgentStatus reads the default state of agent from the agent contract and
+// the agent police at police, through caller
+func CallAgentStatus(
+	caller bind.ContractCaller,
+	police common.Address,
+	agent common.Address,
+	agentID uint64,
+	opts *bind.CallOpts,
+) (*AgentStatus, error) {
+	agentCaller, err := abigen.NewAgentCaller(agent, caller)
+	if err != nil {
+		return nil, err
+	}
+	policeCaller, err := abigen.NewAgentPoliceCaller(police, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	var status AgentStatus
+	status.Defaulted, err = agentCaller.Defaulted(opts)
+	if err != nil {
+		return nil, err
+	}
+	status.Administration, err = agentCaller.Administration(opts)
+	if err != nil {
+		return nil, err
+	}
+	status.Liquidated, err = policeCaller.AgentLiquidated(opts, new(big.Int).SetUint64(agentID))
+	if err != nil {
+		return nil, err
+	}
+	status.MaxDTE, err = policeCaller.MaxDTE(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func callInfinityPool(
+	ctx context.Context,
+	blockNumber *big.Int,
+	call func(caller *abigen.InfinityPoolCaller, opts *bind.CallOpts) (*big.Int, error),
+) (*big.Int, error) {
+	ethClient, err := PoolsSDK.Extern().ConnectEthClient()
+	if err != nil {
+		return nil, err
+	}
+	defer ethClient.Close()
+
+	caller, err := abigen.NewInfinityPoolCaller(PoolsSDK.Query().InfinityPool(), ethClient)
+	if err != nil {
+		return nil, err
+	}
+	return call(caller, &bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
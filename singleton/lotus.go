@@ -35,40 +35,7 @@ func ConnectLotus(opts ChainOptions) error {
 
 	lotusAPIOnce.Do(func() {
 		// log.Printf("new lotus client: %s\n", opts.DialAddr)
-		lotusClient = &LotusNode{}
-		head := http.Header{}
-
-		if opts.Token != "" {
-			head.Set("Authorization", "Bearer "+opts.Token)
-		}
-
-		closer, err := jsonrpc.NewMergeClient(
-			context.Background(),
-			opts.DialAddr,
-			"Filecoin",
-			lotusapi.GetInternalStructs(&lotusClient.Api),
-			head,
-		)
-
-		if err != nil {
-			connectionErr = err
-		}
-
-		chainId, err := lotusClient.Api.EthChainId(context.Background())
-		if err != nil {
-			// default to mainnet
-			chainId = 314
-		}
-		// log.Printf("connected to chain id: %v\n", chainId)
-
-		if chainId != 314 {
-			err = build.UseNetworkBundle("calibrationnet")
-			log.Printf("use network bundle: %v\n", "calibrationnet")
-			if err != nil {
-				log.Fatalf("use network bundle error: %v\n", err)
-			}
-		}
-		lotusClient.Closer = closer
+		lotusClient, connectionErr = NewLotusNode(opts)
 	})
 
 	return connectionErr
@@ -83,43 +50,50 @@ func ConnectArchiveLotus(opts ChainOptions) error {
 
 	lotusArchiveAPIOnce.Do(func() {
 		// log.Printf("new lotus archive client: %s\n", opts.DialAddr)
-		lotusClient = &LotusNode{}
-		head := http.Header{}
+		lotusClient, connectionErr = NewLotusNode(opts)
+	})
 
-		if opts.Token != "" {
-			head.Set("Authorization", "Bearer "+opts.Token)
-		}
+	return connectionErr
+}
 
-		closer, err := jsonrpc.NewMergeClient(
-			context.Background(),
-			opts.DialAddr,
-			"Filecoin",
-			lotusapi.GetInternalStructs(&lotusClient.Api),
-			head,
-		)
+// NewLotusNode connects to a Lotus node without making it the node returned by Lotus()
+func NewLotusNode(opts ChainOptions) (*LotusNode, error) {
+	node := &LotusNode{}
+	head := http.Header{}
 
-		if err != nil {
-			connectionErr = err
-		}
+	if opts.Token != "" {
+		head.Set("Authorization", "Bearer "+opts.Token)
+	}
+
+	closer, err := jsonrpc.NewMergeClient(
+		context.Background(),
+		opts.DialAddr,
+		"Filecoin",
+		lotusapi.GetInternalStructs(&node.Api),
+		head,
+		jsonrpc.WithHTTPClient(&http.Client{Transport: http.DefaultTransport}),
+	)
+	node.Closer = closer
+	if err != nil {
+		return node, err
+	}
 
-		chainId, err := lotusClient.Api.EthChainId(context.Background())
+	chainId, err := node.Api.EthChainId(context.Background())
+	if err != nil {
+		// default to mainnet
+		chainId = 314
+	}
+	// log.Printf("connected to chain id: %v\n", chainId)
+
+	if chainId != 314 {
+		err = build.UseNetworkBundle("calibrationnet")
+		log.Printf("use network bundle: %v\n", "calibrationnet")
 		if err != nil {
-			// default to mainnet
-			chainId = 314
-		}
-		// log.Printf("connected to chain id: %v\n", chainId)
-
-		if chainId != 314 {
-			err = build.UseNetworkBundle("calibrationnet")
-			log.Printf("use network bundle: %v\n", "calibrationnet")
-			if err != nil {
-				log.Fatalf("use network bundle error: %v\n", err)
-			}
+			log.Fatalf("use network bundle error: %v\n", err)
 		}
-		lotusClient.Closer = closer
-	})
+	}
 
-	return connectionErr
+	return node, nil
 }
 
 func Lotus() *LotusNode {
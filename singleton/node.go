@@ -0,0 +1,49 @@
+package singleton
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/glifio/go-pools/sdk"
+	"github.com/glifio/go-pools/types"
+)
+
+// Node is a Lotus connection along with a go-pools SDK that queries through it,
+// used when more than one node is needed in the same run
+type Node struct {
+	Name     string
+	Lotus    *LotusNode
+	PoolsSDK types.PoolsSDK
+}
+
+// NewNode connects to a Lotus node and creates a go-pools SDK for it, without
+// making it the node returned by Lotus() and PoolsSDK
+func NewNode(ctx context.Context, name string, chainID int64, opts ChainOptions) (*Node, error) {
+	lotus, err := NewLotusNode(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	poolsSDK, err := sdk.New(ctx, big.NewInt(chainID), types.Extern{
+		AdoAddr:       "https://ado.glif.link/rpc/v0",
+		LotusDialAddr: opts.DialAddr,
+		LotusToken:    opts.Token,
+	})
+	if err != nil {
+		lotus.Close()
+		return nil, err
+	}
+
+	return &Node{Name: name, Lotus: lotus, PoolsSDK: poolsSDK}, nil
+}
+
+// UseNode makes node the one returned by Lotus() and PoolsSDK, so the existing
+// node queries run against it. It must not be called while queries are running.
+func UseNode(node *Node) {
+	lotusClient = node.Lotus
+	PoolsSDK = node.PoolsSDK
+}
+
+func (node *Node) Close() {
+	node.Lotus.Close()
+}
@@ -0,0 +1,37 @@
+package singleton
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedTransport limits the rate of requests made to a set of hosts
+type RateLimitedTransport struct {
+	Base    http.RoundTripper
+	Hosts   map[string]bool
+	Limiter *rate.Limiter
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Hosts[req.URL.Host] {
+		if err := t.Limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.Base.RoundTrip(req)
+}
+
+// LimitRequestRate limits the requests per second made to the given hosts by the
+// default HTTP transport, which is used by both the Lotus and Ethereum clients
+func LimitRequestRate(rps float64, hosts ...string) {
+	hostMap := make(map[string]bool)
+	for _, host := range hosts {
+		hostMap[host] = true
+	}
+	http.DefaultTransport = &RateLimitedTransport{
+		Base:    http.DefaultTransport,
+		Hosts:   hostMap,
+		Limiter: rate.NewLimiter(rate.Limit(rps), 1),
+	}
+}
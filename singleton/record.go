@@ -0,0 +1,312 @@
+package singleton
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CaptureFile is the file in a capture directory holding the recorded exchanges
+const CaptureFile = "exchanges.jsonl"
+
+// Exchange is one recorded HTTP request and its response
+type Exchange struct {
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	RequestBody  string `json:"requestBody,omitempty"`
+	Status       int    `json:"status"`
+	ContentType  string `json:"contentType,omitempty"`
+	ResponseBody string `json:"responseBody"`
+}
+
+// RecordingTransport writes every request made through it, along with the
+// response, to a capture file. Headers other than the content type aren't
+// recorded, so tokens don't end up in the capture.
+type RecordingTransport struct {
+	Base http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	res, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	line, err := json.Marshal(Exchange{
+		Method:       req.Method,
+		URL:          req.URL.String(),
+		RequestBody:  string(reqBody),
+		Status:       res.StatusCode,
+		ContentType:  res.Header.Get("Content-Type"),
+		ResponseBody: string(resBody),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err = t.file.Write(append(line, '\n'))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record %s %s: %v", req.Method, req.URL, err)
+	}
+	return res, nil
+}
+
+func (t *RecordingTransport) Close() error {
+	return t.file.Close()
+}
+
+// NewRecordingTransport creates a capture in dir, recording the requests made
+// through base
+func NewRecordingTransport(dir string, base http.RoundTripper) (*RecordingTransport, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(dir, CaptureFile))
+	if err != nil {
+		return nil, err
+	}
+	return &RecordingTransport{Base: base, file: file}, nil
+}
+
+// RecordTraffic records every request made by the default HTTP transport, which
+// is used by the events API, Lotus and Ethereum clients, to a capture in dir
+func RecordTraffic(dir string) (*RecordingTransport, error) {
+	t, err := NewRecordingTransport(dir, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	http.DefaultTransport = t
+	return t, nil
+}
+
+// ReplayTransport answers requests from a capture instead of the network.
+// Requests are matched on method, URL and body, ignoring JSON-RPC ids. When the
+// same request was recorded more than once, the responses are replayed in order
+// and the last one is repeated.
+type ReplayTransport struct {
+	mu        sync.Mutex
+	exchanges map[string][]Exchange
+	next      map[string]int
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key := exchangeKey(req.Method, req.URL.String(), reqBody)
+
+	t.mu.Lock()
+	exchanges := t.exchanges[key]
+	if len(exchanges) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("replay: %s %s%s wasn't recorded", req.Method, req.URL, rpcMethods(reqBody))
+	}
+	i := t.next[key]
+	if i < len(exchanges)-1 {
+		t.next[key]++
+	}
+	exchange := exchanges[i]
+	t.mu.Unlock()
+
+	resBody := replaceRPCIDs([]byte(exchange.ResponseBody), []byte(exchange.RequestBody), reqBody)
+	header := http.Header{}
+	if exchange.ContentType != "" {
+		header.Set("Content-Type", exchange.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(resBody)),
+		ContentLength: int64(len(resBody)),
+		Request:       req,
+	}, nil
+}
+
+// NewReplayTransport loads the capture in dir
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	file, err := os.Open(filepath.Join(dir, CaptureFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	t := &ReplayTransport{
+		exchanges: make(map[string][]Exchange),
+		next:      make(map[string]int),
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 256*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var exchange Exchange
+		err := json.Unmarshal(scanner.Bytes(), &exchange)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", CaptureFile, line, err)
+		}
+		key := exchangeKey(exchange.Method, exchange.URL, []byte(exchange.RequestBody))
+		t.exchanges[key] = append(t.exchanges[key], exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ReplayTraffic makes the default HTTP transport answer from the capture in dir,
+// so nothing is sent over the network
+func ReplayTraffic(dir string) error {
+	t, err := NewReplayTransport(dir)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = t
+	return nil
+}
+
+// exchangeKey identifies a request regardless of its JSON-RPC ids, which depend
+// on how many requests the client made before it
+func exchangeKey(method string, url string, body []byte) string {
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		switch v := v.(type) {
+		case map[string]any:
+			delete(v, "id")
+		case []any:
+			for _, elem := range v {
+				if obj, ok := elem.(map[string]any); ok {
+					delete(obj, "id")
+				}
+			}
+		}
+		// Marshalling sorts the keys, so the field order doesn't matter either
+		normalized, err := json.Marshal(v)
+		if err == nil {
+			body = normalized
+		}
+	}
+	return method + " " + url + " " + string(body)
+}
+
+// replaceRPCIDs replaces the JSON-RPC ids of the recorded request in a recorded
+// response with the ids of the replayed request
+func replaceRPCIDs(resBody []byte, recordedReq []byte, req []byte) []byte {
+	recordedIDs := rpcIDs(recordedReq)
+	ids := rpcIDs(req)
+	if len(recordedIDs) == 0 || len(recordedIDs) != len(ids) {
+		return resBody
+	}
+	idMap := make(map[string]json.RawMessage)
+	for i, id := range recordedIDs {
+		idMap[string(id)] = ids[i]
+	}
+
+	replace := func(obj map[string]json.RawMessage) {
+		if id, ok := idMap[string(obj["id"])]; ok {
+			obj["id"] = id
+		}
+	}
+
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(resBody, &obj) == nil {
+		replace(obj)
+		if out, err := json.Marshal(obj); err == nil {
+			return out
+		}
+		return resBody
+	}
+	var batch []map[string]json.RawMessage
+	if json.Unmarshal(resBody, &batch) == nil {
+		for _, obj := range batch {
+			replace(obj)
+		}
+		if out, err := json.Marshal(batch); err == nil {
+			return out
+		}
+	}
+	return resBody
+}
+
+// rpcIDs returns the ids of a JSON-RPC request or batch, in order
+func rpcIDs(body []byte) []json.RawMessage {
+	var obj struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(body, &obj) == nil {
+		if obj.ID == nil {
+			return nil
+		}
+		return []json.RawMessage{obj.ID}
+	}
+	var batch []struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(body, &batch) == nil {
+		ids := make([]json.RawMessage, len(batch))
+		for i, elem := range batch {
+			ids[i] = elem.ID
+		}
+		return ids
+	}
+	return nil
+}
+
+// rpcMethods describes the JSON-RPC methods called by a request, for errors
+func rpcMethods(body []byte) string {
+	var obj struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &obj) == nil && obj.Method != "" {
+		return " (" + obj.Method + ")"
+	}
+	var batch []struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &batch) == nil && len(batch) > 0 {
+		methods := make([]string, len(batch))
+		for i, elem := range batch {
+			methods[i] = elem.Method
+		}
+		return " (" + strings.Join(methods, ", ") + ")"
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package singleton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNode answers JSON-RPC requests with the number of requests it has served
+func fakeNode() *httptest.Server {
+	var served atomic.Int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"%s %d"}`, req.ID, req.Method, served.Add(1))
+	}))
+}
+
+func call(t *testing.T, client *http.Client, url string, id int, method string) (string, string) {
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":[]}`, id, method)
+	res, err := client.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var response struct {
+		ID     json.RawMessage `json:"id"`
+		Result string          `json:"result"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(response.ID), response.Result
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	server := fakeNode()
+
+	recorder, err := NewRecordingTransport(dir, http.DefaultTransport)
+	assert.Nil(t, err)
+	client := &http.Client{Transport: recorder}
+	call(t, client, server.URL, 1, "ChainHead")
+	call(t, client, server.URL, 2, "ChainHead")
+	call(t, client, server.URL, 3, "EthChainId")
+	assert.Nil(t, recorder.Close())
+	server.Close()
+
+	replayer, err := NewReplayTransport(dir)
+	assert.Nil(t, err)
+	client = &http.Client{Transport: replayer}
+
+	// The ids are the replayed request's, and repeated requests get the responses
+	// in the order they were recorded, then the last one again
+	id, result := call(t, client, server.URL, 7, "EthChainId")
+	assert.Equal(t, "7", id)
+	assert.Equal(t, "EthChainId 3", result)
+	_, result = call(t, client, server.URL, 8, "ChainHead")
+	assert.Equal(t, "ChainHead 1", result)
+	_, result = call(t, client, server.URL, 9, "ChainHead")
+	assert.Equal(t, "ChainHead 2", result)
+	_, result = call(t, client, server.URL, 10, "ChainHead")
+	assert.Equal(t, "ChainHead 2", result)
+
+	_, err = client.Post(server.URL, "application/json",
+		strings.NewReader(`{"jsonrpc":"2.0","id":11,"method":"StateGetActor","params":[]}`))
+	assert.ErrorContains(t, err, "(StateGetActor) wasn't recorded")
+
+	res, err := client.Get(server.URL + "/agent")
+	assert.ErrorContains(t, err, "wasn't recorded")
+	if res != nil {
+		io.Copy(io.Discard, res.Body)
+	}
+}
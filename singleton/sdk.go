@@ -2,7 +2,7 @@ package singleton
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"math/big"
 	"sync"
 
@@ -19,7 +19,9 @@ func InitPoolsSDK(
 	chainID int64,
 	dialAddr string,
 	token string,
-) {
+) error {
+	var connectionErr error
+
 	initSDKOnce.Do(func() {
 		sdk, err := sdk.New(ctx, big.NewInt(chainID), types.Extern{
 			AdoAddr:       "https://ado.glif.link/rpc/v0",
@@ -27,8 +29,11 @@ func InitPoolsSDK(
 			LotusToken:    token,
 		})
 		if err != nil {
-			log.Fatal("node connection error: ", err)
+			connectionErr = fmt.Errorf("node connection error: %v", err)
+			return
 		}
 		PoolsSDK = sdk
 	})
+
+	return connectionErr
 }
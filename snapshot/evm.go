@@ -0,0 +1,541 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	evm14 "github.com/filecoin-project/go-state-types/builtin/v14/evm"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrNativeCall is returned for contract calls that reach a Filecoin precompile
+// or a built-in actor, which run in the FVM rather than the EVM
+var ErrNativeCall = errors.New("snapshot: call to a Filecoin precompile or built-in actor")
+
+// callGasLimit is the gas of the calls, which is the Filecoin block gas limit.
+// The EVM charges Ethereum gas, which only matters to contracts that read it.
+const callGasLimit = 10_000_000_000
+
+// evmChainConfig returns the rules of the Filecoin EVM, which are Shanghai's
+func evmChainConfig(chainID *big.Int) *params.ChainConfig {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = chainID
+	config.ShanghaiTime = new(uint64)
+	return &config
+}
+
+// CodeAt returns the bytecode of contract in the state produced by the tipset at
+// blockNumber
+func (c *Chain) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	_, tree, err := c.blockState(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	statedb := newEVMState(ctx, c, tree)
+	code := statedb.GetCode(contract)
+	return code, statedb.err
+}
+
+// CallContract runs call in the state produced by the tipset at blockNumber, as
+// eth_call does. The changes the call makes to the state are discarded.
+func (c *Chain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if call.To == nil {
+		return nil, errors.New("snapshot: contract creation calls aren't supported")
+	}
+	ts, tree, err := c.blockState(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	statedb := newEVMState(ctx, c, tree)
+	guard := &callGuard{state: statedb}
+	blockCtx := vm.BlockContext{
+		CanTransfer: func(db vm.StateDB, addr common.Address, amount *big.Int) bool {
+			return db.GetBalance(addr).Cmp(amount) >= 0
+		},
+		Transfer: func(db vm.StateDB, from common.Address, to common.Address, amount *big.Int) {
+			db.SubBalance(from, amount)
+			db.AddBalance(to, amount)
+		},
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		GasLimit:    callGasLimit,
+		BlockNumber: big.NewInt(int64(ts.Height())),
+		Time:        ts.MinTimestamp(),
+		Difficulty:  big.NewInt(0),
+		BaseFee:     ts.Blocks()[0].ParentBaseFee.Int,
+		Random:      &common.Hash{},
+	}
+	txCtx := vm.TxContext{Origin: call.From, GasPrice: big.NewInt(0)}
+	evm := vm.NewEVM(blockCtx, txCtx, statedb, c.evmConfig, vm.Config{Tracer: guard, NoBaseFee: true})
+
+	gas := call.Gas
+	if gas == 0 {
+		gas = callGasLimit
+	}
+	value := call.Value
+	if value == nil {
+		value = big.NewInt(0)
+	}
+	ret, _, err := evm.Call(vm.AccountRef(call.From), *call.To, call.Data, gas, value)
+	if guard.err != nil {
+		return nil, guard.err
+	}
+	if statedb.err != nil {
+		return nil, statedb.err
+	}
+	if errors.Is(err, vm.ErrExecutionReverted) {
+		if reason, err := abi.UnpackRevert(ret); err == nil {
+			return nil, fmt.Errorf("%v: %v", vm.ErrExecutionReverted, reason)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// blockState returns the tipset at blockNumber, and the state it produced, which
+// is the parent state of the next tipset. Without a block number, that's the
+// state of the head.
+func (c *Chain) blockState(ctx context.Context, blockNumber *big.Int) (*types.TipSet, *state.StateTree, error) {
+	var height filabi.ChainEpoch
+	if blockNumber == nil {
+		if len(c.heights) < 2 {
+			return nil, nil, errors.New("snapshot has no state produced by a tipset")
+		}
+		height = c.heights[len(c.heights)-2]
+	} else {
+		height = filabi.ChainEpoch(blockNumber.Int64())
+	}
+
+	ts, err := c.ChainGetTipSetByHeight(ctx, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ts.Height() != height {
+		return nil, nil, fmt.Errorf("requested epoch %d was a null round", height)
+	}
+	execTs, err := c.ChainGetTipSetAfterHeight(ctx, height+1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("state produced @%d: %v", height, err)
+	}
+	tree, err := c.stateTree(execTs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ts, tree, nil
+}
+
+// callGuard fails the calls the EVM would run differently from the FVM: calls to
+// the Filecoin precompiles and to built-in actors. The tracer can't fail the call
+// itself, so it cancels the EVM and keeps the error.
+type callGuard struct {
+	state *evmState
+	evm   *vm.EVM
+	err   error
+}
+
+func (g *callGuard) check(to common.Address) {
+	if g.err != nil {
+		return
+	}
+	filecoinPrecompile := to[0] == 0xfe && bytes.Equal(to[1:19], make([]byte, 18))
+	if filecoinPrecompile || g.state.account(to).native {
+		g.err = fmt.Errorf("%w: %v", ErrNativeCall, to)
+		g.evm.Cancel()
+	}
+}
+
+func (g *callGuard) CaptureTxStart(gasLimit uint64) {}
+
+func (g *callGuard) CaptureTxEnd(restGas uint64) {}
+
+func (g *callGuard) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	g.evm = env
+	g.check(to)
+}
+
+func (g *callGuard) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+
+func (g *callGuard) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	g.check(to)
+}
+
+func (g *callGuard) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (g *callGuard) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (g *callGuard) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// evmAccount is an actor as the EVM sees it
+type evmAccount struct {
+	exists   bool
+	native   bool
+	balance  *big.Int
+	nonce    uint64
+	code     []byte
+	codeHash common.Hash
+	// storageRoot is the root of the storage KAMT of an EVM actor, or undefined
+	storageRoot cid.Cid
+	committed   map[common.Hash]common.Hash
+	dirty       map[common.Hash]common.Hash
+	suicided    bool
+}
+
+// evmState implements vm.StateDB over a state tree. Actors are read as the calls
+// reach them, and the changes kept in memory, with a journal to revert them. The
+// first error reading the state tree is kept in err, as vm.StateDB has no errors.
+type evmState struct {
+	ctx      context.Context
+	chain    *Chain
+	tree     *state.StateTree
+	accounts map[common.Address]*evmAccount
+	// byID has the accounts of the actors, which both their delegated address and
+	// their masked ID address reach
+	byID        map[address.Address]*evmAccount
+	transient   map[common.Address]map[common.Hash]common.Hash
+	accessAddrs map[common.Address]bool
+	accessSlots map[common.Address]map[common.Hash]bool
+	refund      uint64
+	journal     []func()
+	err         error
+}
+
+var _ vm.StateDB = (*evmState)(nil)
+
+func newEVMState(ctx context.Context, chain *Chain, tree *state.StateTree) *evmState {
+	return &evmState{
+		ctx:         ctx,
+		chain:       chain,
+		tree:        tree,
+		accounts:    make(map[common.Address]*evmAccount),
+		byID:        make(map[address.Address]*evmAccount),
+		transient:   make(map[common.Address]map[common.Hash]common.Hash),
+		accessAddrs: make(map[common.Address]bool),
+		accessSlots: make(map[common.Address]map[common.Hash]bool),
+	}
+}
+
+func (s *evmState) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *evmState) account(addr common.Address) *evmAccount {
+	acc, ok := s.accounts[addr]
+	if !ok {
+		acc = s.loadAccount(addr)
+		s.accounts[addr] = acc
+	}
+	return acc
+}
+
+// loadAccount reads the actor at addr, which is a masked ID or a delegated
+// address. Built-in actors other than accounts get the code 0xfe, as in the FVM.
+func (s *evmState) loadAccount(addr common.Address) *evmAccount {
+	acc := &evmAccount{
+		balance:   new(big.Int),
+		committed: make(map[common.Hash]common.Hash),
+		dirty:     make(map[common.Hash]common.Hash),
+	}
+
+	filAddr, err := ethtypes.EthAddress(addr).ToFilecoinAddress()
+	if err != nil {
+		s.fail(err)
+		return acc
+	}
+	idAddr, err := s.tree.LookupIDAddress(filAddr)
+	if errors.Is(err, types.ErrActorNotFound) {
+		return acc
+	}
+	if err != nil {
+		s.fail(err)
+		return acc
+	}
+	if other, ok := s.byID[idAddr]; ok {
+		return other
+	}
+	act, err := s.tree.GetActor(idAddr)
+	if errors.Is(err, types.ErrActorNotFound) {
+		return acc
+	}
+	if err != nil {
+		s.fail(err)
+		return acc
+	}
+	s.byID[idAddr] = acc
+
+	acc.exists = true
+	acc.balance.Set(act.Balance.Int)
+	acc.nonce = act.Nonce
+	switch {
+	case builtin.IsEvmActor(act.Code):
+		// The EVM state has the same layout in every actors version
+		var head evm14.State
+		err := s.chain.store.Get(s.ctx, act.Head, &head)
+		if err != nil {
+			s.fail(fmt.Errorf("EVM state of %v: %v", idAddr, err))
+			return acc
+		}
+		acc.nonce = head.Nonce
+		if head.Tombstone == nil {
+			var bytecode filabi.CborBytesTransparent
+			err := s.chain.store.Get(s.ctx, head.Bytecode, &bytecode)
+			if err != nil {
+				s.fail(fmt.Errorf("bytecode of %v: %v", idAddr, err))
+				return acc
+			}
+			acc.code = bytecode
+			acc.codeHash = head.BytecodeHash
+			acc.storageRoot = head.ContractState
+		}
+	case builtin.IsAccountActor(act.Code), builtin.IsEthAccountActor(act.Code), builtin.IsPlaceholderActor(act.Code):
+	default:
+		acc.native = true
+		acc.code = []byte{byte(vm.INVALID)}
+		acc.codeHash = crypto.Keccak256Hash(acc.code)
+	}
+	if len(acc.code) == 0 {
+		acc.codeHash = gethtypes.EmptyCodeHash
+	}
+	return acc
+}
+
+func (s *evmState) CreateAccount(addr common.Address) {
+	acc := s.account(addr)
+	prev := *acc
+	s.journal = append(s.journal, func() { *acc = prev })
+
+	*acc = evmAccount{
+		exists:    true,
+		balance:   acc.balance,
+		codeHash:  gethtypes.EmptyCodeHash,
+		committed: make(map[common.Hash]common.Hash),
+		dirty:     make(map[common.Hash]common.Hash),
+	}
+}
+
+func (s *evmState) setBalance(addr common.Address, balance *big.Int) {
+	acc := s.account(addr)
+	prevBalance, prevExists := acc.balance, acc.exists
+	s.journal = append(s.journal, func() { acc.balance, acc.exists = prevBalance, prevExists })
+
+	acc.balance = balance
+	acc.exists = true
+}
+
+func (s *evmState) SubBalance(addr common.Address, amount *big.Int) {
+	s.setBalance(addr, new(big.Int).Sub(s.account(addr).balance, amount))
+}
+
+func (s *evmState) AddBalance(addr common.Address, amount *big.Int) {
+	s.setBalance(addr, new(big.Int).Add(s.account(addr).balance, amount))
+}
+
+func (s *evmState) GetBalance(addr common.Address) *big.Int {
+	return new(big.Int).Set(s.account(addr).balance)
+}
+
+func (s *evmState) GetNonce(addr common.Address) uint64 {
+	return s.account(addr).nonce
+}
+
+func (s *evmState) SetNonce(addr common.Address, nonce uint64) {
+	acc := s.account(addr)
+	prev := acc.nonce
+	s.journal = append(s.journal, func() { acc.nonce = prev })
+	acc.nonce = nonce
+}
+
+func (s *evmState) GetCodeHash(addr common.Address) common.Hash {
+	acc := s.account(addr)
+	if !acc.exists {
+		return common.Hash{}
+	}
+	return acc.codeHash
+}
+
+func (s *evmState) GetCode(addr common.Address) []byte {
+	return s.account(addr).code
+}
+
+func (s *evmState) SetCode(addr common.Address, code []byte) {
+	acc := s.account(addr)
+	prevCode, prevHash := acc.code, acc.codeHash
+	s.journal = append(s.journal, func() { acc.code, acc.codeHash = prevCode, prevHash })
+	acc.code = code
+	acc.codeHash = crypto.Keccak256Hash(code)
+}
+
+func (s *evmState) GetCodeSize(addr common.Address) int {
+	return len(s.account(addr).code)
+}
+
+func (s *evmState) AddRefund(gas uint64) {
+	prev := s.refund
+	s.journal = append(s.journal, func() { s.refund = prev })
+	s.refund += gas
+}
+
+func (s *evmState) SubRefund(gas uint64) {
+	prev := s.refund
+	s.journal = append(s.journal, func() { s.refund = prev })
+	s.refund -= gas
+}
+
+func (s *evmState) GetRefund() uint64 {
+	return s.refund
+}
+
+// GetCommittedState reads a storage slot from the state tree
+func (s *evmState) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	acc := s.account(addr)
+	value, ok := acc.committed[key]
+	if ok || !acc.storageRoot.Defined() {
+		return value
+	}
+	value, err := readStorage(s.ctx, s.chain.blocks, acc.storageRoot, key)
+	if err != nil {
+		s.fail(fmt.Errorf("storage of %v: %v", addr, err))
+	}
+	acc.committed[key] = value
+	return value
+}
+
+func (s *evmState) GetState(addr common.Address, key common.Hash) common.Hash {
+	if value, ok := s.account(addr).dirty[key]; ok {
+		return value
+	}
+	return s.GetCommittedState(addr, key)
+}
+
+func (s *evmState) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	acc := s.account(addr)
+	prev, ok := acc.dirty[key]
+	s.journal = append(s.journal, func() {
+		if ok {
+			acc.dirty[key] = prev
+		} else {
+			delete(acc.dirty, key)
+		}
+	})
+	acc.dirty[key] = value
+}
+
+func (s *evmState) GetTransientState(addr common.Address, key common.Hash) common.Hash {
+	return s.transient[addr][key]
+}
+
+func (s *evmState) SetTransientState(addr common.Address, key common.Hash, value common.Hash) {
+	if s.transient[addr] == nil {
+		s.transient[addr] = make(map[common.Hash]common.Hash)
+	}
+	prev := s.transient[addr][key]
+	s.journal = append(s.journal, func() { s.transient[addr][key] = prev })
+	s.transient[addr][key] = value
+}
+
+func (s *evmState) Suicide(addr common.Address) bool {
+	acc := s.account(addr)
+	if !acc.exists {
+		return false
+	}
+	prevSuicided, prevBalance := acc.suicided, acc.balance
+	s.journal = append(s.journal, func() { acc.suicided, acc.balance = prevSuicided, prevBalance })
+	acc.suicided = true
+	acc.balance = new(big.Int)
+	return true
+}
+
+func (s *evmState) HasSuicided(addr common.Address) bool {
+	return s.account(addr).suicided
+}
+
+func (s *evmState) Exist(addr common.Address) bool {
+	acc := s.account(addr)
+	return acc.exists || acc.suicided
+}
+
+func (s *evmState) Empty(addr common.Address) bool {
+	acc := s.account(addr)
+	return !acc.exists || (acc.balance.Sign() == 0 && acc.nonce == 0 && len(acc.code) == 0)
+}
+
+func (s *evmState) AddressInAccessList(addr common.Address) bool {
+	return s.accessAddrs[addr]
+}
+
+func (s *evmState) SlotInAccessList(addr common.Address, slot common.Hash) (addressOk bool, slotOk bool) {
+	return s.accessAddrs[addr], s.accessSlots[addr][slot]
+}
+
+func (s *evmState) AddAddressToAccessList(addr common.Address) {
+	if s.accessAddrs[addr] {
+		return
+	}
+	s.journal = append(s.journal, func() { delete(s.accessAddrs, addr) })
+	s.accessAddrs[addr] = true
+}
+
+func (s *evmState) AddSlotToAccessList(addr common.Address, slot common.Hash) {
+	s.AddAddressToAccessList(addr)
+	if s.accessSlots[addr] == nil {
+		s.accessSlots[addr] = make(map[common.Hash]bool)
+	}
+	if s.accessSlots[addr][slot] {
+		return
+	}
+	s.journal = append(s.journal, func() { delete(s.accessSlots[addr], slot) })
+	s.accessSlots[addr][slot] = true
+}
+
+func (s *evmState) Prepare(rules params.Rules, sender, coinbase common.Address, dest *common.Address, precompiles []common.Address, txAccesses gethtypes.AccessList) {
+	s.AddAddressToAccessList(sender)
+	if dest != nil {
+		s.AddAddressToAccessList(*dest)
+	}
+	for _, addr := range precompiles {
+		s.AddAddressToAccessList(addr)
+	}
+	for _, access := range txAccesses {
+		s.AddAddressToAccessList(access.Address)
+		for _, slot := range access.StorageKeys {
+			s.AddSlotToAccessList(access.Address, slot)
+		}
+	}
+}
+
+func (s *evmState) RevertToSnapshot(id int) {
+	for i := len(s.journal) - 1; i >= id; i-- {
+		s.journal[i]()
+	}
+	s.journal = s.journal[:id]
+}
+
+func (s *evmState) Snapshot() int {
+	return len(s.journal)
+}
+
+// AddLog discards the logs, as the calls don't return them
+func (s *evmState) AddLog(*gethtypes.Log) {}
+
+func (s *evmState) AddPreimage(common.Hash, []byte) {}
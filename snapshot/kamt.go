@@ -0,0 +1,257 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// kamtBitWidth is the bit width of the KAMT the EVM actor keeps its storage in.
+// The keys are the 32-byte big-endian slots, used as their own hash.
+const kamtBitWidth = 5
+
+// kamtNode is a node of the EVM storage KAMT. Each bit set in the bitfield has a
+// pointer, at the index of the bits set below it.
+type kamtNode struct {
+	bitfield *big.Int
+	pointers []kamtPointer
+}
+
+// kamtPointer is either the key-value pairs of a bucket, or a link to a child node
+// that skips the next skip bits of the key, which all its keys share
+type kamtPointer struct {
+	values [][2][]byte
+	link   cid.Cid
+	skip   int
+}
+
+// readStorage returns the value of slot in the EVM storage KAMT at root, or zero
+// if it isn't set
+func readStorage(ctx context.Context, blocks blockGetter, root cid.Cid, slot common.Hash) (common.Hash, error) {
+	link := root
+	pos := 0
+	for {
+		node, err := loadKAMTNode(ctx, blocks, link)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("storage node %v: %v", link, err)
+		}
+
+		if pos+kamtBitWidth > 8*len(slot) {
+			return common.Hash{}, fmt.Errorf("storage node %v is deeper than the key", link)
+		}
+		idx := keyBits(slot[:], pos, kamtBitWidth)
+		pos += kamtBitWidth
+		if node.bitfield.Bit(idx) == 0 {
+			return common.Hash{}, nil
+		}
+		i := 0
+		for j := 0; j < idx; j++ {
+			i += int(node.bitfield.Bit(j))
+		}
+		if i >= len(node.pointers) {
+			return common.Hash{}, fmt.Errorf("storage node %v has %d pointers, and a bit set for %d", link, len(node.pointers), i)
+		}
+
+		ptr := node.pointers[i]
+		if ptr.values == nil {
+			link = ptr.link
+			pos += ptr.skip
+			continue
+		}
+		// The extensions skipped on the way aren't matched, so the whole key is compared
+		for _, kv := range ptr.values {
+			if common.BytesToHash(kv[0]) == slot {
+				return common.BytesToHash(kv[1]), nil
+			}
+		}
+		return common.Hash{}, nil
+	}
+}
+
+// keyBits returns the n bits of key from bit pos, the most significant first
+func keyBits(key []byte, pos int, n int) int {
+	out := 0
+	for i := pos; i < pos+n; i++ {
+		out <<= 1
+		if key[i/8]&(0x80>>(i%8)) != 0 {
+			out |= 1
+		}
+	}
+	return out
+}
+
+// loadKAMTNode decodes the node at link, which is the tuple of the bitfield, as
+// big-endian bytes, and the pointers. A pointer is a list of key-value pairs, a
+// link, or a link and the extension it skips.
+func loadKAMTNode(ctx context.Context, blocks blockGetter, link cid.Cid) (*kamtNode, error) {
+	block, err := blocks.Get(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	cr := cbg.NewCborReader(bytes.NewReader(block.RawData()))
+
+	n, err := readArrayHeader(cr)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, fmt.Errorf("node has %d fields, expected 2", n)
+	}
+	bitfield, err := readByteString(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err = readArrayHeader(cr)
+	if err != nil {
+		return nil, err
+	}
+	node := &kamtNode{bitfield: new(big.Int).SetBytes(bitfield)}
+	for i := 0; i < n; i++ {
+		ptr, err := readKAMTPointer(cr)
+		if err != nil {
+			return nil, fmt.Errorf("pointer %d: %v", i, err)
+		}
+		node.pointers = append(node.pointers, ptr)
+	}
+	return node, nil
+}
+
+func readKAMTPointer(cr *cbg.CborReader) (kamtPointer, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return kamtPointer{}, err
+	}
+	switch maj {
+	case cbg.MajTag:
+		link, err := readTaggedCid(cr, extra)
+		return kamtPointer{link: link}, err
+
+	case cbg.MajArray:
+		if extra == 0 {
+			return kamtPointer{}, fmt.Errorf("empty pointer")
+		}
+		maj, tag, err := cr.ReadHeader()
+		if err != nil {
+			return kamtPointer{}, err
+		}
+		if maj == cbg.MajTag {
+			// A link and its extension
+			link, err := readTaggedCid(cr, tag)
+			if err != nil {
+				return kamtPointer{}, err
+			}
+			skip, err := readExtension(cr)
+			return kamtPointer{link: link, skip: skip}, err
+		}
+
+		// The key-value pairs, the header of the first one being read
+		values := make([][2][]byte, 0, extra)
+		for i := 0; i < int(extra); i++ {
+			if i > 0 {
+				maj, tag, err = cr.ReadHeader()
+				if err != nil {
+					return kamtPointer{}, err
+				}
+			}
+			if maj != cbg.MajArray || tag != 2 {
+				return kamtPointer{}, fmt.Errorf("key-value pair isn't a tuple")
+			}
+			key, err := readByteString(cr)
+			if err != nil {
+				return kamtPointer{}, err
+			}
+			value, err := readByteString(cr)
+			if err != nil {
+				return kamtPointer{}, err
+			}
+			values = append(values, [2][]byte{key, value})
+		}
+		return kamtPointer{values: values}, nil
+
+	default:
+		return kamtPointer{}, fmt.Errorf("unexpected major type %d", maj)
+	}
+}
+
+// readExtension returns the number of key bits an extension skips. It's the tuple
+// of what it consumed and the path of the key bits, packed in bytes. Consumed is
+// counted in bits, or in levels of the tree by older encoders, which the length
+// of the path tells apart.
+func readExtension(cr *cbg.CborReader) (int, error) {
+	n, err := readArrayHeader(cr)
+	if err != nil {
+		return 0, err
+	}
+	if n != 2 {
+		return 0, fmt.Errorf("extension has %d fields, expected 2", n)
+	}
+	maj, consumed, err := cr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return 0, fmt.Errorf("extension length isn't an integer")
+	}
+	path, err := readByteString(cr)
+	if err != nil {
+		return 0, err
+	}
+
+	bitsLen := int(consumed)
+	if consumed%kamtBitWidth != 0 || len(path) != (bitsLen+7)/8 {
+		bitsLen = int(consumed) * kamtBitWidth
+	}
+	if len(path) != (bitsLen+7)/8 {
+		return 0, fmt.Errorf("extension path of %d bytes doesn't match length %d", len(path), consumed)
+	}
+	return bitsLen, nil
+}
+
+func readArrayHeader(cr *cbg.CborReader) (int, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	if maj != cbg.MajArray {
+		return 0, fmt.Errorf("expected an array, got major type %d", maj)
+	}
+	return int(extra), nil
+}
+
+func readByteString(cr *cbg.CborReader) ([]byte, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	if maj != cbg.MajByteString {
+		return nil, fmt.Errorf("expected bytes, got major type %d", maj)
+	}
+	if extra > cbg.ByteArrayMaxLen {
+		return nil, fmt.Errorf("byte string too long: %d", extra)
+	}
+	buf := make([]byte, extra)
+	_, err = io.ReadFull(cr, buf)
+	return buf, err
+}
+
+// readTaggedCid reads a CID whose tag header has been read
+func readTaggedCid(cr *cbg.CborReader, tag uint64) (cid.Cid, error) {
+	if tag != 42 {
+		return cid.Undef, fmt.Errorf("unexpected tag %d", tag)
+	}
+	buf, err := readByteString(cr)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(buf) == 0 || buf[0] != 0 {
+		return cid.Undef, fmt.Errorf("invalid CID bytes")
+	}
+	return cid.Cast(buf[1:])
+}
@@ -0,0 +1,775 @@
+// Package snapshot reads chain state from a Filecoin CAR snapshot instead of a
+// Lotus node, for historical audits that shouldn't load the archive node.
+//
+// The snapshot answers the chain queries for every tipset whose header it
+// contains, and the state and contract queries for the tipsets whose state it
+// has, as exported with --recent-stateroots. Contract calls run in an EVM over
+// the snapshot's state tree, as eth_call runs them on a node (see evm.go).
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/filecoin-project/go-address"
+	amt4 "github.com/filecoin-project/go-amt-ipld/v4"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filecoin-project/specs-actors/actors/util/adt"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/go-pools/constants"
+	"github.com/glifio/go-pools/deploy"
+	poolstypes "github.com/glifio/go-pools/types"
+	"github.com/glifio/go-pools/vc"
+	"github.com/glifio/invariants/singleton"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/libp2p/go-libp2p/core/peer"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ErrNeedsNode is returned for the queries a snapshot can't answer
+var ErrNeedsNode = errors.New("snapshot: query needs a Lotus node")
+
+type blockGetter interface {
+	Get(ctx context.Context, blockCid cid.Cid) (blocks.Block, error)
+}
+
+// Chain implements singleton.ChainBackend from the blocks of a CAR snapshot. The
+// blocks are read from the file through an index of their offsets, so the
+// snapshot isn't held in memory, but the tipset headers are.
+type Chain struct {
+	blocks    blockGetter
+	store     cbor.IpldStore
+	contracts poolstypes.ProtocolMeta
+	evmConfig *params.ChainConfig
+	tipsets   map[abi.ChainEpoch]*types.TipSet
+	heights   []abi.ChainEpoch
+	close     func() error
+
+	mu sync.Mutex
+	// messages of the eth transaction hashes returned in logs
+	txMessages map[common.Hash]cid.Cid
+}
+
+var _ singleton.ChainBackend = (*Chain)(nil)
+var _ bind.ContractCaller = (*Chain)(nil)
+
+// Open loads the CAR snapshot at path of the chain chainID, whose contracts are
+// the ones GLIF deployed on it
+func Open(path string, chainID int64) (*Chain, error) {
+	var contracts poolstypes.ProtocolMeta
+	switch chainID {
+	case constants.MainnetChainID:
+		contracts = deploy.ProtoMeta
+	case constants.CalibnetChainID:
+		contracts = deploy.TestProtoMeta
+		// The actor code CIDs differ between networks
+		err := build.UseNetworkBundle("calibrationnet")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported chain id: %d", chainID)
+	}
+
+	bs, err := carblockstore.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := bs.Roots()
+	if err != nil {
+		bs.Close()
+		return nil, err
+	}
+
+	c, err := Load(bs, roots, contracts)
+	if err != nil {
+		bs.Close()
+		return nil, err
+	}
+	c.close = bs.Close
+	return c, nil
+}
+
+// Load reads a snapshot from its blocks, and the roots that are the blocks of
+// its head tipset. The tipsets are indexed from the head back through their
+// parents, for as long as the snapshot has their headers.
+func Load(bs blockGetter, roots []cid.Cid, contracts poolstypes.ProtocolMeta) (*Chain, error) {
+	c := &Chain{
+		blocks:     bs,
+		store:      cbor.NewCborStore(readOnly{bs}),
+		contracts:  contracts,
+		evmConfig:  evmChainConfig(contracts.ChainID),
+		tipsets:    make(map[abi.ChainEpoch]*types.TipSet),
+		txMessages: make(map[common.Hash]cid.Cid),
+	}
+
+	key := types.NewTipSetKey(roots...)
+	for {
+		ts, err := c.loadTipSet(context.Background(), key)
+		if err != nil {
+			if len(c.tipsets) == 0 {
+				return nil, fmt.Errorf("snapshot head: %v", err)
+			}
+			break
+		}
+		c.tipsets[ts.Height()] = ts
+		c.heights = append(c.heights, ts.Height())
+		if ts.Height() == 0 {
+			break
+		}
+		key = ts.Parents()
+	}
+	sort.Slice(c.heights, func(i, j int) bool { return c.heights[i] < c.heights[j] })
+
+	return c, nil
+}
+
+// Close closes the snapshot file
+func (c *Chain) Close() error {
+	if c.close == nil {
+		return nil
+	}
+	return c.close()
+}
+
+// Range returns the heights of the first and last tipsets in the snapshot
+func (c *Chain) Range() (from abi.ChainEpoch, to abi.ChainEpoch) {
+	return c.heights[0], c.heights[len(c.heights)-1]
+}
+
+func (c *Chain) loadTipSet(ctx context.Context, key types.TipSetKey) (*types.TipSet, error) {
+	var headers []*types.BlockHeader
+	for _, blockCid := range key.Cids() {
+		block, err := c.blocks.Get(ctx, blockCid)
+		if err != nil {
+			return nil, fmt.Errorf("block %v isn't in the snapshot", blockCid)
+		}
+		header, err := types.DecodeBlock(block.RawData())
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+	return types.NewTipSet(headers)
+}
+
+// stateTree loads the parent state of ts, which is the state the tipset before it
+// produced
+func (c *Chain) stateTree(ts *types.TipSet) (*state.StateTree, error) {
+	tree, err := state.LoadStateTree(c.store, ts.ParentState())
+	if err != nil {
+		return nil, fmt.Errorf("state @%d isn't in the snapshot: %v", ts.Height(), err)
+	}
+	return tree, nil
+}
+
+func (c *Chain) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return c.tipsets[c.heights[len(c.heights)-1]], nil
+}
+
+func (c *Chain) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error) {
+	from, to := c.Range()
+	if height > to {
+		return nil, fmt.Errorf("looking for tipset with height greater than snapshot head: %d > %d", height, to)
+	}
+	if height < from {
+		return nil, fmt.Errorf("tipset @%d is before the snapshot, which starts @%d", height, from)
+	}
+	// The last tipset at or before height
+	i := sort.Search(len(c.heights), func(i int) bool { return c.heights[i] > height })
+	return c.tipsets[c.heights[i-1]], nil
+}
+
+func (c *Chain) ChainGetTipSetAfterHeight(ctx context.Context, height abi.ChainEpoch) (*types.TipSet, error) {
+	from, to := c.Range()
+	if height > to {
+		return nil, fmt.Errorf("looking for tipset with height greater than snapshot head: %d > %d", height, to)
+	}
+	if height < from {
+		return nil, fmt.Errorf("tipset @%d is before the snapshot, which starts @%d", height, from)
+	}
+	// The first tipset at or after height
+	i := sort.Search(len(c.heights), func(i int) bool { return c.heights[i] >= height })
+	return c.tipsets[c.heights[i]], nil
+}
+
+// ChainGetMessagesInTipset returns the messages included in the blocks of a
+// tipset, without duplicates, as Lotus does
+func (c *Chain) ChainGetMessagesInTipset(ctx context.Context, tsk types.TipSetKey) ([]lotusapi.Message, error) {
+	ts, err := c.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	if ts.Height() == 0 {
+		return nil, nil
+	}
+
+	store := adt.WrapStore(ctx, c.store)
+	seen := make(map[cid.Cid]bool)
+	var messages []lotusapi.Message
+	for _, header := range ts.Blocks() {
+		var meta types.MsgMeta
+		err := store.Get(ctx, header.Messages, &meta)
+		if err != nil {
+			return nil, fmt.Errorf("messages of block %v: %v", header.Cid(), err)
+		}
+
+		blsCids, err := readAMTCids(store, meta.BlsMessages)
+		if err != nil {
+			return nil, err
+		}
+		for _, msgCid := range blsCids {
+			var msg types.Message
+			err := store.Get(ctx, msgCid, &msg)
+			if err != nil {
+				return nil, fmt.Errorf("message %v: %v", msgCid, err)
+			}
+			if !seen[msgCid] {
+				seen[msgCid] = true
+				messages = append(messages, lotusapi.Message{Cid: msgCid, Message: &msg})
+			}
+		}
+
+		secpkCids, err := readAMTCids(store, meta.SecpkMessages)
+		if err != nil {
+			return nil, err
+		}
+		for _, msgCid := range secpkCids {
+			var msg types.SignedMessage
+			err := store.Get(ctx, msgCid, &msg)
+			if err != nil {
+				return nil, fmt.Errorf("message %v: %v", msgCid, err)
+			}
+			if !seen[msgCid] {
+				seen[msgCid] = true
+				messages = append(messages, lotusapi.Message{Cid: msgCid, Message: msg.VMMessage()})
+			}
+		}
+	}
+	return messages, nil
+}
+
+// ChainGetParentReceipts returns the receipts the blocks of tsk carry for the
+// messages of their parent tipset
+func (c *Chain) ChainGetParentReceipts(ctx context.Context, tsk types.TipSetKey) ([]*types.MessageReceipt, error) {
+	ts, err := c.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	store := adt.WrapStore(ctx, c.store)
+	array, err := adt.AsArray(store, ts.Blocks()[0].ParentMessageReceipts)
+	if err != nil {
+		return nil, fmt.Errorf("receipts @%d: %v", ts.Height(), err)
+	}
+	receipts := make([]*types.MessageReceipt, 0, array.Length())
+	var receipt types.MessageReceipt
+	err = array.ForEach(&receipt, func(i int64) error {
+		r := receipt
+		receipts = append(receipts, &r)
+		return nil
+	})
+	return receipts, err
+}
+
+// EthGetMessageCidByTransactionHash returns the message of the transaction hashes
+// in the logs returned by ContractLogs, as the snapshot has no index of the
+// others
+func (c *Chain) EthGetMessageCidByTransactionHash(ctx context.Context, txHash common.Hash) (*cid.Cid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgCid, ok := c.txMessages[txHash]
+	if !ok {
+		return nil, nil
+	}
+	return &msgCid, nil
+}
+
+// EthGetTransactionHashByCid returns the hash of the eth transaction that was
+// signed for msg, or the hash of its CID for a Filecoin message
+func (c *Chain) EthGetTransactionHashByCid(ctx context.Context, msg cid.Cid) (*common.Hash, error) {
+	block, err := c.blocks.Get(ctx, msg)
+	if err != nil {
+		return nil, nil
+	}
+
+	var txHash ethtypes.EthHash
+	smsg, err := types.DecodeSignedMessage(block.RawData())
+	if err == nil && smsg.Signature.Type == crypto.SigTypeDelegated {
+		tx, err := ethtypes.EthTransactionFromSignedFilecoinMessage(smsg)
+		if err != nil {
+			return nil, err
+		}
+		txHash, err = tx.TxHash()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		txHash, err = ethtypes.EthHashFromCid(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash := common.Hash(txHash)
+	return &hash, nil
+}
+
+// ContractLogs returns the events emitted by contracts in the messages of the
+// tipsets from start to end, or by every actor if contracts is empty. The events
+// are read from the receipts of the next tipset, so the snapshot must have them.
+func (c *Chain) ContractLogs(ctx context.Context, contracts []common.Address, start uint64, end uint64) ([]gethtypes.Log, error) {
+	wanted := make(map[common.Address]bool, len(contracts))
+	for _, addr := range contracts {
+		wanted[addr] = true
+	}
+
+	logs := make([]gethtypes.Log, 0)
+	from := sort.Search(len(c.heights), func(i int) bool { return c.heights[i] >= abi.ChainEpoch(start) })
+	for _, height := range c.heights[from:] {
+		if height > abi.ChainEpoch(max(start, end)) {
+			break
+		}
+		ts := c.tipsets[height]
+		execTs, err := c.ChainGetTipSetAfterHeight(ctx, height+1)
+		if err != nil {
+			return nil, fmt.Errorf("receipts of @%d: %v", height, err)
+		}
+		msgs, err := c.ChainGetMessagesInTipset(ctx, ts.Key())
+		if err != nil {
+			return nil, err
+		}
+		receipts, err := c.ChainGetParentReceipts(ctx, execTs.Key())
+		if err != nil {
+			return nil, err
+		}
+		if len(receipts) != len(msgs) {
+			return nil, fmt.Errorf("@%d has %d messages and %d receipts", height, len(msgs), len(receipts))
+		}
+		tree, err := c.stateTree(execTs)
+		if err != nil {
+			return nil, err
+		}
+		tsCid, err := ts.Key().Cid()
+		if err != nil {
+			return nil, err
+		}
+		blockHash, err := ethtypes.EthHashFromCid(tsCid)
+		if err != nil {
+			return nil, err
+		}
+
+		logIndex := uint(0)
+		for i, msg := range msgs {
+			if receipts[i].EventsRoot == nil {
+				continue
+			}
+			events, err := c.loadEvents(ctx, *receipts[i].EventsRoot)
+			if err != nil {
+				return nil, fmt.Errorf("events of message %v: %v", msg.Cid, err)
+			}
+
+			var txHash *common.Hash
+			for _, event := range events {
+				data, topics, ok := ethLogFromEvent(event.Entries)
+				if !ok {
+					continue
+				}
+				logIndex++
+				emitter, err := emitterAddress(tree, event.Emitter)
+				if err != nil {
+					return nil, err
+				}
+				if len(wanted) > 0 && !wanted[emitter] {
+					continue
+				}
+
+				if txHash == nil {
+					txHash, err = c.EthGetTransactionHashByCid(ctx, msg.Cid)
+					if err != nil {
+						return nil, err
+					}
+					if txHash == nil {
+						return nil, fmt.Errorf("message %v isn't in the snapshot", msg.Cid)
+					}
+					c.mu.Lock()
+					c.txMessages[*txHash] = msg.Cid
+					c.mu.Unlock()
+				}
+				logs = append(logs, gethtypes.Log{
+					Address:     emitter,
+					Topics:      topics,
+					Data:        data,
+					BlockNumber: uint64(height),
+					TxHash:      *txHash,
+					TxIndex:     uint(i),
+					BlockHash:   common.Hash(blockHash),
+					Index:       logIndex - 1,
+				})
+			}
+		}
+	}
+	return logs, nil
+}
+
+// loadEvents reads the events AMT of a receipt
+func (c *Chain) loadEvents(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+	array, err := amt4.LoadAMT(ctx, c.store, root, amt4.UseTreeBitWidth(types.EventAMTBitwidth))
+	if err != nil {
+		return nil, err
+	}
+	var events []types.Event
+	err = array.ForEach(ctx, func(i uint64, deferred *cbg.Deferred) error {
+		var event types.Event
+		err := event.UnmarshalCBOR(bytes.NewReader(deferred.Raw))
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+// ethLogFromEvent returns the data and topics of an event emitted by the EVM,
+// or false for the events of built-in actors
+func ethLogFromEvent(entries []types.EventEntry) (data []byte, topics []common.Hash, ok bool) {
+	topics = make([]common.Hash, 0, 4)
+	var found [4]bool
+	for _, entry := range entries {
+		if entry.Codec != cid.Raw {
+			return nil, nil, false
+		}
+		if len(entry.Key) == 2 && "t1" <= entry.Key && entry.Key <= "t4" {
+			idx := int(entry.Key[1] - '1')
+			if len(entry.Value) != 32 || found[idx] {
+				return nil, nil, false
+			}
+			found[idx] = true
+			for len(topics) <= idx {
+				topics = append(topics, common.Hash{})
+			}
+			topics[idx] = common.BytesToHash(entry.Value)
+		} else if entry.Key == "d" {
+			if data != nil {
+				return nil, nil, false
+			}
+			data = entry.Value
+		} else {
+			return nil, nil, false
+		}
+	}
+	for _, f := range found[:len(topics)] {
+		if !f {
+			return nil, nil, false
+		}
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, topics, true
+}
+
+// emitterAddress returns the eth address of an actor: its delegated address if it
+// has one, or its masked ID
+func emitterAddress(tree *state.StateTree, id abi.ActorID) (common.Address, error) {
+	idAddr, err := address.NewIDAddress(uint64(id))
+	if err != nil {
+		return common.Address{}, err
+	}
+	act, err := tree.GetActor(idAddr)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if act.DelegatedAddress != nil && act.DelegatedAddress.Protocol() == address.Delegated {
+		ethAddr, err := ethtypes.EthAddressFromFilecoinAddress(*act.DelegatedAddress)
+		if err == nil {
+			return common.Address(ethAddr), nil
+		}
+	}
+	ethAddr, err := ethtypes.EthAddressFromFilecoinAddress(idAddr)
+	return common.Address(ethAddr), err
+}
+
+func (c *Chain) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	ts, err := c.loadTipSet(ctx, tsk)
+	if err != nil {
+		return address.Undef, err
+	}
+	tree, err := c.stateTree(ts)
+	if err != nil {
+		return address.Undef, err
+	}
+	return tree.LookupIDAddress(addr)
+}
+
+func (c *Chain) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	ts, err := c.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.stateTree(ts)
+	if err != nil {
+		return nil, err
+	}
+	return tree.GetActor(addr)
+}
+
+// StateMinerInfo reads the info of a miner actor, as Lotus returns it
+func (c *Chain) StateMinerInfo(ctx context.Context, minerAddr address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error) {
+	act, err := c.StateGetActor(ctx, minerAddr, tsk)
+	if err != nil {
+		return lotusapi.MinerInfo{}, err
+	}
+	mas, err := miner.Load(adt.WrapStore(ctx, c.store), act)
+	if err != nil {
+		return lotusapi.MinerInfo{}, fmt.Errorf("failed to load miner actor state: %v", err)
+	}
+	info, err := mas.Info()
+	if err != nil {
+		return lotusapi.MinerInfo{}, err
+	}
+
+	var pid *peer.ID
+	if peerID, err := peer.IDFromBytes(info.PeerId); err == nil {
+		pid = &peerID
+	}
+	result := lotusapi.MinerInfo{
+		Owner:                      info.Owner,
+		Worker:                     info.Worker,
+		ControlAddresses:           info.ControlAddresses,
+		NewWorker:                  address.Undef,
+		WorkerChangeEpoch:          -1,
+		PeerId:                     pid,
+		Multiaddrs:                 info.Multiaddrs,
+		WindowPoStProofType:        info.WindowPoStProofType,
+		SectorSize:                 info.SectorSize,
+		WindowPoStPartitionSectors: info.WindowPoStPartitionSectors,
+		ConsensusFaultElapsed:      info.ConsensusFaultElapsed,
+		PendingOwnerAddress:        info.PendingOwnerAddress,
+		Beneficiary:                info.Beneficiary,
+		BeneficiaryTerm:            &info.BeneficiaryTerm,
+		PendingBeneficiaryTerm:     info.PendingBeneficiaryTerm,
+	}
+	if info.PendingWorkerKey != nil {
+		result.NewWorker = info.PendingWorkerKey.NewWorker
+		result.WorkerChangeEpoch = info.PendingWorkerKey.EffectiveAt
+	}
+	return result, nil
+}
+
+func (c *Chain) AgentLiquidAssets(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewAgentCaller(agent, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.LiquidAssets(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+// AgentPrincipal sums the principal of the agent in every pool it borrowed from
+func (c *Chain) AgentPrincipal(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: blockNumber}
+	agentID, err := c.agentID(agent, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	registryCaller, err := abigen.NewPoolRegistryCaller(c.contracts.PoolRegistry, c)
+	if err != nil {
+		return nil, err
+	}
+	poolIDs, err := registryCaller.PoolIDs(opts, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	routerCaller, err := abigen.NewRouterCaller(c.contracts.Router, c)
+	if err != nil {
+		return nil, err
+	}
+	principal := big.NewInt(0)
+	for _, poolID := range poolIDs {
+		account, err := routerCaller.GetAccount(opts, agentID, poolID)
+		if err != nil {
+			return nil, err
+		}
+		principal.Add(principal, account.Principal)
+	}
+	return principal, nil
+}
+
+func (c *Chain) AgentRoles(ctx context.Context, agent common.Address, blockNumber *big.Int) (*singleton.AgentRoles, error) {
+	return singleton.CallAgentRoles(c, agent, &bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (c *Chain) AgentFactoryID(ctx context.Context, agent common.Address, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewAgentFactoryCaller(c.contracts.AgentFactory, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.Agents(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber}, agent)
+}
+
+func (c *Chain) AgentStatus(ctx context.Context, agent common.Address, agentID uint64, blockNumber *big.Int) (*singleton.AgentStatus, error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: blockNumber}
+	return singleton.CallAgentStatus(c, c.contracts.AgentPolice, agent, agentID, opts)
+}
+
+func (c *Chain) AgentAccount(ctx context.Context, agent common.Address, blockNumber *big.Int) (abigen.Account, error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: blockNumber}
+	agentID, err := c.agentID(agent, opts)
+	if err != nil {
+		return abigen.Account{}, err
+	}
+	caller, err := abigen.NewRouterCaller(c.contracts.Router, c)
+	if err != nil {
+		return abigen.Account{}, err
+	}
+	return caller.GetAccount(opts, agentID, constants.INFINITY_POOL_ID)
+}
+
+// AgentLiquidationValue previews the termination of the agent's sectors, which
+// reads the miner actors through the node's state API
+func (c *Chain) AgentLiquidationValue(ctx context.Context, agent common.Address, ts *types.TipSet) (*big.Int, error) {
+	return nil, fmt.Errorf("%w: liquidation value of %v", ErrNeedsNode, agent)
+}
+
+func (c *Chain) WFILBalance(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewWFILCaller(c.contracts.WFIL, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.BalanceOf(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber}, account)
+}
+
+func (c *Chain) IFILSupply(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewPoolTokenCaller(c.contracts.IFIL, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.TotalSupply(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (c *Chain) PoolTotalAssets(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewInfinityPoolCaller(c.contracts.InfinityPool, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.TotalAssets(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (c *Chain) PoolTotalBorrowed(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewInfinityPoolCaller(c.contracts.InfinityPool, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.TotalBorrowed(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (c *Chain) PoolRate(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	nullishVC, err := vc.NullishVerifiableCredential(*vc.EmptyAgentData())
+	if err != nil {
+		return nil, err
+	}
+	caller, err := abigen.NewInfinityPoolCaller(c.contracts.InfinityPool, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.GetRate(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber}, *nullishVC)
+}
+
+// PoolWriteOffs parses the WriteOff events in the Infinity Pool logs
+func (c *Chain) PoolWriteOffs(ctx context.Context, start uint64, end uint64) ([]*abigen.InfinityPoolWriteOff, error) {
+	poolABI, err := abigen.InfinityPoolMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	filterer, err := abigen.NewInfinityPoolFilterer(c.contracts.InfinityPool, nil)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := c.ContractLogs(ctx, []common.Address{c.contracts.InfinityPool}, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	writeOffs := make([]*abigen.InfinityPoolWriteOff, 0)
+	for _, log := range logs {
+		if len(log.Topics) == 0 || log.Topics[0] != poolABI.Events["WriteOff"].ID {
+			continue
+		}
+		writeOff, err := filterer.ParseWriteOff(log)
+		if err != nil {
+			return nil, err
+		}
+		writeOffs = append(writeOffs, writeOff)
+	}
+	return writeOffs, nil
+}
+
+func (c *Chain) AgentCount(ctx context.Context, blockNumber *big.Int) (*big.Int, error) {
+	caller, err := abigen.NewAgentFactoryCaller(c.contracts.AgentFactory, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.AgentCount(&bind.CallOpts{Context: ctx, BlockNumber: blockNumber})
+}
+
+func (c *Chain) InfinityPoolAddress() common.Address {
+	return c.contracts.InfinityPool
+}
+
+func (c *Chain) WFILAddress() common.Address {
+	return c.contracts.WFIL
+}
+
+func (c *Chain) agentID(agent common.Address, opts *bind.CallOpts) (*big.Int, error) {
+	caller, err := abigen.NewAgentCaller(agent, c)
+	if err != nil {
+		return nil, err
+	}
+	return caller.Id(opts)
+}
+
+// readOnly adapts the snapshot's blocks to cbor.IpldBlockstore
+type readOnly struct {
+	blockGetter
+}
+
+func (readOnly) Put(ctx context.Context, block blocks.Block) error {
+	return errors.New("snapshot: blocks are read-only")
+}
+
+// readAMTCids reads the message CIDs in an AMT, which use the v0 AMT as in Lotus
+func readAMTCids(store adt.Store, root cid.Cid) ([]cid.Cid, error) {
+	array, err := adt.AsArray(store, root)
+	if err != nil {
+		return nil, err
+	}
+	var cids []cid.Cid
+	var cborCid cbg.CborCid
+	err = array.ForEach(&cborCid, func(i int64) error {
+		cids = append(cids, cid.Cid(cborCid))
+		return nil
+	})
+	return cids, err
+}
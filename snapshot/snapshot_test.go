@@ -0,0 +1,572 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/filecoin-project/go-address"
+	amt4 "github.com/filecoin-project/go-amt-ipld/v4"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	evm14 "github.com/filecoin-project/go-state-types/builtin/v14/evm"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/lotus/chain/actors"
+	lotusadt "github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filecoin-project/specs-actors/actors/util/adt"
+	"github.com/glifio/go-pools/abigen"
+	"github.com/glifio/go-pools/deploy"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Bytecode of the test contracts
+var (
+	// returns the storage slot of the call's selector
+	storageCode = common.FromHex("60003560e01c5460005260206000f3")
+	// returns its balance
+	balanceCode = common.FromHex("4760005260206000f3")
+)
+
+// nativeCallCode returns the bytecode of a contract that calls to
+func nativeCallCode(to common.Address) []byte {
+	code := common.FromHex("6000600060006000600073")
+	code = append(code, to.Bytes()...)
+	return append(code, common.FromHex("5af160005260206000f3")...)
+}
+
+func selector(sig string) common.Hash {
+	return common.BytesToHash(crypto.Keccak256([]byte(sig))[:4])
+}
+
+var (
+	testAgent    = common.HexToAddress("0xa9e1700000000000000000000000000000000001")
+	testMiner, _ = address.NewIDAddress(1234)
+	minerEthID   = common.HexToAddress("0xff000000000000000000000000000000000004d2")
+)
+
+type memBlocks map[cid.Cid]blocks.Block
+
+func (m memBlocks) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	block, ok := m[c]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return block, nil
+}
+
+func (m memBlocks) Put(ctx context.Context, block blocks.Block) error {
+	m[block.Cid()] = block
+	return nil
+}
+
+// testActor is an EVM actor of a test state tree
+type testActor struct {
+	addr    common.Address
+	code    []byte
+	balance int64
+	storage map[common.Hash]common.Hash
+}
+
+// testChain is a snapshot of the tipsets @10, @11, @13 and @14, where 12 is a
+// null round. The state produced @10 has IFIL supply 100 and the agent holds
+// 1000, and @11 and after, the supply is 200 and the agent holds 1500. @13 has a
+// message whose receipt @14 has a WriteOff event of the pool and an event of
+// IFIL.
+type testChain struct {
+	blocks memBlocks
+	store  cbor.IpldStore
+	roots  []cid.Cid
+	msg    cid.Cid
+	ids    map[common.Address]address.Address
+}
+
+func newTestChain(t *testing.T) *testChain {
+	ctx := context.Background()
+	bs := make(memBlocks)
+	tc := &testChain{blocks: bs, store: cbor.NewCborStore(bs)}
+
+	contracts := func(supply int64, balance int64) []testActor {
+		return []testActor{
+			{addr: deploy.ProtoMeta.IFIL, code: storageCode, storage: map[common.Hash]common.Hash{
+				selector("totalSupply()"):          common.BigToHash(big.NewInt(supply)),
+				selector("balanceOf(address)"):     common.BigToHash(big.NewInt(1)),
+				common.HexToHash("0xff00000000ff"): common.BigToHash(big.NewInt(2)),
+			}},
+			{addr: deploy.ProtoMeta.InfinityPool, code: storageCode, storage: map[common.Hash]common.Hash{
+				selector("totalAssets()"): common.BigToHash(big.NewInt(1000)),
+			}},
+			{addr: deploy.ProtoMeta.WFIL, code: nativeCallCode(minerEthID)},
+			{addr: testAgent, code: balanceCode, balance: balance},
+		}
+	}
+	stateA := tc.putState(t, contracts(100, 1000))
+	stateB := tc.putState(t, contracts(200, 1500))
+
+	store := adt.WrapStore(ctx, tc.store)
+	emptyAMT, err := adt.MakeEmptyArray(store).Root()
+	require.Nil(t, err)
+	noMessages, err := tc.store.Put(ctx, &types.MsgMeta{BlsMessages: emptyAMT, SecpkMessages: emptyAMT})
+	require.Nil(t, err)
+
+	msg := &types.Message{
+		To:         tc.delegated(t, deploy.ProtoMeta.InfinityPool),
+		From:       testMiner,
+		Value:      types.NewInt(0),
+		GasLimit:   1,
+		GasFeeCap:  types.NewInt(0),
+		GasPremium: types.NewInt(0),
+	}
+	tc.msg, err = tc.store.Put(ctx, msg)
+	require.Nil(t, err)
+	blsMessages := adt.MakeEmptyArray(store)
+	msgCid := cbg.CborCid(tc.msg)
+	require.Nil(t, blsMessages.Set(0, &msgCid))
+	blsRoot, err := blsMessages.Root()
+	require.Nil(t, err)
+	messages, err := tc.store.Put(ctx, &types.MsgMeta{BlsMessages: blsRoot, SecpkMessages: emptyAMT})
+	require.Nil(t, err)
+
+	poolABI, err := abigen.InfinityPoolMetaData.GetAbi()
+	require.Nil(t, err)
+	var data []byte
+	for _, v := range []int64{5, 3, 2} {
+		data = append(data, common.BigToHash(big.NewInt(v)).Bytes()...)
+	}
+	events := []types.Event{
+		{Emitter: tc.actorID(t, deploy.ProtoMeta.InfinityPool), Entries: []types.EventEntry{
+			{Key: "t1", Codec: cid.Raw, Value: poolABI.Events["WriteOff"].ID.Bytes()},
+			{Key: "t2", Codec: cid.Raw, Value: common.BigToHash(big.NewInt(7)).Bytes()},
+			{Key: "d", Codec: cid.Raw, Value: data},
+		}},
+		// Built-in actors' events aren't logs
+		{Emitter: 1234, Entries: []types.EventEntry{
+			{Key: "amount", Codec: cid.DagCBOR, Value: []byte{0x01}},
+		}},
+		{Emitter: tc.actorID(t, deploy.ProtoMeta.IFIL), Entries: []types.EventEntry{
+			{Key: "t1", Codec: cid.Raw, Value: common.HexToHash("0x01").Bytes()},
+			{Key: "d", Codec: cid.Raw, Value: []byte{}},
+		}},
+	}
+	eventsAMT, err := amt4.NewAMT(tc.store, amt4.UseTreeBitWidth(types.EventAMTBitwidth))
+	require.Nil(t, err)
+	for i := range events {
+		require.Nil(t, eventsAMT.Set(ctx, uint64(i), &events[i]))
+	}
+	eventsRoot, err := eventsAMT.Flush(ctx)
+	require.Nil(t, err)
+	receipts := adt.MakeEmptyArray(store)
+	receipt := types.NewMessageReceiptV1(0, nil, 1, &eventsRoot)
+	require.Nil(t, receipts.Set(0, &receipt))
+	receiptsRoot, err := receipts.Root()
+	require.Nil(t, err)
+
+	// The parents of @10 aren't in the snapshot
+	genesis, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.IDENTITY, MhLength: -1}.Sum([]byte("genesis"))
+	require.Nil(t, err)
+	parents := []cid.Cid{genesis}
+	for _, h := range []struct {
+		height   abi.ChainEpoch
+		state    cid.Cid
+		messages cid.Cid
+		receipts cid.Cid
+	}{
+		{10, stateA, noMessages, emptyAMT},
+		{11, stateA, noMessages, emptyAMT},
+		{13, stateB, messages, emptyAMT},
+		{14, stateB, noMessages, receiptsRoot},
+	} {
+		header := &types.BlockHeader{
+			Miner:                 testMiner,
+			Parents:               parents,
+			ParentWeight:          types.NewInt(0),
+			Height:                h.height,
+			ParentStateRoot:       h.state,
+			ParentMessageReceipts: h.receipts,
+			Messages:              h.messages,
+			Timestamp:             uint64(1000 + 30*h.height),
+			ParentBaseFee:         types.NewInt(100),
+		}
+		block, err := header.ToStorageBlock()
+		require.Nil(t, err)
+		require.Nil(t, bs.Put(ctx, block))
+		parents = []cid.Cid{block.Cid()}
+	}
+	tc.roots = parents
+	return tc
+}
+
+// putState writes a state tree with the init actor, the EVM actors, and a miner
+// f01234
+func (tc *testChain) putState(t *testing.T, contracts []testActor) cid.Cid {
+	ctx := context.Background()
+	tree, err := state.NewStateTree(tc.store, types.StateTreeVersion5)
+	require.Nil(t, err)
+
+	initState, err := init_.MakeState(lotusadt.WrapStore(ctx, tc.store), actorstypes.Version13, "test")
+	require.Nil(t, err)
+	ids := make(map[common.Address]address.Address)
+	for _, contract := range contracts {
+		ids[contract.addr], err = initState.MapAddressToNewID(tc.delegated(t, contract.addr))
+		require.Nil(t, err)
+	}
+	tc.ids = ids
+	initHead, err := tc.store.Put(ctx, initState.GetState())
+	require.Nil(t, err)
+	tc.setActor(t, tree, builtin.InitActorAddr, manifest.InitKey, initHead, 0, nil)
+
+	for _, contract := range contracts {
+		bytecode, err := putRaw(tc.blocks, contract.code)
+		require.Nil(t, err)
+		head, err := tc.store.Put(ctx, &evm14.State{
+			Bytecode:      bytecode,
+			BytecodeHash:  [32]byte(crypto.Keccak256(contract.code)),
+			ContractState: putKAMT(t, tc.blocks, contract.storage, false),
+			Nonce:         1,
+		})
+		require.Nil(t, err)
+		f4 := tc.delegated(t, contract.addr)
+		tc.setActor(t, tree, ids[contract.addr], manifest.EvmKey, head, contract.balance, &f4)
+	}
+
+	minerAddr, err := address.NewIDAddress(1234)
+	require.Nil(t, err)
+	tc.setActor(t, tree, minerAddr, manifest.MinerKey, initHead, 0, nil)
+
+	root, err := tree.Flush(ctx)
+	require.Nil(t, err)
+	return root
+}
+
+func (tc *testChain) setActor(t *testing.T, tree *state.StateTree, addr address.Address, key string, head cid.Cid, balance int64, delegated *address.Address) {
+	code, ok := actors.GetActorCodeID(actorstypes.Version13, key)
+	require.True(t, ok)
+	err := tree.SetActor(addr, &types.Actor{
+		Code:             code,
+		Head:             head,
+		Balance:          types.NewInt(uint64(balance)),
+		DelegatedAddress: delegated,
+	})
+	require.Nil(t, err)
+}
+
+func (tc *testChain) delegated(t *testing.T, addr common.Address) address.Address {
+	f4, err := ethtypes.EthAddress(addr).ToFilecoinAddress()
+	require.Nil(t, err)
+	return f4
+}
+
+func (tc *testChain) actorID(t *testing.T, addr common.Address) abi.ActorID {
+	id, err := address.IDFromAddress(tc.ids[addr])
+	require.Nil(t, err)
+	return abi.ActorID(id)
+}
+
+func (tc *testChain) load(t *testing.T) *Chain {
+	chain, err := Load(tc.blocks, tc.roots, deploy.ProtoMeta)
+	require.Nil(t, err)
+	return chain
+}
+
+func putRaw(bs memBlocks, data []byte) (cid.Cid, error) {
+	prefix := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.BLAKE2B_MIN + 31, MhLength: -1}
+	c, err := prefix.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	block, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, bs.Put(context.Background(), block)
+}
+
+// putKAMT writes the EVM storage KAMT of slots, with extensions over the bits the
+// keys of a node share, counted in levels rather than bits if levels is set
+func putKAMT(t *testing.T, bs memBlocks, slots map[common.Hash]common.Hash, levels bool) cid.Cid {
+	keys := make([]common.Hash, 0, len(slots))
+	for k := range slots {
+		keys = append(keys, k)
+	}
+	return putKAMTNode(t, bs, keys, slots, 0, levels)
+}
+
+func putKAMTNode(t *testing.T, bs memBlocks, keys []common.Hash, slots map[common.Hash]common.Hash, pos int, levels bool) cid.Cid {
+	groups := make(map[int][]common.Hash)
+	bitfield := new(big.Int)
+	for _, k := range keys {
+		idx := keyBits(k[:], pos, kamtBitWidth)
+		groups[idx] = append(groups[idx], k)
+		bitfield.SetBit(bitfield, idx, 1)
+	}
+	idxs := make([]int, 0, len(groups))
+	for idx := range groups {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+
+	buf := new(bytes.Buffer)
+	cw := cbg.NewCborWriter(buf)
+	require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajArray, 2))
+	require.Nil(t, cbg.WriteByteArray(cw, bitfield.Bytes()))
+	require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(idxs))))
+	for _, idx := range idxs {
+		group := groups[idx]
+		if len(group) == 1 {
+			k := group[0]
+			require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajArray, 1))
+			require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajArray, 2))
+			require.Nil(t, cbg.WriteByteArray(cw, new(big.Int).SetBytes(k[:]).Bytes()))
+			v := slots[k]
+			require.Nil(t, cbg.WriteByteArray(cw, new(big.Int).SetBytes(v[:]).Bytes()))
+			continue
+		}
+
+		// Skip the bits the keys share
+		next := pos + kamtBitWidth
+		skip := 0
+		for ; next+skip+kamtBitWidth <= 8*common.HashLength; skip += kamtBitWidth {
+			bits := keyBits(group[0][:], next+skip, kamtBitWidth)
+			shared := true
+			for _, k := range group[1:] {
+				shared = shared && keyBits(k[:], next+skip, kamtBitWidth) == bits
+			}
+			if !shared {
+				break
+			}
+		}
+		child := putKAMTNode(t, bs, group, slots, next+skip, levels)
+		if skip == 0 {
+			require.Nil(t, cbg.WriteCid(cw, child))
+			continue
+		}
+		path := make([]byte, (skip+7)/8)
+		for i := 0; i < skip; i++ {
+			if keyBits(group[0][:], next+i, 1) == 1 {
+				path[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		consumed := skip
+		if levels {
+			consumed = skip / kamtBitWidth
+		}
+		require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajArray, 2))
+		require.Nil(t, cbg.WriteCid(cw, child))
+		require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajArray, 2))
+		require.Nil(t, cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(consumed)))
+		require.Nil(t, cbg.WriteByteArray(cw, path))
+	}
+
+	prefix := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.BLAKE2B_MIN + 31, MhLength: -1}
+	c, err := prefix.Sum(buf.Bytes())
+	require.Nil(t, err)
+	block, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	require.Nil(t, err)
+	require.Nil(t, bs.Put(context.Background(), block))
+	return c
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t).load(t)
+
+	from, to := chain.Range()
+	assert.EqualValues(t, 10, from)
+	assert.EqualValues(t, 14, to)
+	head, err := chain.ChainHead(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 14, head.Height())
+
+	ts, err := chain.ChainGetTipSetByHeight(ctx, 12)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 11, ts.Height())
+	ts, err = chain.ChainGetTipSetAfterHeight(ctx, 12)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 13, ts.Height())
+	_, err = chain.ChainGetTipSetByHeight(ctx, 15)
+	assert.NotNil(t, err)
+	_, err = chain.ChainGetTipSetAfterHeight(ctx, 9)
+	assert.NotNil(t, err, "tipsets before the snapshot aren't in it")
+
+	msgs, err := chain.ChainGetMessagesInTipset(ctx, ts.Key())
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+	head, _ = chain.ChainHead(ctx)
+	receipts, err := chain.ChainGetParentReceipts(ctx, head.Key())
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 1)
+	assert.NotNil(t, receipts[0].EventsRoot)
+}
+
+func TestChainLogs(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestChain(t)
+	chain := tc.load(t)
+
+	txHash, err := chain.EthGetTransactionHashByCid(ctx, tc.msg)
+	assert.Nil(t, err)
+	ethHash, err := ethtypes.EthHashFromCid(tc.msg)
+	assert.Nil(t, err)
+	assert.Equal(t, common.Hash(ethHash), *txHash)
+	msgCid, err := chain.EthGetMessageCidByTransactionHash(ctx, *txHash)
+	assert.Nil(t, err)
+	assert.Nil(t, msgCid, "the transaction hashes are known once their logs are read")
+
+	logs, err := chain.ContractLogs(ctx, nil, 10, 13)
+	assert.Nil(t, err)
+	if assert.Len(t, logs, 2) {
+		assert.Equal(t, deploy.ProtoMeta.InfinityPool, logs[0].Address)
+		assert.Equal(t, deploy.ProtoMeta.IFIL, logs[1].Address)
+		assert.EqualValues(t, 13, logs[1].BlockNumber)
+		assert.EqualValues(t, 1, logs[1].Index)
+		assert.Equal(t, *txHash, logs[1].TxHash)
+	}
+	msgCid, err = chain.EthGetMessageCidByTransactionHash(ctx, *txHash)
+	assert.Nil(t, err)
+	assert.Equal(t, &tc.msg, msgCid)
+
+	logs, err = chain.ContractLogs(ctx, []common.Address{deploy.ProtoMeta.IFIL}, 13, 13)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 1)
+	_, err = chain.ContractLogs(ctx, nil, 14, 14)
+	assert.NotNil(t, err, "the receipts of the head aren't in the snapshot")
+
+	writeOffs, err := chain.PoolWriteOffs(ctx, 10, 13)
+	assert.Nil(t, err)
+	if assert.Len(t, writeOffs, 1) {
+		assert.EqualValues(t, 7, writeOffs[0].AgentID.Int64())
+		assert.EqualValues(t, 5, writeOffs[0].RecoveredFunds.Int64())
+		assert.EqualValues(t, 3, writeOffs[0].LostFunds.Int64())
+		assert.EqualValues(t, 2, writeOffs[0].InterestPaid.Int64())
+	}
+	writeOffs, err = chain.PoolWriteOffs(ctx, 10, 11)
+	assert.Nil(t, err)
+	assert.Empty(t, writeOffs)
+}
+
+func TestChainState(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestChain(t)
+	chain := tc.load(t)
+	ts, err := chain.ChainGetTipSetByHeight(ctx, 13)
+	require.Nil(t, err)
+
+	id, err := chain.StateLookupID(ctx, tc.delegated(t, testAgent), ts.Key())
+	assert.Nil(t, err)
+	assert.Equal(t, tc.ids[testAgent], id)
+	act, err := chain.StateGetActor(ctx, id, ts.Key())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1500, act.Balance.Int64())
+}
+
+func TestChainCalls(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestChain(t)
+	chain := tc.load(t)
+
+	// A block's calls run on the state it produced
+	supply, err := chain.IFILSupply(ctx, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 100, supply.Int64())
+	supply, err = chain.IFILSupply(ctx, big.NewInt(11))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 200, supply.Int64())
+	supply, err = chain.IFILSupply(ctx, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 200, supply.Int64())
+	_, err = chain.IFILSupply(ctx, big.NewInt(12))
+	assert.ErrorContains(t, err, "null round")
+	_, err = chain.IFILSupply(ctx, big.NewInt(14))
+	assert.NotNil(t, err, "the state produced by the head isn't in the snapshot")
+
+	assets, err := chain.PoolTotalAssets(ctx, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1000, assets.Int64())
+	borrowed, err := chain.PoolTotalBorrowed(ctx, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, borrowed.Int64(), "unset slots are zero")
+
+	liquid, err := chain.AgentLiquidAssets(ctx, testAgent, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1000, liquid.Int64())
+	liquid, err = chain.AgentLiquidAssets(ctx, testAgent, big.NewInt(13))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1500, liquid.Int64())
+
+	_, err = chain.WFILBalance(ctx, testAgent, big.NewInt(10))
+	assert.ErrorIs(t, err, ErrNativeCall)
+	_, err = chain.AgentLiquidationValue(ctx, testAgent, nil)
+	assert.ErrorIs(t, err, ErrNeedsNode)
+
+	// Masked IDs and delegated addresses are the same account
+	code, err := chain.CodeAt(ctx, deploy.ProtoMeta.IFIL, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, storageCode, code)
+	maskedID, err := ethtypes.EthAddressFromFilecoinAddress(tc.ids[deploy.ProtoMeta.IFIL])
+	require.Nil(t, err)
+	code, err = chain.CodeAt(ctx, common.Address(maskedID), big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, storageCode, code)
+	code, err = chain.CodeAt(ctx, minerEthID, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xfe}, code, "built-in actors have invalid code")
+}
+
+func TestReadStorage(t *testing.T) {
+	ctx := context.Background()
+	slots := map[common.Hash]common.Hash{
+		selector("totalSupply()"):  common.BigToHash(big.NewInt(1)),
+		selector("totalAssets()"):  common.BigToHash(big.NewInt(2)),
+		common.HexToHash("0x0400"): common.BigToHash(big.NewInt(3)),
+		common.HexToHash("0xff00000000000000000000000000000000000000000000000000000000000000"): common.BigToHash(big.NewInt(4)),
+	}
+	for _, levels := range []bool{false, true} {
+		bs := make(memBlocks)
+		root := putKAMT(t, bs, slots, levels)
+		for slot, want := range slots {
+			value, err := readStorage(ctx, bs, root, slot)
+			assert.Nil(t, err)
+			assert.Equal(t, want, value)
+		}
+		value, err := readStorage(ctx, bs, root, common.HexToHash("0x0401"))
+		assert.Nil(t, err)
+		assert.Equal(t, common.Hash{}, value)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tc := newTestChain(t)
+	path := filepath.Join(t.TempDir(), "snapshot.car")
+	car, err := carblockstore.OpenReadWrite(path, tc.roots)
+	require.Nil(t, err)
+	for _, block := range tc.blocks {
+		require.Nil(t, car.Put(context.Background(), block))
+	}
+	require.Nil(t, car.Finalize())
+
+	_, err = Open(path, 1)
+	assert.NotNil(t, err, "the chain must be one GLIF deployed on")
+	chain, err := Open(path, 314)
+	require.Nil(t, err)
+	defer chain.Close()
+	supply, err := chain.IFILSupply(context.Background(), big.NewInt(10))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 100, supply.Int64())
+}